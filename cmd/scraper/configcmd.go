@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runConfig runs the config subcommands
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "Usage: scraper config print [-config file] [flags]")
+		os.Exit(2)
+	}
+
+	// Accept the same flags as a scrape so their effect can be inspected
+	flags := flag.NewFlagSet("config print", flag.ExitOnError)
+	configFile := defineScrapeFlags(flags)
+	flags.Parse(args[1:])
+
+	layers := loadConfig(flags, *configFile, scrapeBindings)
+	if err := layers.Print(os.Stdout); err != nil {
+		fatal("Error printing configuration", err)
+	}
+}
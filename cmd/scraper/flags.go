@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// flagTarget is a configuration value set by a flag. An empty Value means
+// the flag's own value.
+type flagTarget struct {
+	Path  string
+	Value string
+}
+
+// flagBinding maps a command-line flag onto configuration values
+type flagBinding struct {
+	Name    string
+	Targets []flagTarget
+}
+
+// isBoolFlag reports whether f is a boolean flag such as -verbose
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// to binds a flag to the configuration value at path
+func to(name, path string) flagBinding {
+	return flagBinding{Name: name, Targets: []flagTarget{{Path: path}}}
+}
+
+// scrapeBindings maps the scrape flags onto the configuration. They are
+// applied in this order, so -log-level wins over -verbose.
+var scrapeBindings = []flagBinding{
+	to("input", "io.input_file"),
+	to("output", "io.output_file"),
+	to("format", "io.output_format"),
+	to("workers", "scraper.workers"),
+	to("rate-limit", "scraper.rate_limit"),
+	to("retries", "scraper.max_retries"),
+	to("retry-delay", "scraper.retry_delay"),
+	to("title-selector", "extraction.selectors.title"),
+	to("heading-selector", "extraction.selectors.heading"),
+	to("proxy", "proxies.enabled"),
+	to("browser", "browser.enabled"),
+	to("head-only", "scraper.head_only"),
+	{Name: "metrics-addr", Targets: []flagTarget{{Path: "metrics.address"}, {Path: "metrics.enabled", Value: "true"}}},
+	{Name: "cookies", Targets: []flagTarget{{Path: "cookies.import_file"}, {Path: "cookies.enabled", Value: "true"}}},
+	{Name: "save-cookies", Targets: []flagTarget{{Path: "cookies.export_file"}, {Path: "cookies.enabled", Value: "true"}}},
+	{Name: "verbose", Targets: []flagTarget{{Path: "logging.level", Value: "debug"}}},
+	to("log-level", "logging.level"),
+	to("log-format", "logging.format"),
+	to("log-file", "logging.file"),
+	to("report", "report.file"),
+	{Name: "no-progress", Targets: []flagTarget{{Path: "progress.enabled", Value: "false"}}},
+	{Name: "monitor", Targets: []flagTarget{{Path: "monitor.state_file"}, {Path: "monitor.enabled", Value: "true"}}},
+	to("changes", "monitor.changes_file"),
+	{Name: "warc", Targets: []flagTarget{{Path: "warc.dir"}, {Path: "warc.enabled", Value: "true"}}},
+	{Name: "webhook", Targets: []flagTarget{{Path: "webhook.url"}, {Path: "webhook.enabled", Value: "true"}}},
+	to("canonical", "canonical.enabled"),
+	to("near-duplicates", "near_duplicates.enabled"),
+	{Name: "queue", Targets: []flagTarget{{Path: "queue.path"}, {Path: "queue.backend", Value: "sqlite"}}},
+	{Name: "queue-listen", Targets: []flagTarget{{Path: "queue.listen"}, {Path: "queue.backend", Value: "http"}}},
+}
+
+// defineScrapeFlags defines the scrape flags on fs and returns the -config flag
+func defineScrapeFlags(fs *flag.FlagSet) *string {
+	configFile := fs.String("config", "", "Path to configuration file (YAML)")
+	fs.String("input", "", "File containing URLs to scrape (one per line)")
+	fs.String("output", "", "File to save results to (default results.json, or results.db with -format sqlite)")
+	fs.String("format", "json", "Output format (json, sqlite)")
+	fs.Int("workers", 3, "Number of concurrent workers")
+	fs.Duration("rate-limit", 1*time.Second, "Delay between requests")
+	fs.Int("retries", 3, "Maximum number of retries per URL")
+	fs.Duration("retry-delay", 2*time.Second, "Base delay between retries")
+	fs.String("title-selector", "title", "CSS selector for title extraction")
+	fs.String("heading-selector", "h1", "CSS selector for heading extraction")
+	fs.Bool("proxy", false, "Enable proxy support")
+	fs.Bool("browser", false, "Enable browser-based scraping")
+	fs.Bool("head-only", false, "Only record status and headers without downloading bodies (link checking)")
+	fs.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090)")
+	fs.String("cookies", "", "Cookie file to seed the session with (Netscape cookies.txt or JSON)")
+	fs.String("save-cookies", "", "File to save session cookies to at the end of the run")
+	fs.String("log-level", "", "Log level (debug, info, warn, error)")
+	fs.String("log-format", "", "Log format (text, json)")
+	fs.String("log-file", "", "File to write logs to instead of stderr")
+	fs.Bool("verbose", false, "Log every URL as it is processed (same as -log-level debug)")
+	fs.String("report", "", "File to write the JSON run report to")
+	fs.Bool("no-progress", false, "Disable live progress reporting")
+	fs.String("monitor", "", "State file to compare pages against the previous run with (enables monitoring)")
+	fs.String("changes", "", "File to write new, changed and removed pages to when monitoring")
+	fs.String("warc", "", "Directory to archive raw HTTP exchanges to as WARC files")
+	fs.String("webhook", "", "URL to POST results and the job complete callback to")
+	fs.Bool("canonical", false, "Fetch each page once, comparing URLs by their canonical form")
+	fs.Bool("near-duplicates", false, "Fingerprint page text and flag pages nearly identical to one already fetched")
+	fs.String("queue", "", "SQLite queue file to share the URLs with worker processes (this process coordinates)")
+	fs.String("queue-listen", "", "Address to serve the queue on for workers on other machines (e.g. :7070)")
+	return configFile
+}
+
+// loadConfig layers the defaults, the configuration file, SCRAPER_*
+// environment variables and the flags that were set explicitly on fs, and
+// validates the result
+func loadConfig(fs *flag.FlagSet, configFile string, bindings []flagBinding) *config.Layered {
+	layers := config.NewLayered()
+	if configFile != "" {
+		if err := layers.LoadFile(configFile); err != nil {
+			fatalConfig(err, configFile)
+		}
+	}
+	if err := layers.LoadEnv(os.Environ()); err != nil {
+		fatalConfig(err, "")
+	}
+	for _, warning := range layers.Warnings {
+		slog.Warn("Ignoring environment variable", "name", warning.Path, "problem", warning.Message)
+	}
+
+	// Only flags given on the command line override the other layers
+	set := make(map[string]*flag.Flag)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f
+	})
+	for _, binding := range bindings {
+		f, ok := set[binding.Name]
+		if !ok {
+			continue
+		}
+		for _, target := range binding.Targets {
+			value := target.Value
+			if value == "" {
+				value = f.Value.String()
+			} else if isBoolFlag(f) && f.Value.String() != "true" {
+				// A fixed value applies when the flag is on, not for -flag=false
+				continue
+			}
+			if err := layers.Set(target.Path, value, "flag -"+f.Name); err != nil {
+				fatal("Invalid flag", err, "flag", f.Name)
+			}
+		}
+	}
+
+	layers.DeriveDefaults()
+	if err := layers.Validate(); err != nil {
+		fatalConfig(err, configFile)
+	}
+	return layers
+}
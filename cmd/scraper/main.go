@@ -1,134 +1,106 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/williampepple1/concurrent-web-scraper/internal/config"
-	"github.com/williampepple1/concurrent-web-scraper/internal/io"
-	"github.com/williampepple1/concurrent-web-scraper/internal/worker"
-	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
 )
 
 func main() {
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "reextract":
+			runReextract(os.Args[2:])
+			return
+		case "schedule":
+			runSchedule(os.Args[2:])
+			return
+		case "worker":
+			runWorker(os.Args[2:])
+			return
+		}
+	}
+
 	// Define command-line flags
-	configFile := flag.String("config", "", "Path to configuration file (YAML)")
-	inputFile := flag.String("input", "", "File containing URLs to scrape (one per line)")
-	outputFile := flag.String("output", "results.json", "File to save results to")
-	numWorkers := flag.Int("workers", 3, "Number of concurrent workers")
-	rateLimitDelay := flag.Duration("rate-limit", 1*time.Second, "Delay between requests")
-	maxRetries := flag.Int("retries", 3, "Maximum number of retries per URL")
-	retryDelay := flag.Duration("retry-delay", 2*time.Second, "Base delay between retries")
-	titleSelector := flag.String("title-selector", "title", "CSS selector for title extraction")
-	headingSelector := flag.String("heading-selector", "h1", "CSS selector for heading extraction")
-	enableProxy := flag.Bool("proxy", false, "Enable proxy support")
-	enableBrowser := flag.Bool("browser", false, "Enable browser-based scraping")
+	configFile := defineScrapeFlags(flag.CommandLine)
 	flag.Parse()
 
-	fmt.Println("Concurrent Web Scraper Starting...")
-
 	// Seed the random number generator
 	rand.Seed(time.Now().UnixNano())
 
-	// Load configuration
-	var appConfig *config.AppConfig
+	// Load configuration: defaults, then the file, then SCRAPER_* variables, then flags
+	appConfig := loadConfig(flag.CommandLine, *configFile, scrapeBindings).Config
+
+	// Set up structured logging
+	closeLog, err := logging.Setup(&appConfig.Logging)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	defer closeLog()
+
+	slog.Info("Concurrent Web Scraper starting")
 	if *configFile != "" {
-		// Load from config file
-		var err error
-		appConfig, err = config.Load(*configFile)
-		if err != nil {
-			log.Fatalf("Error loading configuration: %v", err)
-		}
-		fmt.Printf("Loaded configuration from %s\n", *configFile)
+		slog.Info("Loaded configuration", "file", *configFile)
 	} else {
-		// Create default configuration
-		appConfig = config.CreateDefault(
-			*numWorkers,
-			*rateLimitDelay,
-			*retryDelay,
-			*maxRetries,
-			*inputFile,
-			*outputFile,
-			*titleSelector,
-			*headingSelector,
-			*enableProxy,
-			*enableBrowser,
-		)
-		fmt.Println("Using default configuration (no config file provided)")
+		slog.Info("Using default configuration (no config file provided)")
 	}
 
-	// Override config with command-line flags if provided
-	if *inputFile != "" {
-		appConfig.IO.InputFile = *inputFile
-	}
-	if *outputFile != "results.json" {
-		appConfig.IO.OutputFile = *outputFile
+	// Expose metrics for the duration of the run
+	if appConfig.Metrics.Enabled {
+		server, err := metrics.Serve(appConfig.Metrics.Address, appConfig.Metrics.Path)
+		if err != nil {
+			fatal("Error starting metrics endpoint", err, "address", appConfig.Metrics.Address)
+		}
+		defer server.Close()
+		slog.Info("Serving metrics", "address", appConfig.Metrics.Address, "path", appConfig.Metrics.Path)
 	}
 
-	// Get URLs to scrape
-	urlReader := io.NewURLReader(&appConfig.IO)
-	urls, err := urlReader.GetURLs()
+	summary, err := scrape(context.Background(), appConfig)
 	if err != nil {
-		log.Fatalf("Error reading URLs: %v", err)
+		fatal("Scrape failed", err)
 	}
+	summary.WriteSummary(os.Stdout)
+}
 
-	if len(urls) == 0 {
-		log.Fatal("No URLs to scrape")
+// fatal logs an error and exits
+func fatal(msg string, err error, attrs ...any) {
+	if err != nil {
+		attrs = append(attrs, "error", err)
 	}
+	slog.Error(msg, attrs...)
+	os.Exit(1)
+}
 
-	fmt.Printf("Preparing to scrape %d URLs with %d workers\n", len(urls), appConfig.Scraper.Workers)
-
-	// Create worker pool
-	pool := worker.NewPool(appConfig, urls)
-
-	// Start the worker pool
-	pool.Start()
-
-	// Add jobs to the pool
-	pool.AddJobs(urls)
-
-	// Collect results
-	var allResults []models.Result
-	successCount := 0
-	failureCount := 0
-
-	for result := range pool.Results {
-		allResults = append(allResults, result)
-
-		if result.Err != "" {
-			fmt.Printf("Error fetching %s: %s (after %d retries)\n", result.URL, result.Err, result.Retries)
-			failureCount++
-			continue
-		}
-
-		fmt.Printf("Successfully fetched %s in %v (retries: %d)\n", result.URL, result.Duration, result.Retries)
-		if len(result.Extracted) > 0 {
-			fmt.Println("Extracted data:")
-			for name, value := range result.Extracted {
-				fmt.Printf("  %s: %v\n", name, value)
-			}
-		}
-
-		if result.Screenshot != "" {
-			fmt.Printf("  Screenshot saved to: %s\n", result.Screenshot)
-		}
-
-		if result.ProxyUsed != "" {
-			fmt.Printf("  Proxy used: %s\n", result.ProxyUsed)
-		}
-
-		successCount++
+// fatalConfig logs every problem of an invalid configuration and exits
+func fatalConfig(err error, file string) {
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		fatal("Error loading configuration", err, "file", file)
 	}
-
-	// Save results to file
-	resultWriter := io.NewResultWriter(&appConfig.IO)
-	if err := resultWriter.SaveToFile(allResults); err != nil {
-		log.Fatalf("Error saving results to file: %v", err)
+	for _, p := range verr.Problems {
+		attrs := []any{"path", p.Path, "problem", p.Message}
+		if file != "" {
+			attrs = append(attrs, "file", file, "line", p.Line)
+		}
+		slog.Error("Invalid configuration", attrs...)
 	}
-
-	fmt.Printf("All URLs have been processed. Success: %d, Failures: %d\n", successCount, failureCount)
-	fmt.Printf("Results saved to %s\n", appConfig.IO.OutputFile)
+	os.Exit(1)
 }
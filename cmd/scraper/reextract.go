@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	stdio "io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/io"
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/internal/warc"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// reextractBindings maps the reextract flags onto the configuration
+var reextractBindings = []flagBinding{
+	to("output", "io.output_file"),
+	to("format", "io.output_format"),
+	to("title-selector", "extraction.selectors.title"),
+	to("heading-selector", "extraction.selectors.heading"),
+	to("report", "report.file"),
+	to("log-level", "logging.level"),
+	to("log-format", "logging.format"),
+	to("log-file", "logging.file"),
+}
+
+// runReextract runs the configured extraction again over stored pages,
+// read from results files or WARC archives, without touching the network
+func runReextract(args []string) {
+	flags := flag.NewFlagSet("reextract", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: scraper reextract [flags] results.json|archive.warc.gz [...]")
+		flags.PrintDefaults()
+	}
+	configFile := flags.String("config", "", "Path to configuration file (YAML) with the extraction rules")
+	flags.String("output", "", "File to save the re-extracted results to (default results.json, or results.db with -format sqlite)")
+	flags.String("format", "json", "Output format (json, sqlite)")
+	flags.String("title-selector", "title", "CSS selector for title extraction")
+	flags.String("heading-selector", "h1", "CSS selector for heading extraction")
+	flags.String("report", "", "File to write the JSON run report to")
+	flags.String("log-level", "", "Log level (debug, info, warn, error)")
+	flags.String("log-format", "", "Log format (text, json)")
+	flags.String("log-file", "", "File to write logs to instead of stderr")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Load configuration: defaults, then the file, then SCRAPER_* variables, then flags
+	appConfig := loadConfig(flags, *configFile, reextractBindings).Config
+
+	closeLog, err := logging.Setup(&appConfig.Logging)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	defer closeLog()
+
+	resultWriter := io.NewResultWriter(&appConfig.IO)
+	extraction := []*config.ExtractionConfig{&appConfig.Extraction}
+	for _, site := range appConfig.Sites {
+		if site.Extraction != nil {
+			extraction = append(extraction, site.Extraction)
+		}
+	}
+	if err := resultWriter.Open(extraction...); err != nil {
+		fatal("Error opening output", err, "file", appConfig.IO.OutputFile)
+	}
+	runReport := report.NewBuilder(appConfig)
+	offline, err := scraper.NewOfflineScraper(appConfig)
+	if err != nil {
+		fatal("Error resolving site profiles", err)
+	}
+
+	var allResults []models.Result
+	emit := func(result models.Result) {
+		allResults = append(allResults, result)
+		runReport.Observe(result)
+		if err := resultWriter.Write(result); err != nil {
+			slog.Error("Error writing result", "url", result.URL, "error", err)
+		}
+	}
+
+	for _, file := range flags.Args() {
+		before := len(allResults)
+		if err := reextractFile(file, offline, emit); err != nil {
+			fatal("Error reading stored pages", err, "file", file)
+		}
+		slog.Info("Re-extracted stored pages", "file", file, "pages", len(allResults)-before)
+	}
+
+	if err := resultWriter.SaveToFile(allResults); err != nil {
+		fatal("Error saving results to file", err, "file", appConfig.IO.OutputFile)
+	}
+
+	summary := runReport.Finish()
+	if appConfig.Report.File != "" {
+		if err := summary.WriteJSON(appConfig.Report.File); err != nil {
+			fatal("Error saving run report", err, "file", appConfig.Report.File)
+		}
+	}
+	slog.Info("All stored pages have been re-extracted", "pages", len(allResults), "output", appConfig.IO.OutputFile)
+	summary.WriteSummary(os.Stdout)
+}
+
+// reextractFile re-extracts the pages stored in a WARC archive or a results
+// file, telling them apart by content
+func reextractFile(filename string, offline *scraper.OfflineScraper, emit func(models.Result)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewReader(file)
+	start, _ := buffered.Peek(5)
+	if bytes.HasPrefix(start, []byte{0x1f, 0x8b}) || bytes.HasPrefix(start, []byte("WARC/")) {
+		return reextractWARC(buffered, offline, emit)
+	}
+	return reextractResults(buffered, offline, emit)
+}
+
+// reextractResults re-extracts results saved as a JSON array (the json
+// output format) or as newline-delimited JSON (the server and library sinks)
+func reextractResults(r *bufio.Reader, offline *scraper.OfflineScraper, emit func(models.Result)) error {
+	// Skip leading whitespace to see whether the results are in an array
+	for {
+		next, err := r.Peek(1)
+		if errors.Is(err, stdio.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !unicode.IsSpace(rune(next[0])) {
+			break
+		}
+		r.ReadByte()
+	}
+	next, _ := r.Peek(1)
+	decoder := json.NewDecoder(r)
+
+	if next[0] == '[' {
+		decoder.Token()
+		for decoder.More() {
+			var stored models.Result
+			if err := decoder.Decode(&stored); err != nil {
+				return err
+			}
+			emit(offline.FromResult(stored))
+		}
+		return nil
+	}
+
+	for {
+		var stored models.Result
+		err := decoder.Decode(&stored)
+		if errors.Is(err, stdio.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		emit(offline.FromResult(stored))
+	}
+}
+
+// reextractWARC re-extracts the pages archived in a WARC file. Every URL
+// yields one result from its best response: the last complete 200, or else
+// the last response. URLs whose best response is a redirect are skipped as
+// the target is archived under its own URL.
+func reextractWARC(r stdio.Reader, offline *scraper.OfflineScraper, emit func(models.Result)) error {
+	reader, err := warc.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	methods := make(map[string]string)
+	best := make(map[string]*warc.Record)
+	var order []string
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, stdio.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch record.Type {
+		case warc.TypeRequest:
+			methods[record.ID] = warc.Method(record)
+		case warc.TypeResponse:
+			// Leave out logins and other form submissions
+			if method, ok := methods[record.Header("WARC-Concurrent-To")]; ok && method != http.MethodGet {
+				continue
+			}
+			target := record.Header("WARC-Target-URI")
+			current, seen := best[target]
+			if !seen {
+				order = append(order, target)
+			}
+			if !seen || complete200(record) || !complete200(current) {
+				best[target] = record
+			}
+		}
+	}
+
+	for _, target := range order {
+		record := best[target]
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), nil)
+		if err != nil {
+			emit(models.Result{URL: target, Err: fmt.Sprintf("reading archived response: %v", err), ErrorClass: scraper.ClassParse, Timestamp: record.Date})
+			continue
+		}
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.Header.Get("Location") != "" {
+			slog.Debug("Skipping archived redirect", "url", target, "location", resp.Header.Get("Location"))
+			continue
+		}
+		emit(offline.FromResponse(target, resp, record.Date))
+	}
+	return nil
+}
+
+// complete200 reports whether an archived response is a 200 with its whole body
+func complete200(record *warc.Record) bool {
+	if record.Header("WARC-Truncated") != "" {
+		return false
+	}
+	statusLine, _, _ := bytes.Cut(record.Block, []byte("\r\n"))
+	fields := strings.Fields(string(statusLine))
+	return len(fields) >= 2 && fields[1] == "200"
+}
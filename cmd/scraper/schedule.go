@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scheduler"
+)
+
+// scheduleBindings maps the schedule flags onto the configuration
+var scheduleBindings = []flagBinding{
+	to("state", "schedule.state_file"),
+	to("jitter", "schedule.jitter"),
+	to("catch-up", "schedule.catch_up"),
+	{Name: "metrics-addr", Targets: []flagTarget{{Path: "metrics.address"}, {Path: "metrics.enabled", Value: "true"}}},
+	to("log-level", "logging.level"),
+	to("log-format", "logging.format"),
+	to("log-file", "logging.file"),
+}
+
+// runSchedule runs the scheduled jobs until it is interrupted
+func runSchedule(args []string) {
+	flags := flag.NewFlagSet("schedule", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to configuration file (YAML) with the schedule section")
+	flags.String("state", "schedule-state.json", "File to keep the last run of every job in")
+	flags.Duration("jitter", 0, "Random delay of up to this long before each run")
+	flags.String("catch-up", "skip", "Runs missed while the scheduler was down (skip, once, all)")
+	flags.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090)")
+	flags.String("log-level", "", "Log level (debug, info, warn, error)")
+	flags.String("log-format", "", "Log format (text, json)")
+	flags.String("log-file", "", "File to write logs to instead of stderr")
+	flags.Parse(args)
+
+	// Load configuration: defaults, then the file, then SCRAPER_* variables, then flags
+	appConfig := loadConfig(flags, *configFile, scheduleBindings).Config
+
+	// Set up structured logging
+	closeLog, err := logging.Setup(&appConfig.Logging)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	defer closeLog()
+
+	if len(appConfig.Schedule.Jobs) == 0 {
+		fatal("Nothing to schedule", fmt.Errorf("no jobs in schedule.jobs"), "file", *configFile)
+	}
+
+	// Load every job's configuration up front so mistakes show at startup
+	jobConfigs := make(map[string]*config.AppConfig)
+	for i := range appConfig.Schedule.Jobs {
+		job := &appConfig.Schedule.Jobs[i]
+		jobConfig, err := loadJobConfig(appConfig, job)
+		if err != nil {
+			fatalConfig(err, job.Config)
+		}
+		jobConfigs[job.Name] = jobConfig
+	}
+
+	if appConfig.Metrics.Enabled {
+		metricsServer, err := metrics.Serve(appConfig.Metrics.Address, appConfig.Metrics.Path)
+		if err != nil {
+			fatal("Error starting metrics endpoint", err, "address", appConfig.Metrics.Address)
+		}
+		defer metricsServer.Close()
+		slog.Info("Serving metrics", "address", appConfig.Metrics.Address, "path", appConfig.Metrics.Path)
+	}
+
+	// Cancel the running jobs and stop scheduling on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	run := func(ctx context.Context, job *config.ScheduledJob) (*report.Report, error) {
+		// Give every run its own copy, as the scrape may change it
+		jobConfig, err := jobConfigs[job.Name].Merge(nil)
+		if err != nil {
+			return nil, err
+		}
+		return scrape(ctx, jobConfig)
+	}
+
+	sched, err := scheduler.NewScheduler(&appConfig.Schedule, run)
+	if err != nil {
+		fatal("Error loading schedule state", err, "file", appConfig.Schedule.StateFile)
+	}
+
+	slog.Info("Scheduler started", "jobs", len(appConfig.Schedule.Jobs), "state", appConfig.Schedule.StateFile)
+	if err := sched.Start(ctx); err != nil {
+		fatal("Scheduler failed", err)
+	}
+	slog.Info("Scheduler stopped")
+}
+
+// loadJobConfig returns the configuration a job scrapes with: its own file
+// layered like the main configuration, or a copy of the schedule's, with the
+// job's input and output files applied
+func loadJobConfig(base *config.AppConfig, job *config.ScheduledJob) (*config.AppConfig, error) {
+	var jobConfig *config.AppConfig
+	if job.Config != "" {
+		layers := config.NewLayered()
+		if err := layers.LoadFile(job.Config); err != nil {
+			return nil, err
+		}
+		// Unknown variables were already reported when the main configuration was loaded
+		if err := layers.LoadEnv(os.Environ()); err != nil {
+			return nil, err
+		}
+		layers.DeriveDefaults()
+		if err := layers.Validate(); err != nil {
+			return nil, err
+		}
+		jobConfig = layers.Config
+	} else {
+		var err error
+		if jobConfig, err = base.Merge(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if job.InputFile != "" {
+		jobConfig.IO.InputFile = job.InputFile
+	}
+	if job.OutputFile != "" {
+		jobConfig.IO.OutputFile = job.OutputFile
+	}
+	return jobConfig, nil
+}
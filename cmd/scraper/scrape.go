@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/io"
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/monitor"
+	"github.com/williampepple1/concurrent-web-scraper/internal/progress"
+	"github.com/williampepple1/concurrent-web-scraper/internal/proxy"
+	"github.com/williampepple1/concurrent-web-scraper/internal/queue"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/internal/webhook"
+	"github.com/williampepple1/concurrent-web-scraper/internal/worker"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// scrape runs one scrape with the configuration: it reads the URLs, fetches
+// them with a worker pool and saves the results, cookies, changes and run
+// report. With a shared queue it is the coordinator: the URLs go to the queue
+// and the results are collected from it, whichever process fetched them.
+// Cancelling ctx stops it from fetching any more URLs.
+func scrape(ctx context.Context, appConfig *config.AppConfig) (*report.Report, error) {
+	// Get URLs to scrape
+	urlReader := io.NewURLReader(&appConfig.IO)
+	urls, err := urlReader.GetURLs()
+	if err != nil {
+		return nil, fmt.Errorf("reading URLs from %s: %w", appConfig.IO.InputFile, err)
+	}
+
+	if len(urls) == 0 {
+		return nil, errors.New("no URLs to scrape")
+	}
+
+	// Create worker pool, and fetch each canonical URL once
+	pool, err := worker.NewPool(appConfig, urls)
+	if err != nil {
+		return nil, err
+	}
+	pool.Context = ctx
+	if kept := pool.Canonical.Dedupe(urls); len(kept) < len(urls) {
+		slog.Info("Dropped duplicate URLs", "duplicates", len(urls)-len(kept))
+		urls = kept
+	}
+
+	slog.Info("Preparing to scrape", "urls", len(urls), "workers", appConfig.Scraper.Workers)
+
+	// Share the queue with worker processes, starting it afresh
+	var shared *queue.SQLite
+	if appConfig.Queue.Shared() {
+		if appConfig.Queue.Backend == "http" && appConfig.Queue.Listen == "" {
+			return nil, errors.New("the coordinator of an http queue needs queue.listen (or -queue-listen)")
+		}
+		shared, err = queue.NewSQLite(&appConfig.Queue)
+		if err != nil {
+			return nil, fmt.Errorf("opening queue %s: %w", appConfig.Queue.Path, err)
+		}
+		defer shared.Close()
+		if err := shared.Reset(ctx); err != nil {
+			return nil, fmt.Errorf("resetting queue %s: %w", appConfig.Queue.Path, err)
+		}
+		pool.Queue, pool.Sink = shared, shared
+		// Every process's results come through here, so duplicates are flagged here
+		pool.DeferFlags = true
+
+		// Queue the URLs before anything else is started, so a failure leaves nothing running
+		if err := shared.Push(ctx, urls...); err != nil {
+			return nil, fmt.Errorf("queueing URLs in %s: %w", appConfig.Queue.Path, err)
+		}
+		if err := shared.Seal(ctx); err != nil {
+			return nil, fmt.Errorf("sealing queue %s: %w", appConfig.Queue.Path, err)
+		}
+
+		// Serve the queue to workers on other machines
+		if appConfig.Queue.Backend == "http" {
+			server, err := queue.NewServer(shared, appConfig.Queue.Token).Serve(appConfig.Queue.Listen)
+			if err != nil {
+				return nil, fmt.Errorf("serving queue on %s: %w", appConfig.Queue.Listen, err)
+			}
+			// Keep answering for a while after the run, so idle workers find
+			// the queue drained rather than the server gone
+			defer func() {
+				if ctx.Err() == nil {
+					time.Sleep(2 * appConfig.Queue.PollInterval)
+				}
+				server.Close()
+			}()
+			if appConfig.Queue.Token == "" {
+				slog.Warn("Serving the queue without a token; anyone who can reach it can lease URLs and store results", "address", appConfig.Queue.Listen)
+			}
+			slog.Info("Serving the queue", "address", appConfig.Queue.Listen)
+		}
+	}
+
+	// Seed the session cookies
+	if pool.Cookies != nil && appConfig.Cookies.ImportFile != "" {
+		if err := pool.Cookies.Load(appConfig.Cookies.ImportFile); err != nil {
+			return nil, fmt.Errorf("loading cookies from %s: %w", appConfig.Cookies.ImportFile, err)
+		}
+		slog.Info("Loaded cookies", "file", appConfig.Cookies.ImportFile)
+	}
+
+	// Compare every page with the previous run
+	var changes *monitor.Monitor
+	if appConfig.Monitor.Enabled {
+		changes, err = monitor.NewMonitor(&appConfig.Monitor)
+		if err != nil {
+			return nil, fmt.Errorf("loading monitor state from %s: %w", appConfig.Monitor.StateFile, err)
+		}
+	}
+
+	// Open the output now for formats written as results arrive
+	resultWriter := io.NewResultWriter(&appConfig.IO)
+	extraction := []*config.ExtractionConfig{&appConfig.Extraction}
+	for _, site := range appConfig.Sites {
+		if site.Extraction != nil {
+			extraction = append(extraction, site.Extraction)
+		}
+	}
+	if err := resultWriter.Open(extraction...); err != nil {
+		return nil, fmt.Errorf("opening output %s: %w", appConfig.IO.OutputFile, err)
+	}
+
+	// Report progress while the run is going
+	var reporter *progress.Reporter
+	if appConfig.Progress.Enabled {
+		reporter = progress.NewReporter(len(urls), os.Stdout, appConfig.Progress.Interval)
+		// Redrawing in place would overwrite log lines on the same terminal
+		if logging.ToTerminal(&appConfig.Logging) {
+			reporter.Redraw = false
+		}
+		reporter.Start()
+	}
+
+	// Deliver results to the webhook as they come in
+	var deliverer *webhook.Deliverer
+	if appConfig.Webhook.Enabled && appConfig.Webhook.URL != "" {
+		deliverer = webhook.NewDeliverer(ctx, &appConfig.Webhook, "")
+		defer deliverer.Close()
+	}
+
+	// Start the worker pool
+	runReport := report.NewBuilder(appConfig)
+	pool.Start()
+
+	// Add jobs to the pool, or collect the results from the shared queue
+	var results <-chan models.Result = pool.Results
+	if shared != nil {
+		slog.Info("Queued URLs for the workers", "queue", appConfig.Queue.Path, "urls", len(urls), "local_workers", appConfig.Scraper.Workers)
+		results = shared.Collect(ctx)
+	} else {
+		pool.AddJobs(urls)
+	}
+
+	// Collect results
+	var allResults []models.Result
+	successCount := 0
+	failureCount := 0
+	skippedCount := 0
+
+	for result := range results {
+		if shared != nil {
+			pool.Flag(&result)
+		}
+		runReport.Observe(result)
+		if reporter != nil {
+			reporter.Observe(result)
+		}
+
+		// Near-duplicates still count towards the progress and report, but aren't saved
+		if appConfig.NearDup.Skip && result.NearDuplicateOf != "" {
+			slog.Debug("Skipping near-duplicate", "url", result.URL, "near_duplicate_of", result.NearDuplicateOf, "similarity", result.Similarity)
+			if changes != nil {
+				changes.Skip(result.URL)
+			}
+			skippedCount++
+			successCount++
+			continue
+		}
+		allResults = append(allResults, result)
+		if deliverer != nil {
+			deliverer.Send(result)
+		}
+		if err := resultWriter.Write(result); err != nil {
+			slog.Error("Error writing result", "url", result.URL, "error", err)
+		}
+		if changes != nil {
+			if change := changes.Observe(result); change != nil {
+				slog.Debug("Page changed", "url", change.URL, "change", change.Type, "fields", len(change.Fields))
+			}
+		}
+
+		if result.Err != "" {
+			slog.Debug("Error fetching URL",
+				"url", result.URL,
+				"error", result.Err,
+				"retries", result.Retries,
+				"status", result.StatusCode,
+				"duration", result.Duration,
+			)
+			failureCount++
+			continue
+		}
+
+		attrs := []any{
+			"url", result.URL,
+			"status", result.StatusCode,
+			"retries", result.Retries,
+			"duration", result.Duration,
+		}
+		if len(result.Extracted) > 0 {
+			attrs = append(attrs, "extracted", result.Extracted)
+		}
+		if result.Screenshot != "" {
+			attrs = append(attrs, "screenshot", result.Screenshot)
+		}
+		if result.ProxyUsed != "" {
+			attrs = append(attrs, "proxy", proxy.Redacted(result.ProxyUsed))
+		}
+		slog.Debug("Fetched URL", attrs...)
+
+		successCount++
+	}
+
+	if reporter != nil {
+		reporter.Stop()
+	}
+
+	// Let the local workers see the queue drained before it is closed
+	if shared != nil {
+		pool.WaitGroup.Wait()
+	}
+
+	// Save results to file
+	if err := resultWriter.SaveToFile(allResults); err != nil {
+		return nil, fmt.Errorf("saving results to %s: %w", appConfig.IO.OutputFile, err)
+	}
+
+	// Save session cookies for the next run
+	if pool.Cookies != nil && appConfig.Cookies.ExportFile != "" {
+		if err := pool.Cookies.Save(appConfig.Cookies.ExportFile); err != nil {
+			return nil, fmt.Errorf("saving cookies to %s: %w", appConfig.Cookies.ExportFile, err)
+		}
+		slog.Info("Cookies saved", "file", appConfig.Cookies.ExportFile)
+	}
+
+	// Record what changed since the previous run; a cancelled run did not
+	// fetch every page, so it would report the rest as removed
+	if changes != nil && ctx.Err() != nil {
+		slog.Warn("Run cancelled, monitor state left unchanged", "file", appConfig.Monitor.StateFile)
+	} else if changes != nil {
+		found := changes.Finish()
+		if err := monitor.WriteChanges(appConfig.Monitor.ChangesFile, found); err != nil {
+			return nil, fmt.Errorf("saving changes to %s: %w", appConfig.Monitor.ChangesFile, err)
+		}
+		if err := changes.Save(); err != nil {
+			return nil, fmt.Errorf("saving monitor state to %s: %w", appConfig.Monitor.StateFile, err)
+		}
+		counts := make(map[string]int)
+		for _, change := range found {
+			counts[change.Type]++
+		}
+		slog.Info("Changes since the previous run",
+			"new", counts[monitor.ChangeNew],
+			"changed", counts[monitor.ChangeChanged],
+			"removed", counts[monitor.ChangeRemoved],
+			"file", appConfig.Monitor.ChangesFile,
+		)
+	}
+
+	// Write the run report
+	summary := runReport.Finish()
+	if deliverer != nil {
+		status := "completed"
+		if ctx.Err() != nil {
+			status = "cancelled"
+		}
+		deliverer.Complete(status, summary)
+	}
+	if appConfig.Report.File != "" {
+		if err := summary.WriteJSON(appConfig.Report.File); err != nil {
+			return nil, fmt.Errorf("saving run report to %s: %w", appConfig.Report.File, err)
+		}
+		slog.Info("Run report saved", "file", appConfig.Report.File)
+	}
+
+	attrs := []any{
+		"success", successCount,
+		"failures", failureCount,
+		"output", appConfig.IO.OutputFile,
+	}
+	if appConfig.NearDup.Skip {
+		attrs = append(attrs, "near_duplicates_skipped", skippedCount)
+	}
+	slog.Info("All URLs have been processed", attrs...)
+	return summary, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/server"
+)
+
+// serveBindings maps the serve flags onto the configuration
+var serveBindings = []flagBinding{
+	to("addr", "server.address"),
+	to("max-concurrency", "server.max_concurrency"),
+	{Name: "metrics-addr", Targets: []flagTarget{{Path: "metrics.address"}, {Path: "metrics.enabled", Value: "true"}}},
+	to("log-level", "logging.level"),
+	to("log-format", "logging.format"),
+	to("log-file", "logging.file"),
+}
+
+// runServe runs the REST API server until it is interrupted
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to configuration file (YAML) used as the base for every job")
+	flags.String("addr", ":8080", "Address to serve the API on")
+	flags.Int("max-concurrency", 10, "Maximum concurrent fetches shared by all jobs")
+	flags.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090)")
+	flags.String("log-level", "", "Log level (debug, info, warn, error)")
+	flags.String("log-format", "", "Log format (text, json)")
+	flags.String("log-file", "", "File to write logs to instead of stderr")
+	flags.Parse(args)
+
+	// Load configuration: defaults, then the file, then SCRAPER_* variables, then flags
+	appConfig := loadConfig(flags, *configFile, serveBindings).Config
+
+	// Set up structured logging
+	closeLog, err := logging.Setup(&appConfig.Logging)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	defer closeLog()
+
+	if appConfig.Metrics.Enabled {
+		metricsServer, err := metrics.Serve(appConfig.Metrics.Address, appConfig.Metrics.Path)
+		if err != nil {
+			fatal("Error starting metrics endpoint", err, "address", appConfig.Metrics.Address)
+		}
+		defer metricsServer.Close()
+		slog.Info("Serving metrics", "address", appConfig.Metrics.Address, "path", appConfig.Metrics.Path)
+	}
+
+	// Stop accepting jobs and cancel the running ones on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	api := server.NewServer(appConfig)
+	if err := api.ListenAndServe(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("API server failed", err, "address", appConfig.Server.Address)
+	}
+	slog.Info("API server stopped")
+}
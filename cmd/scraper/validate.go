@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// runValidate checks configuration files and exits with status 1 if any is
+// invalid, printing one line per problem
+func runValidate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: scraper validate config.yaml [more.yaml ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, file := range flags.Args() {
+		_, err := config.Load(file)
+		if err == nil {
+			fmt.Printf("%s: OK\n", file)
+			continue
+		}
+		failed = true
+
+		var verr *config.ValidationError
+		if !errors.As(err, &verr) {
+			fmt.Printf("%s: %v\n", file, err)
+			continue
+		}
+		for _, p := range verr.Problems {
+			if p.Path == "" {
+				fmt.Printf("%s:%d: %s\n", file, p.Line, p.Message)
+				continue
+			}
+			fmt.Printf("%s:%d: %s: %s\n", file, p.Line, p.Path, p.Message)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/logging"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/queue"
+	"github.com/williampepple1/concurrent-web-scraper/internal/worker"
+)
+
+// workerBindings maps the worker flags onto the configuration
+var workerBindings = []flagBinding{
+	{Name: "queue", Targets: []flagTarget{{Path: "queue.path"}, {Path: "queue.backend", Value: "sqlite"}}},
+	{Name: "queue-url", Targets: []flagTarget{{Path: "queue.url"}, {Path: "queue.backend", Value: "http"}}},
+	to("workers", "scraper.workers"),
+	to("rate-limit", "scraper.rate_limit"),
+	to("browser", "browser.enabled"),
+	{Name: "cookies", Targets: []flagTarget{{Path: "cookies.import_file"}, {Path: "cookies.enabled", Value: "true"}}},
+	{Name: "metrics-addr", Targets: []flagTarget{{Path: "metrics.address"}, {Path: "metrics.enabled", Value: "true"}}},
+	{Name: "warc", Targets: []flagTarget{{Path: "warc.dir"}, {Path: "warc.enabled", Value: "true"}}},
+	to("log-level", "logging.level"),
+	to("log-format", "logging.format"),
+	to("log-file", "logging.file"),
+}
+
+// runWorker fetches URLs from a shared queue until the coordinator's queue
+// is drained or the worker is interrupted
+func runWorker(args []string) {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to configuration file (YAML) with the scraping settings")
+	flags.String("queue", "", "SQLite queue file shared with the coordinator")
+	flags.String("queue-url", "", "URL of the queue served by a coordinator on another machine (e.g. http://coordinator:7070)")
+	flags.Int("workers", 3, "Number of concurrent workers in this process")
+	flags.Duration("rate-limit", 1*time.Second, "Delay between requests")
+	flags.Bool("browser", false, "Enable browser-based scraping")
+	flags.String("cookies", "", "Cookie file to seed the session with (Netscape cookies.txt or JSON)")
+	flags.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090)")
+	flags.String("warc", "", "Directory to archive raw HTTP exchanges to as WARC files")
+	flags.String("log-level", "", "Log level (debug, info, warn, error)")
+	flags.String("log-format", "", "Log format (text, json)")
+	flags.String("log-file", "", "File to write logs to instead of stderr")
+	flags.Parse(args)
+
+	// Load configuration: defaults, then the file, then SCRAPER_* variables, then flags
+	appConfig := loadConfig(flags, *configFile, workerBindings).Config
+
+	// Set up structured logging
+	closeLog, err := logging.Setup(&appConfig.Logging)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	defer closeLog()
+
+	if !appConfig.Queue.Shared() {
+		fatal("Nothing to work on", errors.New("a worker needs a shared queue: pass -queue or -queue-url, or set queue.backend to sqlite or http"))
+	}
+	if appConfig.Queue.Backend == "http" && appConfig.Queue.URL == "" {
+		fatal("Nothing to work on", errors.New("a worker of an http queue needs queue.url (or -queue-url)"))
+	}
+	if appConfig.Scraper.Workers <= 0 {
+		fatal("Nothing to work with", errors.New("scraper.workers must be greater than zero"))
+	}
+
+	if appConfig.Metrics.Enabled {
+		metricsServer, err := metrics.Serve(appConfig.Metrics.Address, appConfig.Metrics.Path)
+		if err != nil {
+			fatal("Error starting metrics endpoint", err, "address", appConfig.Metrics.Address)
+		}
+		defer metricsServer.Close()
+		slog.Info("Serving metrics", "address", appConfig.Metrics.Address, "path", appConfig.Metrics.Path)
+	}
+
+	// Stop leasing on interrupt; the fetches in progress still finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Take the URLs from the SQLite file on this machine, or from the coordinator
+	var shared interface {
+		queue.Queue
+		queue.Sink
+	}
+	name, location := "", appConfig.Queue.Path
+	if appConfig.Queue.Backend == "http" {
+		remote := queue.NewHTTP(&appConfig.Queue)
+		shared, name, location = remote, remote.Worker, appConfig.Queue.URL
+	} else {
+		local, err := queue.NewSQLite(&appConfig.Queue)
+		if err != nil {
+			fatal("Error opening queue", err, "queue", appConfig.Queue.Path)
+		}
+		defer local.Close()
+		shared, name = local, local.Worker
+	}
+
+	pool, err := worker.NewPool(appConfig, nil)
+	if err != nil {
+		fatal("Error creating worker pool", err)
+	}
+	pool.Context = ctx
+	pool.Queue, pool.Sink = shared, shared
+	pool.DeferFlags = true // The coordinator flags duplicates across every worker's results
+
+	// Seed the session cookies
+	if pool.Cookies != nil && appConfig.Cookies.ImportFile != "" {
+		if err := pool.Cookies.Load(appConfig.Cookies.ImportFile); err != nil {
+			fatal("Error loading cookies", err, "file", appConfig.Cookies.ImportFile)
+		}
+	}
+
+	slog.Info("Worker started", "queue", location, "worker", name, "workers", appConfig.Scraper.Workers)
+	pool.Start()
+
+	// The results go to the queue; the channel closes once every worker is done
+	for range pool.Results {
+	}
+
+	if ctx.Err() != nil {
+		slog.Info("Worker interrupted")
+		return
+	}
+	slog.Info("Queue drained, worker finished")
+}
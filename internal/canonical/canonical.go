@@ -0,0 +1,171 @@
+// Package canonical rewrites URLs into a canonical form so that the aliases
+// of a page compare equal
+package canonical
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Canonicalizer applies the configured rules to URLs. When canonicalization
+// is disabled every URL is left as it is.
+type Canonicalizer struct {
+	Config *config.CanonicalConfig
+}
+
+// NewCanonicalizer creates a canonicalizer
+func NewCanonicalizer(cfg *config.CanonicalConfig) *Canonicalizer {
+	return &Canonicalizer{Config: cfg}
+}
+
+// Canonicalize returns the canonical form of the URL, or the URL unchanged
+// if canonicalization is disabled or it can't be parsed as an absolute URL
+func (c *Canonicalizer) Canonicalize(raw string) string {
+	if !c.Config.Enabled {
+		return raw
+	}
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return raw
+	}
+
+	if c.Config.LowercaseHost {
+		u.Host = strings.ToLower(u.Host)
+	}
+	// The default port is the one of the scheme as given, so drop it before
+	// rewriting the scheme
+	if c.Config.DropDefaultPort {
+		if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = u.Hostname()
+			if strings.Contains(u.Host, ":") {
+				u.Host = "[" + u.Host + "]" // IPv6 literal
+			}
+		}
+	}
+	switch c.Config.Scheme {
+	case "http", "https":
+		if u.Scheme == "http" || u.Scheme == "https" {
+			u.Scheme = c.Config.Scheme
+		}
+	}
+	if c.Config.DropFragment {
+		u.Fragment, u.RawFragment = "", ""
+	}
+
+	// An empty path is the root; otherwise apply the trailing slash rule
+	switch {
+	case u.Path == "":
+		u.Path, u.RawPath = "/", ""
+	case c.Config.TrailingSlash == "remove" && u.Path != "/" && strings.HasSuffix(u.Path, "/"):
+		u.Path = strings.TrimRight(u.Path, "/")
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+		if u.Path == "" {
+			u.Path, u.RawPath = "/", ""
+		}
+	case c.Config.TrailingSlash == "add" && !strings.HasSuffix(u.Path, "/") && !strings.Contains(path.Base(u.Path), "."):
+		// Paths ending in a file name such as page.html keep their form
+		u.Path += "/"
+		if u.RawPath != "" {
+			u.RawPath += "/"
+		}
+	}
+
+	u.RawQuery = c.query(u.RawQuery)
+	u.ForceQuery = false
+	return u.String()
+}
+
+// query removes the stripped parameters and sorts the rest, keeping the
+// original encoding of each parameter
+func (c *Canonicalizer) query(raw string) string {
+	if raw == "" || (len(c.Config.StripParams) == 0 && !c.Config.SortQuery) {
+		return raw
+	}
+
+	var params []string
+	for _, param := range strings.Split(raw, "&") {
+		if param == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		if c.stripped(name) {
+			continue
+		}
+		params = append(params, param)
+	}
+	if c.Config.SortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			ni, _, _ := strings.Cut(params[i], "=")
+			nj, _, _ := strings.Cut(params[j], "=")
+			return ni < nj
+		})
+	}
+	return strings.Join(params, "&")
+}
+
+// stripped reports whether a query parameter is removed
+func (c *Canonicalizer) stripped(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range c.Config.StripParams {
+		if ok, _ := path.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dedupe drops the URLs whose canonical form appeared earlier in the list,
+// keeping the first of each as it was given. It returns the list unchanged
+// unless canonicalization and de-duplication are enabled.
+func (c *Canonicalizer) Dedupe(urls []string) []string {
+	if !c.Config.Enabled || !c.Config.Deduplicate {
+		return urls
+	}
+	seen := make(map[string]bool, len(urls))
+	kept := make([]string, 0, len(urls))
+	for _, raw := range urls {
+		canonical := c.Canonicalize(raw)
+		if seen[canonical] {
+			continue
+		}
+		seen[canonical] = true
+		kept = append(kept, raw)
+	}
+	return kept
+}
+
+// FromPage returns the canonical form of the page's <link rel="canonical">,
+// resolved against its URL, or "" if the page has none
+func (c *Canonicalizer) FromPage(result models.Result) string {
+	if result.Content == "" || content.Detect(result.ContentType, []byte(result.Content)) != content.HTML {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(result.Content))
+	if err != nil {
+		return ""
+	}
+	href, ok := doc.Find(`link[rel~="canonical" i][href]`).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return ""
+	}
+
+	base, err := url.Parse(result.URL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	return c.Canonicalize(base.ResolveReference(ref).String())
+}
@@ -0,0 +1,142 @@
+package canonical
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// defaults returns the default canonical rules, enabled
+func defaults() config.CanonicalConfig {
+	cfg := config.Defaults().Canonical
+	cfg.Enabled = true
+	return cfg
+}
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*config.CanonicalConfig)
+		in     string
+		want   string
+	}{
+		{name: "lowercases the host", in: "HTTP://Example.COM/Path", want: "http://example.com/Path"},
+		{name: "drops the fragment", in: "https://example.com/a#top", want: "https://example.com/a"},
+		{name: "empty path is the root", in: "https://example.com", want: "https://example.com/"},
+		{name: "drops the http default port", in: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "drops the https default port", in: "https://example.com:443/a", want: "https://example.com/a"},
+		{name: "keeps other ports", in: "http://example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "keeps a port that is another scheme's default", in: "http://example.com:443/a", want: "http://example.com:443/a"},
+		{name: "drops the default port of an IPv6 host", in: "http://[::1]:80/a", want: "http://[::1]/a"},
+		{name: "strips tracking parameters", in: "https://example.com/a?utm_source=x&id=1&gclid=2", want: "https://example.com/a?id=1"},
+		{name: "strips encoded parameter names", in: "https://example.com/a?utm%5Fsource=x&id=1", want: "https://example.com/a?id=1"},
+		{name: "sorts the query keeping its encoding", in: "https://example.com/a?b=2&a=%20x&c", want: "https://example.com/a?a=%20x&b=2&c"},
+		{name: "drops an empty query", in: "https://example.com/a?utm_medium=y", want: "https://example.com/a"},
+		{name: "keeps a trailing slash", in: "https://example.com/a/", want: "https://example.com/a/"},
+		{
+			name:   "removes a trailing slash",
+			modify: func(c *config.CanonicalConfig) { c.TrailingSlash = "remove" },
+			in:     "https://example.com/a//", want: "https://example.com/a",
+		},
+		{
+			name:   "adds a trailing slash",
+			modify: func(c *config.CanonicalConfig) { c.TrailingSlash = "add" },
+			in:     "https://example.com/a", want: "https://example.com/a/",
+		},
+		{
+			name:   "adds no trailing slash to a file name",
+			modify: func(c *config.CanonicalConfig) { c.TrailingSlash = "add" },
+			in:     "https://example.com/page.html", want: "https://example.com/page.html",
+		},
+		{
+			name:   "rewrites the scheme",
+			modify: func(c *config.CanonicalConfig) { c.Scheme = "https" },
+			in:     "http://example.com/a", want: "https://example.com/a",
+		},
+		{
+			name:   "drops the default port before rewriting the scheme",
+			modify: func(c *config.CanonicalConfig) { c.Scheme = "https" },
+			in:     "http://example.com:80/a", want: "https://example.com/a",
+		},
+		{
+			name:   "keeps the port of the original scheme",
+			modify: func(c *config.CanonicalConfig) { c.Scheme = "http" },
+			in:     "https://example.com:80/a", want: "http://example.com:80/a",
+		},
+		{
+			name:   "leaves other schemes alone",
+			modify: func(c *config.CanonicalConfig) { c.Scheme = "https" },
+			in:     "ftp://example.com/a", want: "ftp://example.com/a",
+		},
+		{
+			name:   "disabled",
+			modify: func(c *config.CanonicalConfig) { c.Enabled = false },
+			in:     "HTTP://Example.COM:80/a?utm_source=x#top", want: "HTTP://Example.COM:80/a?utm_source=x#top",
+		},
+		{name: "relative URL is left alone", in: "/a?b=1#c", want: "/a?b=1#c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults()
+			if tt.modify != nil {
+				tt.modify(&cfg)
+			}
+			if got := NewCanonicalizer(&cfg).Canonicalize(tt.in); got != tt.want {
+				t.Errorf("Canonicalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	cfg := defaults()
+	c := NewCanonicalizer(&cfg)
+
+	urls := []string{
+		"https://Example.com/a?utm_source=x",
+		"https://example.com/a",
+		"https://example.com:443/a#top",
+		"https://example.com/b",
+	}
+	want := []string{"https://Example.com/a?utm_source=x", "https://example.com/b"}
+	if got := c.Dedupe(urls); !reflect.DeepEqual(got, want) {
+		t.Errorf("Dedupe = %q, want %q", got, want)
+	}
+
+	cfg.Deduplicate = false
+	if got := c.Dedupe(urls); !reflect.DeepEqual(got, urls) {
+		t.Errorf("Dedupe without deduplicate = %q, want the URLs unchanged", got)
+	}
+}
+
+func TestFromPage(t *testing.T) {
+	cfg := defaults()
+	c := NewCanonicalizer(&cfg)
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"absolute", `<html><head><link rel="canonical" href="https://Example.com/a?utm_source=x"></head></html>`, "https://example.com/a"},
+		{"relative", `<html><head><link rel="Canonical" href="../a"></head></html>`, "https://example.com/a"},
+		{"among other relations", `<html><head><link rel="alternate canonical" href="/b"></head></html>`, "https://example.com/b"},
+		{"none", `<html><head><link rel="alternate" href="/b"></head></html>`, ""},
+		{"empty", `<html><head><link rel="canonical" href=" "></head></html>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := models.Result{URL: "https://example.com/x/page", ContentType: "text/html", Content: tt.content}
+			if got := c.FromPage(result); got != tt.want {
+				t.Errorf("FromPage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	text := models.Result{URL: "https://example.com/a.txt", ContentType: "text/plain", Content: `<link rel="canonical" href="/b">`}
+	if got := c.FromPage(text); got != "" {
+		t.Errorf("FromPage of a text file = %q, want none", got)
+	}
+}
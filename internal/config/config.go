@@ -1,7 +1,11 @@
 package config
 
 import (
-	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,6 +18,27 @@ type AppConfig struct {
 	Extraction ExtractionConfig `yaml:"extraction"`
 	Proxies    ProxyConfig      `yaml:"proxies"`
 	Browser    BrowserConfig    `yaml:"browser"`
+	Cookies    CookieConfig     `yaml:"cookies"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Content    ContentConfig    `yaml:"content"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Progress   ProgressConfig   `yaml:"progress"`
+	Report     ReportConfig     `yaml:"report"`
+	Server     ServerConfig     `yaml:"server"`
+	Webhook    WebhookConfig    `yaml:"webhook"`
+	Middleware MiddlewareConfig `yaml:"middleware"`
+	Sites      []SiteConfig     `yaml:"sites"`
+	Hybrid     HybridConfig     `yaml:"hybrid"`
+	WARC       WARCConfig       `yaml:"warc"`
+	Monitor    MonitorConfig    `yaml:"monitor"`
+	Schedule   ScheduleConfig   `yaml:"schedule"`
+	Queue      QueueConfig      `yaml:"queue"`
+	Canonical  CanonicalConfig  `yaml:"canonical"`
+	NearDup    NearDupConfig    `yaml:"near_duplicates"`
+
+	// lines maps YAML paths to their line in the configuration file
+	lines map[string]int
 }
 
 // ScraperConfig holds the scraper configuration
@@ -24,6 +49,7 @@ type ScraperConfig struct {
 	RetryDelay time.Duration `yaml:"retry_delay"`
 	Timeout    time.Duration `yaml:"timeout"`
 	UserAgents []string      `yaml:"user_agents,omitempty"`
+	HeadOnly   bool          `yaml:"head_only"`
 }
 
 // IOConfig holds the input/output configuration
@@ -57,28 +83,285 @@ type BrowserConfig struct {
 	Headless      bool          `yaml:"headless"`
 	UserAgent     string        `yaml:"user_agent"`
 	WaitTime      time.Duration `yaml:"wait_time"`
+	WaitFor       string        `yaml:"wait_for"` // CSS selector to wait for before capturing the page
 	Screenshot    bool          `yaml:"screenshot"`
 	ScreenshotDir string        `yaml:"screenshot_dir"`
 }
 
-// Load loads the configuration from a YAML file
-func Load(filename string) (*AppConfig, error) {
-	data, err := ioutil.ReadFile(filename)
+// CookieConfig holds the cookie jar configuration
+type CookieConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	ImportFile string `yaml:"import_file"`
+	ExportFile string `yaml:"export_file"`
+}
+
+// AuthConfig holds the login sequence run before scraping authenticated pages
+type AuthConfig struct {
+	Enabled         bool               `yaml:"enabled"`
+	Type            string             `yaml:"type"`       // "form" or "browser"
+	Scope           string             `yaml:"scope"`      // "domain" logs in once per host, "session" once per run
+	Domains         []string           `yaml:"domains"`    // Hosts that require a login (all hosts if empty)
+	LoginURL        string             `yaml:"login_url"`  // Absolute, or relative to each host when scope is "domain"
+	SubmitURL       string             `yaml:"submit_url"` // Form action (defaults to login_url)
+	Fields          map[string]string  `yaml:"fields"`     // Form fields; values may reference ${ENV_VARS}
+	CSRF            CSRFConfig         `yaml:"csrf"`
+	SuccessSelector string             `yaml:"success_selector"` // Element that must be present after logging in
+	Browser         BrowserLoginConfig `yaml:"browser"`
+	ExpiredStatus   []int              `yaml:"expired_status"` // Status codes signalling an expired session
+	ExpiredURL      string             `yaml:"expired_url"`    // Redirect target signalling an expired session (defaults to login_url)
+}
+
+// CSRFConfig describes how to take a CSRF token from the login page
+type CSRFConfig struct {
+	Selector  string `yaml:"selector"`
+	Attribute string `yaml:"attribute"` // Defaults to "value"
+	Field     string `yaml:"field"`     // Defaults to the element's name attribute
+}
+
+// BrowserLoginConfig describes a browser-driven login
+type BrowserLoginConfig struct {
+	Fields  map[string]string `yaml:"fields"`   // CSS selector to value; values may reference ${ENV_VARS}
+	Submit  string            `yaml:"submit"`   // CSS selector of the element to click
+	WaitFor string            `yaml:"wait_for"` // CSS selector to wait for after submitting
+}
+
+// ContentConfig holds the settings for handling HTML and non-HTML responses
+type ContentConfig struct {
+	AllowedTypes        []string `yaml:"allowed_types"`         // MIME types to accept, e.g. "text/html" or "image/*" (all if empty)
+	MaxBodySize         int64    `yaml:"max_body_size"`         // Maximum body size in bytes as sent over the wire (0 for no limit)
+	MaxDecompressedSize int64    `yaml:"max_decompressed_size"` // Maximum size in bytes after decompressing gzip or deflate bodies (0 for no limit)
+	DownloadDir         string   `yaml:"download_dir"`          // Where binary bodies are saved
+}
+
+// MetricsConfig holds the Prometheus metrics endpoint configuration
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Path    string `yaml:"path"`
+}
+
+// LoggingConfig holds the structured logging configuration
+type LoggingConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // text or json
+	File   string `yaml:"file"`   // Log file (stderr if empty)
+}
+
+// ProgressConfig holds the live progress reporting configuration
+type ProgressConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"` // How often summary lines are logged when stdout is not a terminal
+}
+
+// ReportConfig holds the end-of-run report configuration
+type ReportConfig struct {
+	File    string `yaml:"file"`    // JSON report file (none if empty)
+	Slowest int    `yaml:"slowest"` // Number of slowest URLs to list
+}
+
+// ServerConfig holds the API server configuration used by serve mode
+type ServerConfig struct {
+	Address        string        `yaml:"address"`
+	MaxConcurrency int           `yaml:"max_concurrency"` // Concurrent fetches shared by all jobs
+	JobRetention   time.Duration `yaml:"job_retention"`   // How long finished jobs and their results are kept
+	MaxJobs        int           `yaml:"max_jobs"`        // Finished jobs kept at most; the oldest are deleted first
+}
+
+// WebhookConfig holds the webhook delivery configuration
+type WebhookConfig struct {
+	Enabled        bool              `yaml:"enabled"`
+	URL            string            `yaml:"url"`              // Endpoint that receives result batches
+	CompleteURL    string            `yaml:"complete_url"`     // Endpoint for the job complete callback (defaults to url)
+	Secret         string            `yaml:"secret"`           // HMAC-SHA256 signing secret; ${VARS} are read from the environment
+	Headers        map[string]string `yaml:"headers"`          // Extra request headers; ${VARS} are read from the environment
+	BatchSize      int               `yaml:"batch_size"`       // Results per delivery
+	FlushInterval  time.Duration     `yaml:"flush_interval"`   // Send a partial batch after this long
+	MaxRetries     int               `yaml:"max_retries"`      // Retries per delivery
+	RetryDelay     time.Duration     `yaml:"retry_delay"`      // Base delay, doubled on each retry
+	Timeout        time.Duration     `yaml:"timeout"`          // Per-request timeout
+	DeadLetterFile string            `yaml:"dead_letter_file"` // NDJSON file for deliveries that never succeed
+}
+
+// MiddlewareConfig holds the built-in middleware applied around every fetch
+type MiddlewareConfig struct {
+	Headers  map[string]string `yaml:"headers"`  // Headers added to every request; ${VARS} are read from the environment
+	Allow    []string          `yaml:"allow"`    // Only fetch URLs matching one of these regexes (all if empty)
+	Deny     []string          `yaml:"deny"`     // Never fetch URLs matching any of these regexes
+	Metadata map[string]string `yaml:"metadata"` // Values added to every result's metadata
+}
+
+// HybridConfig controls the fallback from plain HTTP to browser rendering
+type HybridConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	RequiredFields []string `yaml:"required_fields"` // Escalate when any of these extracted fields is empty
+	MinBodySize    int64    `yaml:"min_body_size"`   // Escalate when the HTML body is smaller than this many bytes
+	Markers        []string `yaml:"markers"`         // Escalate when the page contains any of these strings (case-insensitive)
+	Hosts          []string `yaml:"hosts"`           // Host patterns always fetched with the browser
+}
+
+// WARCConfig controls archiving of the raw HTTP exchanges in WARC files
+type WARCConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`      // Directory the archive files are written to
+	Prefix  string `yaml:"prefix"`   // File name prefix
+	MaxSize int64  `yaml:"max_size"` // Start a new file once the current one reaches this many bytes (0 for no limit)
+}
+
+// MonitorConfig controls change detection between runs
+type MonitorConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	StateFile       string   `yaml:"state_file"`       // Fingerprints from the previous run
+	ChangesFile     string   `yaml:"changes_file"`     // New, changed and removed pages found by this run
+	IgnoreFields    []string `yaml:"ignore_fields"`    // Extracted fields left out of the comparison
+	IgnoreSelectors []string `yaml:"ignore_selectors"` // Elements removed from the page before comparing its text
+	IgnorePatterns  []string `yaml:"ignore_patterns"`  // Regexes blanked out of the text and field values
+	TextDiff        bool     `yaml:"text_diff"`        // Include a line diff of the page text
+	ContextLines    int      `yaml:"context_lines"`    // Unchanged lines shown around each change in the text diff
+}
+
+// ScheduleConfig holds the jobs run by schedule mode
+type ScheduleConfig struct {
+	StateFile string         `yaml:"state_file"` // Last run time and outcome of every job
+	Jitter    time.Duration  `yaml:"jitter"`     // Random delay of up to this long before each run
+	CatchUp   string         `yaml:"catch_up"`   // Runs missed while the scheduler was down: "skip", "once" or "all"
+	Jobs      []ScheduledJob `yaml:"jobs"`
+}
+
+// ScheduledJob is a named scrape run on a cron expression or at an interval
+type ScheduledJob struct {
+	Name       string         `yaml:"name"`
+	Cron       string         `yaml:"cron"`        // Five-field cron expression, e.g. "0 */6 * * *"
+	Every      time.Duration  `yaml:"every"`       // Interval between runs, instead of cron
+	Config     string         `yaml:"config"`      // Configuration file for the job (the schedule's own if empty)
+	InputFile  string         `yaml:"input_file"`  // Overrides io.input_file
+	OutputFile string         `yaml:"output_file"` // Overrides io.output_file
+	Jitter     *time.Duration `yaml:"jitter"`      // Overrides schedule.jitter
+	CatchUp    string         `yaml:"catch_up"`    // Overrides schedule.catch_up
+}
+
+// QueueConfig selects where the worker pool takes its URLs from and puts its
+// results: an in-process queue, a database shared with other processes on
+// the machine, or that database served by the coordinator over HTTP
+type QueueConfig struct {
+	Backend      string        `yaml:"backend"`       // "memory", "sqlite" or "http"
+	Path         string        `yaml:"path"`          // SQLite file shared by the coordinator and the workers
+	Listen       string        `yaml:"listen"`        // Address the coordinator serves an http queue on
+	URL          string        `yaml:"url"`           // Where workers reach the coordinator's http queue
+	Token        string        `yaml:"token"`         // Shared secret for the http queue
+	LeaseTimeout time.Duration `yaml:"lease_timeout"` // A job not renewed for this long goes back to the queue
+	PollInterval time.Duration `yaml:"poll_interval"` // How often idle workers and the coordinator check the queue
+	MaxAttempts  int           `yaml:"max_attempts"`  // Leases a job may expire before it is given up as failed
+}
+
+// Shared reports whether the queue is shared with worker processes
+func (c *QueueConfig) Shared() bool {
+	return c.Backend == "sqlite" || c.Backend == "http"
+}
+
+// CanonicalConfig controls how URLs are rewritten into a canonical form, so
+// the aliases of a page are fetched once
+type CanonicalConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	LowercaseHost   bool     `yaml:"lowercase_host"`
+	DropFragment    bool     `yaml:"drop_fragment"`
+	DropDefaultPort bool     `yaml:"drop_default_port"` // Remove :80 from http and :443 from https URLs
+	SortQuery       bool     `yaml:"sort_query"`
+	StripParams     []string `yaml:"strip_params"`   // Query parameters to remove; glob patterns such as "utm_*"
+	TrailingSlash   string   `yaml:"trailing_slash"` // "keep", "add" or "remove"
+	Scheme          string   `yaml:"scheme"`         // "keep", "http" or "https"
+	Deduplicate     bool     `yaml:"deduplicate"`    // Queue each canonical URL once
+	ResolveTag      bool     `yaml:"resolve_tag"`    // Record the page's <link rel=canonical> and flag pages sharing one
+}
+
+// NearDupConfig controls the detection of pages whose text is nearly the
+// same as a page already fetched, by comparing SimHash fingerprints
+type NearDupConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Similarity      float64  `yaml:"similarity"`       // Share of matching fingerprint bits, 0.5 to 1, for a page to count as a near-duplicate
+	ShingleSize     int      `yaml:"shingle_size"`     // Words per shingle
+	MinWords        int      `yaml:"min_words"`        // Pages with fewer words are fingerprinted but not compared
+	IgnoreSelectors []string `yaml:"ignore_selectors"` // Elements removed before the text is fingerprinted
+	Skip            bool     `yaml:"skip"`             // Leave near-duplicates out of the saved results
+}
+
+// SiteConfig is a per-site profile overriding the global settings for the
+// URLs it matches. The first matching profile wins.
+type SiteConfig struct {
+	Name       string            `yaml:"name"`
+	Hosts      []string          `yaml:"hosts"`      // Host patterns, e.g. "shop.example.com" or "*.example.com"
+	URLRegex   string            `yaml:"url_regex"`  // Regular expression matched against the whole URL
+	Extraction *ExtractionConfig `yaml:"extraction"` // Replaces the global extraction rules
+	Browser    *bool             `yaml:"browser"`    // Render with (or without) the browser
+	WaitTime   time.Duration     `yaml:"wait_time"`  // Browser wait after loading the page
+	WaitFor    string            `yaml:"wait_for"`   // CSS selector the browser waits for
+	Headers    map[string]string `yaml:"headers"`    // Added to the global middleware headers
+	Proxies    *ProxyConfig      `yaml:"proxies"`    // Replaces the global proxy settings
+	RateLimit  time.Duration     `yaml:"rate_limit"` // Delay between requests to this site
+}
+
+// sitePatterns caches the compiled url_regex of the site profiles
+var sitePatterns sync.Map
+
+// Matches reports whether the profile applies to the URL: its url_regex
+// matches the URL or one of its host patterns matches the URL's host
+func (s *SiteConfig) Matches(rawURL string) bool {
+	if s.URLRegex != "" {
+		re, ok := sitePatterns.Load(s.URLRegex)
+		if !ok {
+			compiled, err := regexp.Compile(s.URLRegex)
+			if err != nil {
+				return false
+			}
+			re, _ = sitePatterns.LoadOrStore(s.URLRegex, compiled)
+		}
+		if re.(*regexp.Regexp).MatchString(rawURL) {
+			return true
+		}
+	}
+
+	if len(s.Hosts) == 0 {
+		return false
+	}
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, err
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range s.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteFor returns the first site profile that applies to the URL, or nil
+// if the global settings do
+func (c *AppConfig) SiteFor(rawURL string) *SiteConfig {
+	for i := range c.Sites {
+		if c.Sites[i].Matches(rawURL) {
+			return &c.Sites[i]
+		}
 	}
+	return nil
+}
 
-	var config AppConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
+// Load loads the configuration from a YAML file on top of the built-in
+// defaults and validates it
+func Load(filename string) (*AppConfig, error) {
+	layers := NewLayered()
+	if err := layers.LoadFile(filename); err != nil {
 		return nil, err
 	}
-
-	// Set default user agents if none provided
-	if len(config.Scraper.UserAgents) == 0 {
-		config.Scraper.UserAgents = DefaultUserAgents
+	if err := layers.Validate(); err != nil {
+		return nil, err
 	}
+	return layers.Config, nil
+}
 
-	return &config, nil
+// Defaults returns the built-in default configuration
+func Defaults() *AppConfig {
+	return CreateDefault(3, 1*time.Second, 2*time.Second, 3, "", "results.json", "title", "h1", false, false)
 }
 
 // CreateDefault creates a default configuration
@@ -119,5 +402,161 @@ func CreateDefault(numWorkers int, rateLimitDelay, retryDelay time.Duration, max
 			Screenshot:    false,
 			ScreenshotDir: "screenshots",
 		},
+		Cookies: CookieConfig{
+			Enabled: true,
+		},
+		Content: ContentConfig{
+			DownloadDir: "downloads",
+		},
+		Metrics: MetricsConfig{
+			Address: ":9090",
+			Path:    "/metrics",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Progress: ProgressConfig{
+			Enabled:  true,
+			Interval: 10 * time.Second,
+		},
+		Report: ReportConfig{
+			Slowest: 10,
+		},
+		Server: ServerConfig{
+			Address:        ":8080",
+			MaxConcurrency: 10,
+			JobRetention:   time.Hour,
+			MaxJobs:        100,
+		},
+		Hybrid: HybridConfig{
+			Markers: []string{"enable javascript", "javascript is required", "javascript is disabled"},
+		},
+		Schedule: ScheduleConfig{
+			StateFile: "schedule-state.json",
+			CatchUp:   "skip",
+		},
+		Queue: QueueConfig{
+			Backend:      "memory",
+			Path:         "queue.db",
+			LeaseTimeout: 2 * time.Minute,
+			PollInterval: time.Second,
+			MaxAttempts:  3,
+		},
+		Canonical: CanonicalConfig{
+			LowercaseHost:   true,
+			DropFragment:    true,
+			DropDefaultPort: true,
+			SortQuery:       true,
+			StripParams:     []string{"utm_*", "gclid", "fbclid", "msclkid", "mc_cid", "mc_eid"},
+			TrailingSlash:   "keep",
+			Scheme:          "keep",
+			Deduplicate:     true,
+			ResolveTag:      true,
+		},
+		NearDup: NearDupConfig{
+			Similarity:  0.95,
+			ShingleSize: 3,
+			MinWords:    20,
+		},
+		Monitor: MonitorConfig{
+			StateFile:    "monitor-state.json",
+			ChangesFile:  "changes.json",
+			TextDiff:     true,
+			ContextLines: 3,
+		},
+		WARC: WARCConfig{
+			Dir:     "warc",
+			Prefix:  "scraper",
+			MaxSize: 1 << 30,
+		},
+		Webhook: WebhookConfig{
+			BatchSize:      1,
+			FlushInterval:  5 * time.Second,
+			MaxRetries:     5,
+			RetryDelay:     1 * time.Second,
+			Timeout:        10 * time.Second,
+			DeadLetterFile: "webhook-dead-letter.ndjson",
+		},
+	}
+}
+
+// Merge returns a copy of the configuration with the YAML (or JSON) overrides
+// applied on top. Only the keys present in the overrides are changed; unknown
+// keys and values of the wrong type are reported as a *ValidationError.
+func (c *AppConfig) Merge(overrides []byte) (*AppConfig, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
 	}
+
+	var merged AppConfig
+	if err := yaml.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+
+	if len(overrides) > 0 {
+		problems, err := decodeStrict(overrides, &merged)
+		if err != nil {
+			return nil, err
+		}
+		if len(problems) > 0 {
+			return nil, &ValidationError{Problems: problems}
+		}
+	}
+
+	return &merged, nil
+}
+
+// MergeOnly is like Merge, but rejects overrides of any key outside the
+// allowed paths. A path allows every key under it.
+func (c *AppConfig) MergeOnly(overrides []byte, allowed []string) (*AppConfig, error) {
+	problems, err := restrictKeys(overrides, allowed)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return c.Merge(overrides)
+}
+
+// ForSite returns a copy of the configuration with the site's overrides applied
+func (c *AppConfig) ForSite(site *SiteConfig) (*AppConfig, error) {
+	merged, err := c.Merge(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if site.Extraction != nil {
+		merged.Extraction = *site.Extraction
+	}
+	if site.Browser != nil {
+		merged.Browser.Enabled = *site.Browser
+	}
+	if site.WaitTime > 0 {
+		merged.Browser.WaitTime = site.WaitTime
+	}
+	if site.WaitFor != "" {
+		merged.Browser.WaitFor = site.WaitFor
+	}
+	if len(site.Headers) > 0 {
+		headers := make(map[string]string, len(merged.Middleware.Headers)+len(site.Headers))
+		for name, value := range merged.Middleware.Headers {
+			headers[name] = value
+		}
+		for name, value := range site.Headers {
+			headers[name] = value
+		}
+		merged.Middleware.Headers = headers
+	}
+	if site.Proxies != nil {
+		merged.Proxies = *site.Proxies
+	}
+	if site.RateLimit > 0 {
+		merged.Scraper.RateLimit = site.RateLimit
+	}
+	merged.Sites = nil
+
+	return merged, nil
 }
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables that override configuration
+// values, e.g. SCRAPER_SCRAPER_WORKERS for scraper.workers
+const EnvPrefix = "SCRAPER_"
+
+// SourceDefault marks values that come from the built-in defaults
+const SourceDefault = "default"
+
+// Layered builds a configuration from the built-in defaults, a configuration
+// file, SCRAPER_* environment variables and command-line flags, in that order
+// of precedence, and remembers where each value came from
+type Layered struct {
+	Config *AppConfig
+
+	// Sources maps YAML paths to the layer that set them, e.g. "default",
+	// "file config.yaml", "env SCRAPER_SCRAPER_WORKERS" or "flag -workers"
+	Sources map[string]string
+
+	// Warnings lists the SCRAPER_* variables that name no value. They aren't
+	// errors: other software sets such variables too, such as Kubernetes
+	// with SCRAPER_SERVICE_HOST for a service named "scraper".
+	Warnings []Problem
+
+	// problems holds the decoding problems found in the configuration file
+	problems []Problem
+	file     string
+}
+
+// NewLayered creates a layered configuration holding the built-in defaults
+func NewLayered() *Layered {
+	l := &Layered{
+		Config:  Defaults(),
+		Sources: make(map[string]string),
+	}
+	for _, path := range leafPaths() {
+		l.Sources[path] = SourceDefault
+	}
+	return l
+}
+
+// LoadFile applies the values set in a YAML configuration file. Unknown keys
+// and values of the wrong type are reported by Validate.
+func (l *Layered) LoadFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var file AppConfig
+	problems, err := decodeStrict(data, &file)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	l.problems = append(l.problems, problems...)
+	l.file = filename
+
+	// Copy only the values present in the file
+	source := "file " + filename
+	dst, src := reflect.ValueOf(l.Config).Elem(), reflect.ValueOf(&file).Elem()
+	for _, path := range leafPaths() {
+		if _, ok := file.lines[path]; !ok || hasPath(problems, path) {
+			continue
+		}
+		from, _ := fieldByPath(src, path)
+		to, _ := fieldByPath(dst, path)
+		to.Set(from)
+		l.Sources[path] = source
+	}
+	l.Config.lines = file.lines
+
+	return nil
+}
+
+// LoadEnv applies SCRAPER_* variables from environ (as returned by
+// os.Environ). The variable name is the YAML path in upper case with dots
+// replaced by underscores; lists and maps take YAML flow syntax such as
+// "[a, b]" or "{title: h1}". Only values that don't parse are errors;
+// SCRAPER_* variables that name no value are added to Warnings, with a
+// suggestion when one is close.
+func (l *Layered) LoadEnv(environ []string) error {
+	values := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			values[name] = value
+		}
+	}
+
+	var problems []Problem
+	known := make([]string, 0, len(leafPaths()))
+	for _, path := range leafPaths() {
+		name := EnvName(path)
+		known = append(known, name)
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		delete(values, name)
+		if err := l.Set(path, value, "env "+name); err != nil {
+			problems = append(problems, Problem{Path: path, Message: fmt.Sprintf("%s: %v", name, err)})
+		}
+	}
+
+	// Whatever is left doesn't match any value
+	unknown := make([]string, 0, len(values))
+	for name := range values {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		l.Warnings = append(l.Warnings, Problem{Path: name, Message: unknownEnvMessage(name, known)})
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Set parses value as YAML into the field at path and records its source.
+// The path may also name a single entry of a map, e.g.
+// "extraction.selectors.title".
+func (l *Layered) Set(path, value, source string) error {
+	if err := setPath(reflect.ValueOf(l.Config).Elem(), path, value); err != nil {
+		return err
+	}
+	l.Sources[path] = source
+	return nil
+}
+
+// Source returns the layer that set the value at path, looking at its
+// parents for map entries and list items
+func (l *Layered) Source(path string) string {
+	for path != "" {
+		if source, ok := l.Sources[path]; ok {
+			return source
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return ""
+}
+
+// DeriveDefaults fills in the defaults that depend on other values once
+// every layer is loaded: unless a layer set the output file, it is named
+// after the output format
+func (l *Layered) DeriveDefaults() {
+	if l.Source("io.output_file") != SourceDefault {
+		return
+	}
+	if name, ok := DefaultOutputFiles[l.Config.IO.OutputFormat]; ok {
+		l.Config.IO.OutputFile = name
+	}
+}
+
+// Validate checks the final configuration. Problems with values set outside
+// the configuration file name their source instead of a line.
+func (l *Layered) Validate() error {
+	problems := append([]Problem(nil), l.problems...)
+	if verr, ok := l.Config.Validate().(*ValidationError); ok {
+		for _, p := range verr.Problems {
+			if hasPath(problems, p.Path) {
+				continue
+			}
+			if source := l.Source(p.Path); source != "" && !strings.HasPrefix(source, "file ") {
+				p.Line = 0
+				p.Message = fmt.Sprintf("%s (set by %s)", p.Message, source)
+			}
+			problems = append(problems, p)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return &ValidationError{File: l.file, Problems: problems}
+}
+
+// Print writes the effective configuration as YAML, with the source of each
+// value as a line comment. Secrets are masked.
+func (l *Layered) Print(w io.Writer) error {
+	var doc yaml.Node
+	if err := doc.Encode(l.Config); err != nil {
+		return err
+	}
+	l.annotate(&doc, "")
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// annotate adds the source of every value below node as a line comment and
+// masks secrets
+func (l *Layered) annotate(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+
+			if value.Kind == yaml.ScalarNode && isSecret(keyPath) && value.Value != "" && !strings.HasPrefix(value.Value, "${") {
+				value.Value = "********"
+				value.Style = 0
+			}
+
+			switch value.Kind {
+			case yaml.ScalarNode:
+				value.LineComment = l.Source(keyPath)
+			case yaml.MappingNode, yaml.SequenceNode:
+				if len(value.Content) == 0 {
+					value.LineComment = l.Source(keyPath)
+				} else if _, leaf := l.Sources[keyPath]; leaf {
+					key.LineComment = l.Source(keyPath)
+				}
+				l.annotate(value, keyPath)
+			}
+		}
+
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if item.Kind == yaml.ScalarNode && strings.HasPrefix(path, "proxies.list") {
+				item.Value = redactURL(item.Value)
+			}
+			l.annotate(item, itemPath)
+		}
+	}
+}
+
+// isSecret reports whether the value at path should be masked when printed
+func isSecret(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.Contains(lower, "password") || strings.Contains(lower, "secret") ||
+		strings.Contains(lower, "token") || strings.HasPrefix(lower, "auth.fields.")
+}
+
+// redactURL masks the password in a URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// unknownEnvMessage reports an unknown SCRAPER_* variable, suggesting the
+// variables it is the tail of, such as SCRAPER_SCRAPER_WORKERS for
+// SCRAPER_WORKERS, or one within a typo of it
+func unknownEnvMessage(name string, known []string) string {
+	var tails []string
+	suffix := "_" + strings.TrimPrefix(name, EnvPrefix)
+	for _, k := range known {
+		if strings.HasSuffix(k, suffix) {
+			tails = append(tails, k)
+		}
+	}
+	switch {
+	case len(tails) == 1:
+		return fmt.Sprintf("unknown environment variable (did you mean %s?)", tails[0])
+	case len(tails) > 1 && len(tails) <= 3:
+		return fmt.Sprintf("unknown environment variable (did you mean one of %s?)", strings.Join(tails, ", "))
+	}
+
+	for _, k := range known {
+		if distance(name, k) <= 2 {
+			return fmt.Sprintf("unknown environment variable (did you mean %s?)", k)
+		}
+	}
+	return "unknown environment variable"
+}
+
+// EnvName returns the environment variable that overrides the value at path
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// leafPaths lists the YAML paths of every value that isn't a nested section
+func leafPaths() []string {
+	var paths []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			path := joinPath(prefix, name)
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, path)
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	walk(reflect.TypeOf(AppConfig{}), "")
+	return paths
+}
+
+// fieldByPath finds the struct field at a dotted YAML path
+func fieldByPath(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		field, ok := structField(v, name)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		v = field
+	}
+	return v, true
+}
+
+// structField returns the field of a struct with the given YAML name
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0] == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setPath parses raw as YAML into the value at path, which may end in a map key
+func setPath(root reflect.Value, path, raw string) error {
+	if field, ok := fieldByPath(root, path); ok {
+		if field.Kind() == reflect.Struct {
+			return fmt.Errorf("%s is a section, not a value", path)
+		}
+		return parseInto(field, raw)
+	}
+
+	// Try a map entry such as extraction.selectors.title
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return fmt.Errorf("unknown key %q", path)
+	}
+	parent, ok := fieldByPath(root, path[:i])
+	if !ok || parent.Kind() != reflect.Map {
+		return fmt.Errorf("unknown key %q", path)
+	}
+
+	value := reflect.New(parent.Type().Elem()).Elem()
+	if err := parseInto(value, raw); err != nil {
+		return err
+	}
+	if parent.IsNil() {
+		parent.Set(reflect.MakeMap(parent.Type()))
+	}
+	parent.SetMapIndex(reflect.ValueOf(path[i+1:]), value)
+	return nil
+}
+
+// parseInto sets v from raw; strings are taken as is, anything else is
+// parsed as YAML
+func parseInto(v reflect.Value, raw string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(raw)
+		return nil
+	}
+
+	parsed := reflect.New(v.Type())
+	if err := yaml.Unmarshal([]byte(raw), parsed.Interface()); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+			msg = typeLinePattern.ReplaceAllString(typeErr.Errors[0], "$2")
+		}
+		return fmt.Errorf("invalid value %q: %s", raw, msg)
+	}
+	v.Set(parsed.Elem())
+	return nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetPath(t *testing.T) {
+	tests := []struct {
+		path, raw string
+		get       func(*AppConfig) interface{}
+		want      interface{}
+		err       string // Part of the expected error, empty for none
+	}{
+		{path: "scraper.workers", raw: "4", get: func(c *AppConfig) interface{} { return c.Scraper.Workers }, want: 4},
+		{path: "scraper.rate_limit", raw: "500ms", get: func(c *AppConfig) interface{} { return c.Scraper.RateLimit }, want: 500 * time.Millisecond},
+		{path: "scraper.head_only", raw: "true", get: func(c *AppConfig) interface{} { return c.Scraper.HeadOnly }, want: true},
+		{path: "io.output_file", raw: "out: [1].json", get: func(c *AppConfig) interface{} { return c.IO.OutputFile }, want: "out: [1].json"},
+		{path: "proxies.list", raw: "[http://a, http://b]", get: func(c *AppConfig) interface{} { return c.Proxies.List }, want: []string{"http://a", "http://b"}},
+		{
+			path: "extraction.selectors", raw: "{price: .price}",
+			get:  func(c *AppConfig) interface{} { return c.Extraction.Selectors },
+			want: map[string]string{"price": ".price"},
+		},
+		{
+			path: "extraction.selectors.price", raw: ".price",
+			get:  func(c *AppConfig) interface{} { return c.Extraction.Selectors },
+			want: map[string]string{"title": "title", "heading": "h1", "price": ".price"},
+		},
+		{
+			path: "extraction.regex.id", raw: `id=(\d+)`,
+			get:  func(c *AppConfig) interface{} { return c.Extraction.Regex["id"] },
+			want: `id=(\d+)`,
+		},
+		{path: "scraper.workers", raw: "many", err: `invalid value "many"`},
+		{path: "scraper.rate_limit", raw: "soon", err: `invalid value "soon"`},
+		{path: "proxies.list", raw: "[a, b", err: `invalid value "[a, b"`},
+		{path: "scraper", raw: "1", err: "is a section"},
+		{path: "scraper.nope", raw: "1", err: `unknown key "scraper.nope"`},
+		{path: "scraper.workers.x", raw: "1", err: "unknown key"},
+		{path: "nope", raw: "1", err: `unknown key "nope"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+"="+tt.raw, func(t *testing.T) {
+			cfg := Defaults()
+			err := setPath(reflect.ValueOf(cfg).Elem(), tt.path, tt.raw)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("setPath() error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setPath() error = %v", err)
+			}
+			if got := tt.get(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayeredPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	data := "scraper:\n  workers: 5\n  rate_limit: 2s\n  timeout: 20s\nio:\n  output_format: sqlite\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLayered()
+	if err := l.LoadFile(file); err != nil {
+		t.Fatalf("LoadFile() = %v", err)
+	}
+	if err := l.LoadEnv([]string{
+		"SCRAPER_SCRAPER_WORKERS=6",
+		"SCRAPER_SCRAPER_TIMEOUT=10s",
+		"HOME=/root",
+	}); err != nil {
+		t.Fatalf("LoadEnv() = %v", err)
+	}
+	if err := l.Set("scraper.workers", "7", "flag -workers"); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	l.DeriveDefaults()
+	if err := l.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		got    interface{}
+		want   interface{}
+		source string
+	}{
+		{"scraper.workers", l.Config.Scraper.Workers, 7, "flag -workers"},
+		{"scraper.timeout", l.Config.Scraper.Timeout, 10 * time.Second, "env SCRAPER_SCRAPER_TIMEOUT"},
+		{"scraper.rate_limit", l.Config.Scraper.RateLimit, 2 * time.Second, "file " + file},
+		{"scraper.max_retries", l.Config.Scraper.MaxRetries, 3, SourceDefault},
+		{"io.output_format", l.Config.IO.OutputFormat, "sqlite", "file " + file},
+		// The output file follows the format unless a layer set it
+		{"io.output_file", l.Config.IO.OutputFile, "results.db", SourceDefault},
+		{"extraction.selectors.title", l.Config.Extraction.Selectors["title"], "title", SourceDefault},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.path, tt.got, tt.want)
+		}
+		if got := l.Source(tt.path); got != tt.source {
+			t.Errorf("Source(%q) = %q, want %q", tt.path, got, tt.source)
+		}
+	}
+}
+
+func TestLayeredFileProblems(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	data := "scraper:\n  workers: 5\n  worker: 2\n  rate_limit: fast\n"
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLayered()
+	if err := l.LoadFile(file); err != nil {
+		t.Fatalf("LoadFile() = %v", err)
+	}
+	if l.Config.Scraper.Workers != 5 {
+		t.Errorf("workers = %d, want the valid values of the file applied", l.Config.Scraper.Workers)
+	}
+	if l.Config.Scraper.RateLimit != time.Second {
+		t.Errorf("rate_limit = %s, want the default kept in place of the invalid value", l.Config.Scraper.RateLimit)
+	}
+
+	var verr *ValidationError
+	if err := l.Validate(); !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	if verr.File != file {
+		t.Errorf("File = %q, want %q", verr.File, file)
+	}
+	var got []string
+	for _, p := range verr.Problems {
+		got = append(got, p.Path)
+	}
+	if want := []string{"scraper.worker", "scraper.rate_limit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("problems at %v, want %v in line order", got, want)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		environ  []string
+		errors   []string // Paths of the parse errors
+		warnings []string // Warnings, as "NAME: message"
+	}{
+		{
+			name:    "known variables",
+			environ: []string{"SCRAPER_SCRAPER_WORKERS=2", "SCRAPER_EXTRACTION_SELECTORS={a: b}", "PATH=/bin"},
+		},
+		{
+			name:    "tail of a known variable",
+			environ: []string{"SCRAPER_WORKERS=2"},
+			warnings: []string{
+				"SCRAPER_WORKERS: unknown environment variable (did you mean SCRAPER_SCRAPER_WORKERS?)",
+			},
+		},
+		{
+			name:    "typo",
+			environ: []string{"SCRAPER_SCRAPER_WORKRES=2"},
+			warnings: []string{
+				"SCRAPER_SCRAPER_WORKRES: unknown environment variable (did you mean SCRAPER_SCRAPER_WORKERS?)",
+			},
+		},
+		{
+			name:    "set by Kubernetes for a service named scraper",
+			environ: []string{"SCRAPER_SERVICE_HOST=10.0.0.1", "SCRAPER_PORT=tcp://10.0.0.1:80"},
+			warnings: []string{
+				"SCRAPER_PORT: unknown environment variable (did you mean SCRAPER_CANONICAL_DROP_DEFAULT_PORT?)",
+				"SCRAPER_SERVICE_HOST: unknown environment variable",
+			},
+		},
+		{
+			name:    "values that don't parse",
+			environ: []string{"SCRAPER_SCRAPER_WORKERS=lots", "SCRAPER_SCRAPER_TIMEOUT=1s", "SCRAPER_SCRAPER_RATE_LIMIT=slow"},
+			errors:  []string{"scraper.workers", "scraper.rate_limit"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLayered()
+			err := l.LoadEnv(tt.environ)
+
+			var got []string
+			var verr *ValidationError
+			if errors.As(err, &verr) {
+				for _, p := range verr.Problems {
+					got = append(got, p.Path)
+				}
+			} else if err != nil {
+				t.Fatalf("LoadEnv() = %v", err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.errors, " ") {
+				t.Errorf("errors at %v, want %v", got, tt.errors)
+			}
+
+			var warnings []string
+			for _, w := range l.Warnings {
+				warnings = append(warnings, w.Path+": "+w.Message)
+			}
+			if strings.Join(warnings, "\n") != strings.Join(tt.warnings, "\n") {
+				t.Errorf("warnings = %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestLayeredValidateSource(t *testing.T) {
+	l := NewLayered()
+	if err := l.LoadEnv([]string{"SCRAPER_SCRAPER_WORKERS=0"}); err != nil {
+		t.Fatalf("LoadEnv() = %v", err)
+	}
+	if err := l.Set("scraper.timeout", "-1s", "flag -timeout"); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+
+	var verr *ValidationError
+	if err := l.Validate(); !errors.As(err, &verr) {
+		t.Fatalf("Validate() = %v, want a *ValidationError", err)
+	}
+	want := map[string]string{
+		"scraper.workers": "(set by env SCRAPER_SCRAPER_WORKERS)",
+		"scraper.timeout": "(set by flag -timeout)",
+	}
+	for _, p := range verr.Problems {
+		suffix, ok := want[p.Path]
+		if !ok {
+			t.Errorf("unexpected problem %s", p)
+			continue
+		}
+		if !strings.HasSuffix(p.Message, suffix) || p.Line != 0 {
+			t.Errorf("problem = %s, want it to end in %q without a line", p, suffix)
+		}
+		delete(want, p.Path)
+	}
+	for path := range want {
+		t.Errorf("no problem reported at %s", path)
+	}
+}
@@ -0,0 +1,776 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	pathpkg "path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cron"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormats lists the supported values of io.output_format
+var OutputFormats = []string{"json", "sqlite"}
+
+// DefaultOutputFiles maps each output format to the output file used when
+// io.output_file isn't set
+var DefaultOutputFiles = map[string]string{"json": "results.json", "sqlite": "results.db"}
+
+// QueueBackends lists the supported values of queue.backend
+var QueueBackends = []string{"memory", "sqlite", "http"}
+
+// Problem describes one invalid configuration value
+type Problem struct {
+	Path    string `json:"path,omitempty"` // YAML path, e.g. "scraper.workers" or "proxies.list[1]"
+	Line    int    `json:"line,omitempty"` // Line in the configuration file (0 if unknown)
+	Message string `json:"message"`
+}
+
+// String formats the problem as "path (line N): message"
+func (p Problem) String() string {
+	switch {
+	case p.Path == "":
+		return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+	case p.Line > 0:
+		return fmt.Sprintf("%s (line %d): %s", p.Path, p.Line, p.Message)
+	default:
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	File     string
+	Problems []Problem
+}
+
+// Error lists the problems, one per line
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		fmt.Fprintf(&b, "invalid configuration %s:", e.File)
+	} else {
+		b.WriteString("invalid configuration:")
+	}
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem, or nil if it is valid
+func (c *AppConfig) Validate() error {
+	v := &validator{lines: c.lines}
+	v.check(c)
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// decodeStrict decodes a YAML document into config, reporting unknown keys
+// and type errors with their path and line
+func decodeStrict(data []byte, config *AppConfig) ([]Problem, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	doc := root.Content[0]
+
+	// Record where every key is, then look for keys the configuration doesn't have
+	config.lines = make(map[string]int)
+	var problems []Problem
+	walkNode(doc, reflect.TypeOf(*config), "", config.lines, &problems)
+
+	// Decode leniently; unknown keys were reported above
+	if err := doc.Decode(config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, typeProblem(msg, config.lines))
+		}
+	}
+
+	return problems, nil
+}
+
+// restrictKeys reports every key in a YAML document that is neither one of
+// the allowed paths, under one, nor a section leading to one
+func restrictKeys(data []byte, allowed []string) ([]Problem, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	var problems []Problem
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		if node.Kind != yaml.MappingNode {
+			problems = append(problems, Problem{Path: path, Line: node.Line, Message: "can't be overridden"})
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+
+			switch {
+			case allowedPath(keyPath, allowed):
+			case leadsTo(keyPath, allowed):
+				walk(value, keyPath)
+			default:
+				problems = append(problems, Problem{Path: keyPath, Line: key.Line, Message: "can't be overridden"})
+			}
+		}
+	}
+	walk(root.Content[0], "")
+	return problems, nil
+}
+
+// allowedPath reports whether path is one of the allowed paths or under one
+func allowedPath(path string, allowed []string) bool {
+	for _, a := range allowed {
+		if path == a || strings.HasPrefix(path, a+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// leadsTo reports whether path is a section containing one of the allowed paths
+func leadsTo(path string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.HasPrefix(a, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// walkNode records the line of every key under node and reports keys that
+// don't exist in the Go type t
+func walkNode(node *yaml.Node, t reflect.Type, path string, lines map[string]int, problems *[]Problem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		fields := map[string]reflect.StructField{}
+		if t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+				if name == "" || name == "-" {
+					continue
+				}
+				fields[name] = field
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			lines[keyPath] = key.Line
+
+			switch t.Kind() {
+			case reflect.Struct:
+				field, ok := fields[key.Value]
+				if !ok {
+					*problems = append(*problems, Problem{
+						Path:    keyPath,
+						Line:    key.Line,
+						Message: unknownKeyMessage(key.Value, fields),
+					})
+					continue
+				}
+				walkNode(value, field.Type, keyPath, lines, problems)
+			case reflect.Map:
+				walkNode(value, t.Elem(), keyPath, lines, problems)
+			}
+		}
+
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			lines[itemPath] = item.Line
+			walkNode(item, t.Elem(), itemPath, lines, problems)
+		}
+	}
+}
+
+// unknownKeyMessage reports an unknown key, suggesting a close match
+func unknownKeyMessage(key string, fields map[string]reflect.StructField) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if distance(strings.ToLower(key), name) <= 2 {
+			return fmt.Sprintf("unknown key %q (did you mean %q?)", key, name)
+		}
+	}
+	return fmt.Sprintf("unknown key %q (expected one of: %s)", key, strings.Join(names, ", "))
+}
+
+// distance returns the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// typeLinePattern extracts the line from yaml.v3 type errors
+var typeLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// typeProblem converts a yaml.v3 type error message into a problem, finding
+// the key on that line
+func typeProblem(msg string, lines map[string]int) Problem {
+	m := typeLinePattern.FindStringSubmatch(msg)
+	if m == nil {
+		return Problem{Message: msg}
+	}
+	line, _ := strconv.Atoi(m[1])
+
+	// Use the deepest key found on that line
+	path := ""
+	for p, l := range lines {
+		if l == line && len(p) > len(path) {
+			path = p
+		}
+	}
+	return Problem{Path: path, Line: line, Message: m[2]}
+}
+
+// joinPath appends a key to a YAML path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validator collects problems found while checking a configuration
+type validator struct {
+	lines    map[string]int
+	problems []Problem
+}
+
+// addf records a problem at path
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Path:    path,
+		Line:    v.line(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line returns the line of path, or of its closest parent present in the file
+func (v *validator) line(path string) int {
+	for path != "" {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// check validates every section of the configuration
+func (v *validator) check(c *AppConfig) {
+	// Scraper; with a shared queue the coordinator may leave all the fetching to the workers
+	switch {
+	case c.Scraper.Workers == 0 && c.Queue.Shared():
+	case c.Scraper.Workers <= 0:
+		v.addf("scraper.workers", "must be greater than zero, got %d", c.Scraper.Workers)
+	}
+	if c.Scraper.RateLimit <= 0 {
+		v.addf("scraper.rate_limit", "must be greater than zero, got %s (e.g. 1s or 500ms)", c.Scraper.RateLimit)
+	}
+	if c.Scraper.Timeout <= 0 {
+		v.addf("scraper.timeout", "must be greater than zero, got %s (e.g. 30s)", c.Scraper.Timeout)
+	}
+	if c.Scraper.MaxRetries < 0 {
+		v.addf("scraper.max_retries", "must not be negative, got %d", c.Scraper.MaxRetries)
+	}
+	if c.Scraper.RetryDelay < 0 {
+		v.addf("scraper.retry_delay", "must not be negative, got %s", c.Scraper.RetryDelay)
+	}
+
+	// Output
+	if !contains(OutputFormats, c.IO.OutputFormat) {
+		v.addf("io.output_format", "unsupported output format %q (expected one of: %s)", c.IO.OutputFormat, strings.Join(OutputFormats, ", "))
+	}
+
+	// Extraction
+	for _, name := range sortedKeys(c.Extraction.Selectors) {
+		v.selector("extraction.selectors."+name, c.Extraction.Selectors[name])
+	}
+	for _, name := range sortedKeys(c.Extraction.Regex) {
+		v.regex("extraction.regex."+name, c.Extraction.Regex[name])
+	}
+
+	// Proxies
+	if c.Proxies.Enabled && len(c.Proxies.List) == 0 {
+		v.addf("proxies.list", "must list at least one proxy when proxies are enabled")
+	}
+	for i, proxy := range c.Proxies.List {
+		v.proxyURL(fmt.Sprintf("proxies.list[%d]", i), proxy)
+	}
+
+	// Browser
+	if c.Browser.WaitTime < 0 {
+		v.addf("browser.wait_time", "must not be negative, got %s", c.Browser.WaitTime)
+	}
+	if c.Browser.WaitFor != "" {
+		v.selector("browser.wait_for", c.Browser.WaitFor)
+	}
+	if c.Browser.Screenshot && c.Browser.ScreenshotDir == "" {
+		v.addf("browser.screenshot_dir", "is required when screenshots are enabled")
+	}
+
+	// Auth
+	if c.Auth.Enabled {
+		v.checkAuth(&c.Auth)
+	}
+
+	// Content
+	if c.Content.MaxBodySize < 0 {
+		v.addf("content.max_body_size", "must not be negative, got %d", c.Content.MaxBodySize)
+	}
+	if c.Content.MaxDecompressedSize < 0 {
+		v.addf("content.max_decompressed_size", "must not be negative, got %d", c.Content.MaxDecompressedSize)
+	}
+	for i, mediaType := range c.Content.AllowedTypes {
+		if parts := strings.Split(mediaType, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			v.addf(fmt.Sprintf("content.allowed_types[%d]", i), "invalid MIME type %q (e.g. text/html or image/*)", mediaType)
+		}
+	}
+
+	// Metrics
+	if c.Metrics.Enabled {
+		if c.Metrics.Address == "" {
+			v.addf("metrics.address", "is required when metrics are enabled")
+		}
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			v.addf("metrics.path", "must start with /, got %q", c.Metrics.Path)
+		}
+	}
+
+	// Logging
+	switch strings.ToLower(c.Logging.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		v.addf("logging.level", "unsupported log level %q (expected debug, info, warn or error)", c.Logging.Level)
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case "", "text", "json":
+	default:
+		v.addf("logging.format", "unsupported log format %q (expected text or json)", c.Logging.Format)
+	}
+
+	// Progress and report
+	if c.Progress.Enabled && c.Progress.Interval <= 0 {
+		v.addf("progress.interval", "must be greater than zero, got %s", c.Progress.Interval)
+	}
+	if c.Report.Slowest < 0 {
+		v.addf("report.slowest", "must not be negative, got %d", c.Report.Slowest)
+	}
+
+	// Server
+	if c.Server.MaxConcurrency < 0 {
+		v.addf("server.max_concurrency", "must not be negative, got %d", c.Server.MaxConcurrency)
+	}
+	if c.Server.JobRetention <= 0 {
+		v.addf("server.job_retention", "must be positive, got %s", c.Server.JobRetention)
+	}
+	if c.Server.MaxJobs <= 0 {
+		v.addf("server.max_jobs", "must be greater than zero, got %d", c.Server.MaxJobs)
+	}
+
+	// Webhook
+	if c.Webhook.Enabled {
+		if c.Webhook.URL == "" {
+			v.addf("webhook.url", "is required when the webhook is enabled")
+		} else {
+			v.httpURL("webhook.url", c.Webhook.URL)
+		}
+		if c.Webhook.CompleteURL != "" {
+			v.httpURL("webhook.complete_url", c.Webhook.CompleteURL)
+		}
+		if c.Webhook.BatchSize <= 0 {
+			v.addf("webhook.batch_size", "must be greater than zero, got %d", c.Webhook.BatchSize)
+		}
+		if c.Webhook.MaxRetries < 0 {
+			v.addf("webhook.max_retries", "must not be negative, got %d", c.Webhook.MaxRetries)
+		}
+	}
+
+	// Hybrid
+	if c.Hybrid.Enabled {
+		if c.Hybrid.MinBodySize < 0 {
+			v.addf("hybrid.min_body_size", "must not be negative, got %d", c.Hybrid.MinBodySize)
+		}
+		for i, host := range c.Hybrid.Hosts {
+			if _, err := pathpkg.Match(host, ""); err != nil || strings.ContainsAny(host, "/:") {
+				v.addf(fmt.Sprintf("hybrid.hosts[%d]", i), "invalid host pattern %q (e.g. app.example.com or *.example.com)", host)
+			}
+		}
+	}
+
+	// WARC
+	if c.WARC.Enabled {
+		if c.WARC.Dir == "" {
+			v.addf("warc.dir", "is required when WARC archiving is enabled")
+		}
+		if strings.ContainsAny(c.WARC.Prefix, `/\`) {
+			v.addf("warc.prefix", "must be a file name prefix, not a path, got %q", c.WARC.Prefix)
+		}
+		if c.WARC.MaxSize < 0 {
+			v.addf("warc.max_size", "must not be negative, got %d", c.WARC.MaxSize)
+		}
+	}
+
+	// Monitor
+	if c.Monitor.Enabled {
+		if c.Monitor.StateFile == "" {
+			v.addf("monitor.state_file", "is required when monitoring is enabled")
+		}
+		if c.Monitor.ChangesFile == "" {
+			v.addf("monitor.changes_file", "is required when monitoring is enabled")
+		}
+		if c.Monitor.ContextLines < 0 {
+			v.addf("monitor.context_lines", "must not be negative, got %d", c.Monitor.ContextLines)
+		}
+	}
+	for i, selector := range c.Monitor.IgnoreSelectors {
+		v.selector(fmt.Sprintf("monitor.ignore_selectors[%d]", i), selector)
+	}
+	for i, pattern := range c.Monitor.IgnorePatterns {
+		v.regex(fmt.Sprintf("monitor.ignore_patterns[%d]", i), pattern)
+	}
+
+	// Queue
+	if !contains(QueueBackends, c.Queue.Backend) {
+		v.addf("queue.backend", "unsupported queue backend %q (expected one of: %s)", c.Queue.Backend, strings.Join(QueueBackends, ", "))
+	}
+	if c.Queue.Shared() {
+		if c.Queue.Path == "" {
+			v.addf("queue.path", "is required for the %s queue", c.Queue.Backend)
+		}
+		if c.Queue.LeaseTimeout <= 0 {
+			v.addf("queue.lease_timeout", "must be greater than zero, got %s", c.Queue.LeaseTimeout)
+		}
+		if c.Queue.PollInterval <= 0 {
+			v.addf("queue.poll_interval", "must be greater than zero, got %s", c.Queue.PollInterval)
+		}
+		if c.Queue.MaxAttempts < 1 {
+			v.addf("queue.max_attempts", "must be at least 1, got %d", c.Queue.MaxAttempts)
+		}
+	}
+	if c.Queue.Backend == "http" {
+		if c.Queue.Listen == "" && c.Queue.URL == "" {
+			v.addf("queue.backend", "the http queue needs queue.listen on the coordinator or queue.url on the workers")
+		}
+		if c.Queue.URL != "" {
+			if u, err := url.Parse(c.Queue.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				v.addf("queue.url", "must be an http or https URL, got %q", c.Queue.URL)
+			}
+		}
+	}
+
+	// Canonical URLs
+	switch c.Canonical.TrailingSlash {
+	case "", "keep", "add", "remove":
+	default:
+		v.addf("canonical.trailing_slash", "unsupported trailing slash rule %q (expected keep, add or remove)", c.Canonical.TrailingSlash)
+	}
+	switch c.Canonical.Scheme {
+	case "", "keep", "http", "https":
+	default:
+		v.addf("canonical.scheme", "unsupported scheme rule %q (expected keep, http or https)", c.Canonical.Scheme)
+	}
+	for i, pattern := range c.Canonical.StripParams {
+		if _, err := pathpkg.Match(pattern, ""); err != nil {
+			v.addf(fmt.Sprintf("canonical.strip_params[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	// Near-duplicates
+	if c.NearDup.Enabled {
+		if c.NearDup.Similarity < 0.5 || c.NearDup.Similarity > 1 {
+			v.addf("near_duplicates.similarity", "must be between 0.5 and 1, got %g", c.NearDup.Similarity)
+		}
+		if c.NearDup.ShingleSize < 1 {
+			v.addf("near_duplicates.shingle_size", "must be at least 1, got %d", c.NearDup.ShingleSize)
+		}
+		if c.NearDup.MinWords < 0 {
+			v.addf("near_duplicates.min_words", "must not be negative, got %d", c.NearDup.MinWords)
+		}
+	}
+	for i, selector := range c.NearDup.IgnoreSelectors {
+		v.selector(fmt.Sprintf("near_duplicates.ignore_selectors[%d]", i), selector)
+	}
+
+	// Schedule
+	if c.Schedule.Jitter < 0 {
+		v.addf("schedule.jitter", "must not be negative, got %s", c.Schedule.Jitter)
+	}
+	v.catchUp("schedule.catch_up", c.Schedule.CatchUp)
+	names := make(map[string]bool)
+	for i := range c.Schedule.Jobs {
+		job := &c.Schedule.Jobs[i]
+		path := fmt.Sprintf("schedule.jobs[%d]", i)
+		switch {
+		case job.Name == "":
+			v.addf(path+".name", "is required")
+		case names[job.Name]:
+			v.addf(path+".name", "duplicate job name %q", job.Name)
+		}
+		names[job.Name] = true
+
+		switch {
+		case job.Cron == "" && job.Every == 0:
+			v.addf(path, "needs either cron or every")
+		case job.Cron != "" && job.Every != 0:
+			v.addf(path, "has both cron and every; use one")
+		case job.Cron != "":
+			if _, err := cron.Parse(job.Cron); err != nil {
+				v.addf(path+".cron", "invalid cron expression %q: %v", job.Cron, err)
+			}
+		case job.Every < 0:
+			v.addf(path+".every", "must be greater than zero, got %s", job.Every)
+		}
+		if job.Jitter != nil && *job.Jitter < 0 {
+			v.addf(path+".jitter", "must not be negative, got %s", *job.Jitter)
+		}
+		v.catchUp(path+".catch_up", job.CatchUp)
+	}
+
+	// Sites
+	for i := range c.Sites {
+		v.checkSite(fmt.Sprintf("sites[%d]", i), &c.Sites[i])
+	}
+
+	// Middleware
+	for i, pattern := range c.Middleware.Allow {
+		v.regex(fmt.Sprintf("middleware.allow[%d]", i), pattern)
+	}
+	for i, pattern := range c.Middleware.Deny {
+		v.regex(fmt.Sprintf("middleware.deny[%d]", i), pattern)
+	}
+}
+
+// checkSite validates a per-site profile
+func (v *validator) checkSite(path string, site *SiteConfig) {
+	if len(site.Hosts) == 0 && site.URLRegex == "" {
+		v.addf(path, "must set hosts or url_regex")
+	}
+	for i, host := range site.Hosts {
+		if _, err := pathpkg.Match(host, ""); err != nil || strings.ContainsAny(host, "/:") {
+			v.addf(fmt.Sprintf("%s.hosts[%d]", path, i), "invalid host pattern %q (e.g. shop.example.com or *.example.com)", host)
+		}
+	}
+	if site.URLRegex != "" {
+		v.regex(path+".url_regex", site.URLRegex)
+	}
+
+	if site.Extraction != nil {
+		for _, name := range sortedKeys(site.Extraction.Selectors) {
+			v.selector(path+".extraction.selectors."+name, site.Extraction.Selectors[name])
+		}
+		for _, name := range sortedKeys(site.Extraction.Regex) {
+			v.regex(path+".extraction.regex."+name, site.Extraction.Regex[name])
+		}
+	}
+	if site.WaitTime < 0 {
+		v.addf(path+".wait_time", "must not be negative, got %s", site.WaitTime)
+	}
+	if site.WaitFor != "" {
+		v.selector(path+".wait_for", site.WaitFor)
+	}
+	if site.Proxies != nil {
+		if site.Proxies.Enabled && len(site.Proxies.List) == 0 {
+			v.addf(path+".proxies.list", "must list at least one proxy when proxies are enabled")
+		}
+		for i, proxy := range site.Proxies.List {
+			v.proxyURL(fmt.Sprintf("%s.proxies.list[%d]", path, i), proxy)
+		}
+	}
+	if site.RateLimit < 0 {
+		v.addf(path+".rate_limit", "must not be negative, got %s", site.RateLimit)
+	}
+}
+
+// checkAuth validates the login configuration
+func (v *validator) checkAuth(auth *AuthConfig) {
+	switch auth.Type {
+	case "", "form", "browser":
+	default:
+		v.addf("auth.type", "unsupported login type %q (expected form or browser)", auth.Type)
+	}
+	switch auth.Scope {
+	case "", "domain", "session":
+	default:
+		v.addf("auth.scope", "unsupported scope %q (expected domain or session)", auth.Scope)
+	}
+	if auth.LoginURL == "" {
+		v.addf("auth.login_url", "is required when auth is enabled")
+	}
+
+	if auth.CSRF.Selector != "" {
+		v.selector("auth.csrf.selector", auth.CSRF.Selector)
+	}
+	if auth.SuccessSelector != "" {
+		v.selector("auth.success_selector", auth.SuccessSelector)
+	}
+
+	if auth.Type == "browser" {
+		if len(auth.Browser.Fields) == 0 {
+			v.addf("auth.browser.fields", "is required for browser logins")
+		}
+		for _, selector := range sortedKeys(auth.Browser.Fields) {
+			v.selector("auth.browser.fields."+selector, selector)
+		}
+		if auth.Browser.Submit == "" {
+			v.addf("auth.browser.submit", "is required for browser logins")
+		} else {
+			v.selector("auth.browser.submit", auth.Browser.Submit)
+		}
+		if auth.Browser.WaitFor != "" {
+			v.selector("auth.browser.wait_for", auth.Browser.WaitFor)
+		}
+	}
+
+	for i, status := range auth.ExpiredStatus {
+		if status < 100 || status > 599 {
+			v.addf(fmt.Sprintf("auth.expired_status[%d]", i), "invalid HTTP status %d", status)
+		}
+	}
+}
+
+// selector checks the syntax of a CSS selector
+func (v *validator) selector(path, selector string) {
+	if strings.TrimSpace(selector) == "" {
+		v.addf(path, "selector is empty")
+		return
+	}
+	if _, err := cascadia.ParseGroup(selector); err != nil {
+		v.addf(path, "invalid CSS selector %q: %v", selector, err)
+	}
+}
+
+// catchUp checks a scheduler catch-up policy
+func (v *validator) catchUp(path, policy string) {
+	switch policy {
+	case "", "skip", "once", "all":
+	default:
+		v.addf(path, "unsupported catch-up policy %q (expected skip, once or all)", policy)
+	}
+}
+
+// regex checks the syntax of a regular expression
+func (v *validator) regex(path, pattern string) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		v.addf(path, "invalid regular expression %q: %v", pattern, err)
+	}
+}
+
+// proxyURL checks that a proxy URL has a supported scheme and a host
+func (v *validator) proxyURL(path, proxy string) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		v.addf(path, "invalid proxy URL: %v", err)
+		return
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		v.addf(path, "unsupported proxy scheme %q in %q (expected http, https, socks5 or socks5h)", u.Scheme, proxy)
+		return
+	}
+	if u.Host == "" {
+		v.addf(path, "proxy URL %q has no host", proxy)
+	}
+}
+
+// httpURL checks that a URL is an absolute http or https URL
+func (v *validator) httpURL(path, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		v.addf(path, "invalid URL: %v", err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf(path, "must be an absolute http or https URL, got %q", raw)
+	}
+}
+
+// hasPath reports whether a problem was already found at path
+func hasPath(problems []Problem, path string) bool {
+	for _, p := range problems {
+		if p.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order, so problems are reported deterministically
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
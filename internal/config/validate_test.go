@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		path    string // Path of the one expected problem, empty for none
+		line    int
+		message string // Part of the expected message
+	}{
+		{
+			name: "valid",
+			yaml: "scraper:\n  workers: 2\nextraction:\n  selectors:\n    anything: h1\n",
+		},
+		{
+			name: "typo in a key",
+			yaml: "scraper:\n  workers: 2\n  worker: 3\n",
+			path: "scraper.worker", line: 3,
+			message: `unknown key "worker" (did you mean "workers"?)`,
+		},
+		{
+			name: "typo in a section",
+			yaml: "# comment\nscrapr:\n  workers: 1\n",
+			path: "scrapr", line: 2,
+			message: `did you mean "scraper"?`,
+		},
+		{
+			name: "wrong case",
+			yaml: "Scraper:\n  workers: 1\n",
+			path: "Scraper", line: 1,
+			message: `did you mean "scraper"?`,
+		},
+		{
+			name: "nothing close",
+			yaml: "logging:\n  colour_scheme: dark\n",
+			path: "logging.colour_scheme", line: 2,
+			message: "expected one of: file, format, level",
+		},
+		{
+			name: "key in a list item",
+			yaml: "sites:\n  - name: shop\n    hostz: [shop.example]\n",
+			path: "sites[0].hostz", line: 3,
+			message: `did you mean "hosts"?`,
+		},
+		{
+			name: "key in a pointer section",
+			yaml: "sites:\n  - name: shop\n    extraction:\n      selector: {}\n",
+			path: "sites[0].extraction.selector", line: 4,
+			message: `did you mean "selectors"?`,
+		},
+		{
+			name: "wrong type",
+			yaml: "scraper:\n  rate_limit: 1s\n  workers: many\n",
+			path: "scraper.workers", line: 3,
+			message: "cannot unmarshal",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg AppConfig
+			problems, err := decodeStrict([]byte(tt.yaml), &cfg)
+			if err != nil {
+				t.Fatalf("decodeStrict() error = %v", err)
+			}
+			if tt.path == "" {
+				if len(problems) > 0 {
+					t.Errorf("decodeStrict() = %v, want no problems", problems)
+				}
+				return
+			}
+			if len(problems) != 1 {
+				t.Fatalf("decodeStrict() = %v, want one problem", problems)
+			}
+			p := problems[0]
+			if p.Path != tt.path || p.Line != tt.line || !strings.Contains(p.Message, tt.message) {
+				t.Errorf("problem = %s, want %s (line %d) containing %q", p, tt.path, tt.line, tt.message)
+			}
+		})
+	}
+}
+
+func TestDecodeStrictSyntaxError(t *testing.T) {
+	var cfg AppConfig
+	if _, err := decodeStrict([]byte("scraper: [\n"), &cfg); err == nil {
+		t.Error("decodeStrict() of invalid YAML = nil error")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"workers", "workers", 0},
+		{"worker", "workers", 1},
+		{"wrokers", "workers", 2},
+		{"scrapr", "scraper", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRestrictKeys(t *testing.T) {
+	allowed := []string{"scraper.workers", "scraper.timeout", "extraction"}
+
+	tests := []struct {
+		name string
+		yaml string
+		want []string // Paths of the problems, as "path:line"
+	}{
+		{name: "empty", yaml: ""},
+		{name: "allowed keys", yaml: "scraper:\n  workers: 2\n  timeout: 5s\n"},
+		{name: "anything under an allowed path", yaml: "extraction:\n  selectors:\n    title: h1\n  regex: {}\n"},
+		{name: "disallowed section", yaml: "io:\n  output_file: x\n", want: []string{"io:1"}},
+		{
+			name: "disallowed key beside allowed ones",
+			yaml: "scraper:\n  workers: 2\n  user_agents: [x]\n  timeout: 1s\n",
+			want: []string{"scraper.user_agents:3"},
+		},
+		{
+			name: "several problems",
+			yaml: "proxies: {enabled: true}\nscraper:\n  max_retries: 9\nwebhook:\n  url: x\n",
+			want: []string{"proxies:1", "scraper.max_retries:3", "webhook:4"},
+		},
+		{name: "value instead of a section", yaml: "scraper: 3\n", want: []string{"scraper:1"}},
+		{
+			name: "merge key in a section",
+			yaml: "scraper:\n  <<: {user_agents: [x]}\n",
+			want: []string{"scraper.<<:2"},
+		},
+		{
+			name: "merge key at the root",
+			yaml: "base: &base\n  workers: 2\n<<: *base\n",
+			want: []string{"base:1", "<<:3"},
+		},
+		{
+			name: "alias of an allowed value",
+			yaml: "extraction: &e\n  selectors: {}\nscraper:\n  workers: 2\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := restrictKeys([]byte(tt.yaml), allowed)
+			if err != nil {
+				t.Fatalf("restrictKeys() error = %v", err)
+			}
+			var got []string
+			for _, p := range problems {
+				got = append(got, fmt.Sprintf("%s:%d", p.Path, p.Line))
+				if p.Message != "can't be overridden" {
+					t.Errorf("problem message = %q", p.Message)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("restrictKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeOnly(t *testing.T) {
+	base := Defaults()
+
+	merged, err := base.MergeOnly([]byte(`{"scraper": {"workers": 7}}`), []string{"scraper.workers"})
+	if err != nil {
+		t.Fatalf("MergeOnly() error = %v", err)
+	}
+	if merged.Scraper.Workers != 7 || base.Scraper.Workers == 7 {
+		t.Errorf("workers = %d (base %d), want 7 on the copy only", merged.Scraper.Workers, base.Scraper.Workers)
+	}
+	if merged.Scraper.Timeout != base.Scraper.Timeout {
+		t.Errorf("timeout = %s, want the base %s", merged.Scraper.Timeout, base.Scraper.Timeout)
+	}
+
+	_, err = base.MergeOnly([]byte(`{"scraper": {"timeout": "1s"}}`), []string{"scraper.workers"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0].Path != "scraper.timeout" {
+		t.Errorf("MergeOnly() of a disallowed key = %v, want a problem at scraper.timeout", err)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := &ValidationError{File: "config.yaml", Problems: []Problem{
+		{Path: "scraper.workers", Line: 3, Message: "must be greater than zero, got 0"},
+		{Path: "io.output_format", Message: `must be one of json, sqlite, got "xml"`},
+		{Line: 7, Message: "syntax error"},
+	}}
+	want := "invalid configuration config.yaml:\n" +
+		"  scraper.workers (line 3): must be greater than zero, got 0\n" +
+		`  io.output_format: must be one of json, sqlite, got "xml"` + "\n" +
+		"  line 7: syntax error"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
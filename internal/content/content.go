@@ -0,0 +1,109 @@
+package content
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Kind identifies how a response body should be processed
+type Kind int
+
+const (
+	// HTML documents are parsed with goquery and run through the extractor
+	HTML Kind = iota
+	// Feed documents are RSS or Atom feeds parsed into feed items
+	Feed
+	// PDF documents have their text and metadata extracted
+	PDF
+	// Text documents are stored as they are
+	Text
+	// Binary documents are saved to the download directory
+	Binary
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case HTML:
+		return "html"
+	case Feed:
+		return "feed"
+	case PDF:
+		return "pdf"
+	case Text:
+		return "text"
+	default:
+		return "binary"
+	}
+}
+
+// MediaType returns the media type of a Content-Type header, sniffing the
+// body when the header is missing
+func MediaType(contentType string, body []byte) string {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
+
+// Detect determines how to process a body of the given media type
+func Detect(mediaType string, body []byte) Kind {
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		return HTML
+	case mediaType == "application/rss+xml" || mediaType == "application/atom+xml" || mediaType == "application/rdf+xml":
+		return Feed
+	case mediaType == "application/pdf":
+		return PDF
+	case strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml"):
+		// Feeds are often served as generic XML
+		if IsFeed(body) {
+			return Feed
+		}
+		return Text
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return Text
+	default:
+		return Binary
+	}
+}
+
+// Allowed reports whether a media type matches one of the patterns. Patterns
+// may end in "/*" to match a whole family. An empty list allows everything.
+func Allowed(mediaType string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*" || pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if matched, _ := path.Match(pattern, mediaType); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFeed reports whether an XML document looks like an RSS or Atom feed
+func IsFeed(body []byte) bool {
+	head := body
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.ToLower(head)
+
+	return bytes.Contains(head, []byte("<rss")) ||
+		bytes.Contains(head, []byte("<feed")) ||
+		bytes.Contains(head, []byte("<rdf:rdf"))
+}
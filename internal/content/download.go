@@ -0,0 +1,65 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Hash returns the hex-encoded SHA-256 hash of a body
+func Hash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save stores a body in dir under its content hash and returns the file path.
+// Identical bodies are only written once.
+func Save(dir string, body []byte, mediaType, rawURL string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	filename := filepath.Join(dir, Hash(body)+extension(mediaType, rawURL))
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+
+	// Write to a temporary file first so concurrent workers never see a partial file
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// extension picks a file extension from the URL path, falling back to the media type
+func extension(mediaType, rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if ext := path.Ext(u.Path); ext != "" && len(ext) <= 6 {
+			return ext
+		}
+	}
+
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,155 @@
+package content
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+	"golang.org/x/net/html/charset"
+)
+
+// FeedDocument holds the parsed contents of an RSS or Atom feed
+type FeedDocument struct {
+	Title string
+	Items []models.FeedItem
+}
+
+// rssDocument covers RSS 2.0 and RSS 1.0 (RDF) feeds
+type rssDocument struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items []rssItem `xml:"item"` // RSS 1.0 places items next to the channel
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+}
+
+type atomDocument struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string `xml:"title"`
+	ID        string `xml:"id"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+// ParseFeed parses an RSS 2.0, RSS 1.0 or Atom feed
+func ParseFeed(body []byte) (*FeedDocument, error) {
+	root, err := rootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(root) {
+	case "rss", "rdf":
+		var doc rssDocument
+		if err := decodeXML(body, &doc); err != nil {
+			return nil, err
+		}
+
+		feed := &FeedDocument{Title: strings.TrimSpace(doc.Channel.Title)}
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			feed.Items = append(feed.Items, models.FeedItem{
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				ID:        strings.TrimSpace(item.GUID),
+				Published: strings.TrimSpace(firstNonEmpty(item.PubDate, item.Date)),
+				Summary:   strings.TrimSpace(item.Description),
+				Author:    strings.TrimSpace(firstNonEmpty(item.Author, item.Creator)),
+			})
+		}
+		return feed, nil
+
+	case "feed":
+		var doc atomDocument
+		if err := decodeXML(body, &doc); err != nil {
+			return nil, err
+		}
+
+		feed := &FeedDocument{Title: strings.TrimSpace(doc.Title)}
+		for _, entry := range doc.Entries {
+			item := models.FeedItem{
+				Title:     strings.TrimSpace(entry.Title),
+				ID:        strings.TrimSpace(entry.ID),
+				Published: strings.TrimSpace(firstNonEmpty(entry.Published, entry.Updated)),
+				Summary:   strings.TrimSpace(firstNonEmpty(entry.Summary, entry.Content)),
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					item.Link = link.Href
+					break
+				}
+			}
+			if len(entry.Authors) > 0 {
+				item.Author = strings.TrimSpace(entry.Authors[0].Name)
+			}
+			feed.Items = append(feed.Items, item)
+		}
+		return feed, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported feed root element <%s>", root)
+	}
+}
+
+// rootElement returns the local name of the document's root element
+func rootElement(body []byte) (string, error) {
+	decoder := newDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("parsing feed: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func decodeXML(body []byte, v interface{}) error {
+	if err := newDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("parsing feed: %w", err)
+	}
+	return nil
+}
+
+// newDecoder creates a lenient XML decoder that understands non-UTF-8 feeds
+func newDecoder(body []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	return decoder
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package content
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// PDFDocument holds the text and metadata extracted from a PDF
+type PDFDocument struct {
+	Text     string
+	Metadata map[string]string
+}
+
+// pdfInfoKeys are the document information entries copied into the metadata
+var pdfInfoKeys = []string{"Title", "Author", "Subject", "Keywords", "Creator", "Producer", "CreationDate", "ModDate"}
+
+// ParsePDF extracts the plain text and document information of a PDF
+func ParsePDF(body []byte) (doc *PDFDocument, err error) {
+	// The PDF reader panics on some malformed documents
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parsing PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing PDF: %w", err)
+	}
+
+	metadata := map[string]string{
+		"pages": strconv.Itoa(reader.NumPage()),
+	}
+	info := reader.Trailer().Key("Info")
+	for _, key := range pdfInfoKeys {
+		if value := strings.TrimSpace(info.Key(key).Text()); value != "" {
+			metadata[strings.ToLower(key)] = value
+		}
+	}
+
+	textReader, err := reader.GetPlainText()
+	if err != nil {
+		return nil, fmt.Errorf("extracting PDF text: %w", err)
+	}
+	text, err := io.ReadAll(textReader)
+	if err != nil {
+		return nil, fmt.Errorf("extracting PDF text: %w", err)
+	}
+
+	return &PDFDocument{
+		Text:     strings.TrimSpace(string(text)),
+		Metadata: metadata,
+	}, nil
+}
@@ -0,0 +1,359 @@
+package cookies
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie is a portable representation of a cookie used for import and export.
+// A Domain with a leading dot matches subdomains; without one the cookie is host-only.
+type Cookie struct {
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	Expires  time.Time
+	Secure   bool
+	HTTPOnly bool
+}
+
+// Jar is a per-domain cookie jar shared by all workers that also remembers
+// every cookie it has seen so the session can be exported at the end of a run
+type Jar struct {
+	jar     *cookiejar.Jar
+	mu      sync.Mutex
+	cookies map[string]Cookie
+}
+
+// NewJar creates a new empty cookie jar
+func NewJar() *Jar {
+	// cookiejar.New only fails on invalid options
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &Jar{
+		jar:     jar,
+		cookies: make(map[string]Cookie),
+	}
+}
+
+// Cookies implements http.CookieJar
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range cookies {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   u.Hostname(),
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+		}
+		if c.Domain != "" {
+			cookie.Domain = "." + strings.TrimPrefix(c.Domain, ".")
+		}
+		if cookie.Path == "" || !strings.HasPrefix(cookie.Path, "/") {
+			cookie.Path = defaultPath(u.Path)
+		}
+
+		// Honour deletions so that expired sessions are not exported
+		switch {
+		case c.MaxAge < 0:
+			delete(j.cookies, key(cookie))
+			continue
+		case c.MaxAge > 0:
+			cookie.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if !cookie.Expires.IsZero() && cookie.Expires.Before(time.Now()) {
+			delete(j.cookies, key(cookie))
+			continue
+		}
+
+		// Only export what the jar accepted
+		if !j.stored(cookie) {
+			continue
+		}
+		j.cookies[key(cookie)] = cookie
+	}
+}
+
+// stored reports whether the underlying jar holds the cookie. It rejects
+// cookies set for another domain or for a public suffix such as co.uk.
+func (j *Jar) stored(c Cookie) bool {
+	host := strings.TrimPrefix(c.Domain, ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	for _, hc := range j.jar.Cookies(&url.URL{Scheme: "https", Host: host, Path: c.Path}) {
+		if hc.Name == c.Name && hc.Value == c.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Add seeds the jar with the given cookies
+func (j *Jar) Add(cookies []Cookie) {
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" {
+			continue
+		}
+
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+
+		hc := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		if strings.HasPrefix(c.Domain, ".") {
+			hc.Domain = host
+		}
+
+		j.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, []*http.Cookie{hc})
+	}
+}
+
+// All returns every unexpired cookie in the jar, sorted by domain, path and name
+func (j *Jar) All() []Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	cookies := make([]Cookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if !c.Expires.IsZero() && c.Expires.Before(now) {
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+
+	sort.Slice(cookies, func(a, b int) bool {
+		return key(cookies[a]) < key(cookies[b])
+	})
+	return cookies
+}
+
+// Load seeds the jar from a Netscape cookies.txt or JSON file
+func (j *Jar) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var cookies []Cookie
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		cookies, err = parseJSON(data)
+	} else {
+		cookies, err = parseNetscape(trimmed)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing cookies from %s: %w", filename, err)
+	}
+
+	j.Add(cookies)
+	return nil
+}
+
+// Save writes the jar to disk, as JSON when the file has a .json extension
+// and in Netscape cookies.txt format otherwise
+func (j *Jar) Save(filename string) error {
+	cookies := j.All()
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		var err error
+		data, err = formatJSON(cookies)
+		if err != nil {
+			return err
+		}
+	} else {
+		data = formatNetscape(cookies)
+	}
+
+	return os.WriteFile(filename, data, 0600)
+}
+
+// jsonCookie is the JSON representation of a cookie, compatible with the
+// format used by browser devtools and most cookie export extensions
+type jsonCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires,omitempty"`
+	Secure   bool    `json:"secure"`
+	HTTPOnly bool    `json:"httpOnly"`
+}
+
+func parseJSON(data []byte) ([]Cookie, error) {
+	var records []jsonCookie
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+
+	cookies := make([]Cookie, 0, len(records))
+	for _, r := range records {
+		c := Cookie{
+			Name:     r.Name,
+			Value:    r.Value,
+			Domain:   r.Domain,
+			Path:     r.Path,
+			Secure:   r.Secure,
+			HTTPOnly: r.HTTPOnly,
+		}
+		if r.Expires > 0 {
+			c.Expires = time.Unix(int64(r.Expires), 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, nil
+}
+
+func formatJSON(cookies []Cookie) ([]byte, error) {
+	records := make([]jsonCookie, 0, len(cookies))
+	for _, c := range cookies {
+		r := jsonCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+		}
+		if !c.Expires.IsZero() {
+			r.Expires = float64(c.Expires.Unix())
+		}
+		records = append(records, r)
+	}
+	return json.MarshalIndent(records, "", "  ")
+}
+
+// httpOnlyPrefix marks HttpOnly cookies in the Netscape format
+const httpOnlyPrefix = "#HttpOnly_"
+
+func parseNetscape(data string) ([]Cookie, error) {
+	var cookies []Cookie
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(text, httpOnlyPrefix) {
+			httpOnly = true
+			text = strings.TrimPrefix(text, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expires, name, value
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", line, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", line, fields[4])
+		}
+
+		c := Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HTTPOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(c.Domain, ".") {
+			c.Domain = "." + c.Domain
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+
+	return cookies, scanner.Err()
+}
+
+func formatNetscape(cookies []Cookie) []byte {
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n")
+
+	for _, c := range cookies {
+		domain := c.Domain
+		if c.HTTPOnly {
+			domain = httpOnlyPrefix + domain
+		}
+
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain,
+			boolString(strings.HasPrefix(c.Domain, ".")),
+			c.Path,
+			boolString(c.Secure),
+			expires,
+			c.Name,
+			c.Value,
+		)
+	}
+
+	return []byte(b.String())
+}
+
+func boolString(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// key identifies a cookie the same way a browser does: by domain, path and name
+func key(c Cookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// defaultPath computes the default cookie path for a request path (RFC 6265 section 5.1.4)
+func defaultPath(p string) string {
+	if p == "" || !strings.HasPrefix(p, "/") {
+		return "/"
+	}
+	return path.Dir(p)
+}
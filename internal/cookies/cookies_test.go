@@ -0,0 +1,143 @@
+package cookies
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetCookies(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		cookies []*http.Cookie
+		want    []string // Exported cookies, as "domain path name=value"
+	}{
+		{
+			name:    "host-only",
+			url:     "https://example.com/account/login",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1"}},
+			want:    []string{"example.com /account sid=1"},
+		},
+		{
+			name:    "domain cookie from a subdomain",
+			url:     "https://www.example.com/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Domain: "example.com", Path: "/"}},
+			want:    []string{".example.com / sid=1"},
+		},
+		{
+			name:    "host with a port",
+			url:     "http://localhost:8080/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Path: "/"}},
+			want:    []string{"localhost / sid=1"},
+		},
+		{
+			name:    "IP address",
+			url:     "http://127.0.0.1/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Path: "/"}},
+			want:    []string{"127.0.0.1 / sid=1"},
+		},
+		{
+			name:    "another domain",
+			url:     "https://evil.example/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Domain: "bank.example", Path: "/"}},
+		},
+		{
+			name:    "sibling subdomain",
+			url:     "https://a.example.com/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Domain: "b.example.com", Path: "/"}},
+		},
+		{
+			name:    "public suffix",
+			url:     "https://shop.example.co.uk/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Domain: "co.uk", Path: "/"}},
+		},
+		{
+			name: "accepted beside rejected",
+			url:  "https://www.example.com/",
+			cookies: []*http.Cookie{
+				{Name: "a", Value: "1", Domain: "example.com", Path: "/"},
+				{Name: "b", Value: "2", Domain: "other.com", Path: "/"},
+			},
+			want: []string{".example.com / a=1"},
+		},
+		{
+			name: "deleted",
+			url:  "https://example.com/",
+			cookies: []*http.Cookie{
+				{Name: "sid", Value: "1", Path: "/"},
+				{Name: "sid", Value: "", Path: "/", MaxAge: -1},
+			},
+		},
+		{
+			name:    "already expired",
+			url:     "https://example.com/",
+			cookies: []*http.Cookie{{Name: "sid", Value: "1", Path: "/", Expires: time.Now().Add(-time.Hour)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jar := NewJar()
+			for _, c := range tt.cookies {
+				jar.SetCookies(u, []*http.Cookie{c})
+			}
+
+			var got []string
+			for _, c := range jar.All() {
+				got = append(got, c.Domain+" "+c.Path+" "+c.Name+"="+c.Value)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exported %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	expires := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	cookies := []Cookie{
+		{Name: "sid", Value: "abc", Domain: ".example.com", Path: "/", Expires: expires, Secure: true, HTTPOnly: true},
+		{Name: "theme", Value: "dark", Domain: "www.example.com", Path: "/app"},
+	}
+
+	for _, name := range []string{"cookies.txt", "cookies.json"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), name)
+			jar := NewJar()
+			jar.Add(cookies)
+			if err := jar.Save(file); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := NewJar()
+			if err := loaded.Load(file); err != nil {
+				t.Fatal(err)
+			}
+			got := loaded.All()
+			if len(got) != len(cookies) {
+				t.Fatalf("loaded %+v, want %+v", got, cookies)
+			}
+			for i := range cookies {
+				if got[i].Name != cookies[i].Name || got[i].Value != cookies[i].Value ||
+					got[i].Domain != cookies[i].Domain || got[i].Path != cookies[i].Path ||
+					!got[i].Expires.Equal(cookies[i].Expires) ||
+					got[i].Secure != cookies[i].Secure || got[i].HTTPOnly != cookies[i].HTTPOnly {
+					t.Errorf("cookie %d = %+v, want %+v", i, got[i], cookies[i])
+				}
+			}
+
+			// The loaded cookies are sent with matching requests
+			u, _ := url.Parse("https://www.example.com/app/page")
+			if sent := loaded.Cookies(u); len(sent) != 2 {
+				t.Errorf("Cookies(%s) = %v, want both cookies", u, sent)
+			}
+		})
+	}
+}
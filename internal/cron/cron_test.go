@@ -0,0 +1,130 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	span := func(low, high, step int) uint64 {
+		var b uint64
+		for v := low; v <= high; v += step {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+
+	tests := []struct {
+		expr string
+		want Schedule
+	}{
+		{"* * * * *", Schedule{minute: span(0, 59, 1), hour: span(0, 23, 1), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), domAny: true, dowAny: true}},
+		{"@daily", Schedule{minute: bits(0), hour: bits(0), dom: span(1, 31, 1), month: span(1, 12, 1), dow: span(0, 7, 1), domAny: true, dowAny: true}},
+		{"*/15 9-17 1,15 * *", Schedule{minute: bits(0, 15, 30, 45), hour: span(9, 17, 1), dom: bits(1, 15), month: span(1, 12, 1), dow: span(0, 7, 1), dowAny: true}},
+		{"5/20 0 * jan-mar mon-fri", Schedule{minute: bits(5, 25, 45), hour: bits(0), dom: span(1, 31, 1), month: bits(1, 2, 3), dow: span(1, 5, 1), domAny: true}},
+		{"0 12 * DEC 7", Schedule{minute: bits(0), hour: bits(12), dom: span(1, 31, 1), month: bits(12), dow: bits(0, 7), domAny: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+			}
+			if *got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.expr, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@often",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should fail", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// São Paulo's clocks went forward at midnight, so 2018-11-04 had no 00:00
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// The repeated hour when New York's clocks go back, in standard time
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next minute", "* * * * *", at(time.UTC, "2026-05-01 10:30"), at(time.UTC, "2026-05-01 10:31")},
+		{"later today", "30 14 * * *", at(time.UTC, "2026-05-01 10:30"), at(time.UTC, "2026-05-01 14:30")},
+		{"tomorrow", "30 9 * * *", at(time.UTC, "2026-05-01 10:30"), at(time.UTC, "2026-05-02 09:30")},
+		{"next month", "0 0 1 * *", at(time.UTC, "2026-05-01 00:00"), at(time.UTC, "2026-06-01 00:00")},
+		{"day of week", "0 8 * * mon", at(time.UTC, "2026-05-01 10:30"), at(time.UTC, "2026-05-04 08:00")},
+		{"either day field", "0 0 13 * fri", at(time.UTC, "2026-05-02 00:00"), at(time.UTC, "2026-05-08 00:00")},
+		{"leap day", "0 0 29 2 *", at(time.UTC, "2026-03-01 00:00"), at(time.UTC, "2028-02-29 00:00")},
+		{"never", "0 0 30 2 *", at(time.UTC, "2026-03-01 00:00"), time.Time{}},
+
+		{"spring forward, before the gap", "0 * * * *", at(newYork, "2026-03-08 01:30"), at(newYork, "2026-03-08 03:00")},
+		{"spring forward, every minute", "* * * * *", at(newYork, "2026-03-08 01:59"), at(newYork, "2026-03-08 03:00")},
+		{"spring forward, time in the gap", "30 2 * * *", at(newYork, "2026-03-08 00:00"), at(newYork, "2026-03-09 02:30")},
+		{"spring forward, after the gap", "0 3 * * *", at(newYork, "2026-03-08 00:00"), at(newYork, "2026-03-08 03:00")},
+		{"fall back, first pass", "30 1 * * *", at(newYork, "2026-11-01 00:00"), at(newYork, "2026-11-01 01:30")},
+		{"fall back, second pass", "30 1 * * *", at(newYork, "2026-11-01 01:30"), at(est, "2026-11-01 01:30")},
+		{"fall back, after the repeat", "30 1 * * *", at(est, "2026-11-01 01:30"), at(newYork, "2026-11-02 01:30")},
+		{"fall back, hourly", "0 * * * *", at(est, "2026-11-01 01:00"), at(newYork, "2026-11-01 02:00")},
+
+		{"midnight gap, daily", "0 0 * * *", at(saoPaulo, "2018-11-03 12:00"), at(saoPaulo, "2018-11-05 00:00")},
+		{"midnight gap, hourly", "0 * * * *", at(saoPaulo, "2018-11-03 23:30"), at(saoPaulo, "2018-11-04 01:00")},
+		{"midnight gap, next day", "0 12 4 11 *", at(saoPaulo, "2018-11-03 12:00"), at(saoPaulo, "2018-11-04 12:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+			}
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) for %q = %v, want %v", tt.from, tt.expr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package io
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/proxy"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+
+	// Pure-Go SQLite driver, keeps the build cgo-free
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables shared by every run; the extracted table
+// gets one column per field on top of these
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT,
+	pages       INTEGER NOT NULL DEFAULT 0,
+	failures    INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS pages (
+	url          TEXT PRIMARY KEY,
+	run_id       INTEGER NOT NULL REFERENCES runs(id),
+	status_code  INTEGER,
+	error        TEXT,
+	error_class  TEXT,
+	duration_ms  INTEGER NOT NULL,
+	retries      INTEGER NOT NULL,
+	fetched_at   TEXT NOT NULL,
+	content_type TEXT,
+	content_hash TEXT,
+	encoding     TEXT,
+	size         INTEGER,
+	js_rendered  INTEGER NOT NULL,
+	fetch_path   TEXT,
+	escalation   TEXT,
+	proxy_used   TEXT,
+	site         TEXT,
+	screenshot   TEXT,
+	download     TEXT,
+	headers      TEXT,
+	metadata     TEXT
+);
+
+CREATE TABLE IF NOT EXISTS extracted (
+	url    TEXT PRIMARY KEY REFERENCES pages(url),
+	run_id INTEGER NOT NULL REFERENCES runs(id)
+);
+`
+
+// upsertPage inserts a page or replaces the row from an earlier run
+const upsertPage = `
+INSERT INTO pages (
+	url, run_id, status_code, error, error_class, duration_ms, retries, fetched_at,
+	content_type, content_hash, encoding, size, js_rendered, fetch_path, escalation,
+	proxy_used, site, screenshot, download, headers, metadata
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(url) DO UPDATE SET
+	run_id = excluded.run_id,
+	status_code = excluded.status_code,
+	error = excluded.error,
+	error_class = excluded.error_class,
+	duration_ms = excluded.duration_ms,
+	retries = excluded.retries,
+	fetched_at = excluded.fetched_at,
+	content_type = excluded.content_type,
+	content_hash = excluded.content_hash,
+	encoding = excluded.encoding,
+	size = excluded.size,
+	js_rendered = excluded.js_rendered,
+	fetch_path = excluded.fetch_path,
+	escalation = excluded.escalation,
+	proxy_used = excluded.proxy_used,
+	site = excluded.site,
+	screenshot = excluded.screenshot,
+	download = excluded.download,
+	headers = excluded.headers,
+	metadata = excluded.metadata
+`
+
+// SQLiteWriter upserts results into a SQLite database as they arrive.
+// Pages are keyed by URL, so rerunning a scrape updates rows instead of
+// duplicating them.
+type SQLiteWriter struct {
+	db      *sql.DB
+	runID   int64
+	mu      sync.Mutex
+	columns map[string]bool
+}
+
+// NewSQLiteWriter opens (or creates) the database, adds a column to the
+// extracted table for every field of the given extraction rules and starts
+// a new run
+func NewSQLiteWriter(filename string, extraction ...*config.ExtractionConfig) (*SQLiteWriter, error) {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialise through one connection
+	db.SetMaxOpenConns(1)
+
+	w := &SQLiteWriter{db: db, columns: make(map[string]bool)}
+	if err := w.init(extraction); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("preparing %s: %w", filename, err)
+	}
+	return w, nil
+}
+
+// init creates the schema and the run row
+func (w *SQLiteWriter) init(extraction []*config.ExtractionConfig) error {
+	if _, err := w.db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	// Find the field columns left by earlier runs
+	rows, err := w.db.Query(`SELECT name FROM pragma_table_info('extracted')`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		w.columns[strings.ToLower(name)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Add the configured fields up front so the table has them even when empty
+	for _, name := range fieldNames(extraction) {
+		if err := w.ensureColumn(name); err != nil {
+			return err
+		}
+	}
+
+	result, err := w.db.Exec(`INSERT INTO runs (started_at) VALUES (?)`, formatTime(time.Now()))
+	if err != nil {
+		return err
+	}
+	w.runID, err = result.LastInsertId()
+	return err
+}
+
+// Write upserts the result's page row and extracted fields
+func (w *SQLiteWriter) Write(result models.Result) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Fields from custom extractors may not be configured; give them columns too
+	names := make([]string, 0, len(result.Extracted))
+	for name := range result.Extracted {
+		if isKeyColumn(name) {
+			slog.Warn("Extracted field clashes with a key column and is not stored", "field", name)
+			continue
+		}
+		if err := w.ensureColumn(name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var proxyUsed string
+	if result.ProxyUsed != "" {
+		proxyUsed = proxy.Redacted(result.ProxyUsed)
+	}
+	_, err = tx.Exec(upsertPage,
+		result.URL, w.runID, nullInt(result.StatusCode), nullString(result.Err), nullString(result.ErrorClass),
+		result.Duration.Milliseconds(), result.Retries, formatTime(result.Timestamp),
+		nullString(result.ContentType), nullString(result.ContentHash), nullString(result.Encoding),
+		nullInt(int(result.Size)), result.JSRendered, nullString(result.FetchPath), nullString(result.Escalation),
+		nullString(proxyUsed), nullString(result.Site), nullString(result.Screenshot), nullString(result.Download),
+		jsonColumn(result.Headers), jsonColumn(result.Metadata),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Replace the whole extracted row so fields missing from this run are cleared
+	if _, err := tx.Exec(`DELETE FROM extracted WHERE url = ?`, result.URL); err != nil {
+		return err
+	}
+	columns := []string{"url", "run_id"}
+	args := []any{result.URL, w.runID}
+	for _, name := range names {
+		columns = append(columns, quoteIdent(name))
+		args = append(args, fieldValue(result.Extracted[name]))
+	}
+	query := fmt.Sprintf("INSERT INTO extracted (%s) VALUES (%s)",
+		strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close records the run's totals and closes the database
+func (w *SQLiteWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := w.db.Exec(`
+		UPDATE runs SET
+			finished_at = ?,
+			pages = (SELECT COUNT(*) FROM pages WHERE run_id = ?),
+			failures = (SELECT COUNT(*) FROM pages WHERE run_id = ? AND error IS NOT NULL)
+		WHERE id = ?`,
+		formatTime(time.Now()), w.runID, w.runID, w.runID)
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ensureColumn adds a TEXT column for the field if the extracted table lacks
+// one; SQLite column names are case-insensitive
+func (w *SQLiteWriter) ensureColumn(name string) error {
+	if w.columns[strings.ToLower(name)] {
+		return nil
+	}
+	if _, err := w.db.Exec(fmt.Sprintf("ALTER TABLE extracted ADD COLUMN %s TEXT", quoteIdent(name))); err != nil {
+		return fmt.Errorf("adding column for field %q: %w", name, err)
+	}
+	w.columns[strings.ToLower(name)] = true
+	return nil
+}
+
+// fieldNames returns every field named by the extraction rules
+func fieldNames(extraction []*config.ExtractionConfig) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range extraction {
+		for _, rules := range []map[string]string{e.Selectors, e.XPath, e.Regex} {
+			for name := range rules {
+				if !seen[name] && !isKeyColumn(name) {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isKeyColumn reports whether a field name clashes with a key column of the
+// extracted table
+func isKeyColumn(name string) bool {
+	return strings.EqualFold(name, "url") || strings.EqualFold(name, "run_id")
+}
+
+// fieldValue stores single values as text and anything else as JSON
+func fieldValue(value interface{}) any {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return v
+	default:
+		return jsonColumn(v)
+	}
+}
+
+// jsonColumn encodes a value as JSON, or NULL when it is empty
+func jsonColumn[T any](value T) any {
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" || string(data) == "{}" {
+		return nil
+	}
+	return string(data)
+}
+
+// quoteIdent quotes a field name for use as a column name
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// nullString maps "" to NULL
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullInt maps 0 to NULL
+func nullInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// formatTime formats a timestamp the way SQLite's date functions expect
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
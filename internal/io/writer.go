@@ -12,6 +12,7 @@ import (
 // ResultWriter writes results to various outputs
 type ResultWriter struct {
 	Config *config.IOConfig
+	sqlite *SQLiteWriter
 }
 
 // NewResultWriter creates a new result writer
@@ -21,7 +22,32 @@ func NewResultWriter(config *config.IOConfig) *ResultWriter {
 	}
 }
 
-// SaveToFile saves the results to a file in the specified format
+// Open prepares formats that are written as results arrive. The fields of
+// the given extraction rules become columns in the sqlite format.
+func (w *ResultWriter) Open(extraction ...*config.ExtractionConfig) error {
+	if w.Config.OutputFormat != "sqlite" {
+		return nil
+	}
+
+	sqlite, err := NewSQLiteWriter(w.Config.OutputFile, extraction...)
+	if err != nil {
+		return err
+	}
+	w.sqlite = sqlite
+	return nil
+}
+
+// Write records a single result as it arrives; formats that are saved in
+// one go by SaveToFile ignore it
+func (w *ResultWriter) Write(result models.Result) error {
+	if w.sqlite != nil {
+		return w.sqlite.Write(result)
+	}
+	return nil
+}
+
+// SaveToFile saves the results to a file in the specified format. Formats
+// written as results arrive already hold them and are only closed.
 func (w *ResultWriter) SaveToFile(results []models.Result) error {
 	switch w.Config.OutputFormat {
 	case "json":
@@ -31,6 +57,12 @@ func (w *ResultWriter) SaveToFile(results []models.Result) error {
 		}
 		return os.WriteFile(w.Config.OutputFile, data, 0644)
 
+	case "sqlite":
+		if w.sqlite == nil {
+			return fmt.Errorf("sqlite output was not opened")
+		}
+		return w.sqlite.Close()
+
 	case "csv":
 		// Implement CSV output if needed
 		return fmt.Errorf("CSV output not implemented yet")
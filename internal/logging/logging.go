@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// Setup installs a structured logger as the slog default according to the
+// configuration. Logs go to stderr unless a log file is configured, keeping
+// them apart from the results. The returned function closes the log file.
+func Setup(config *config.LoggingConfig) (func() error, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	closer := func() error { return nil }
+	if config.File != "" {
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("opening log file: %w", err)
+		}
+		out = file
+		closer = file.Close
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		closer()
+		return nil, fmt.Errorf("unsupported log format: %s", config.Format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+// ToTerminal reports whether the configured logs are written to a terminal
+func ToTerminal(config *config.LoggingConfig) bool {
+	if config.File != "" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ParseLevel converts a level name (debug, info, warn or error) into a slog level
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("unsupported log level: %s", name)
+	}
+	return level, nil
+}
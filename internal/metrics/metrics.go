@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scraper"
+
+// Registry holds every scraper metric along with the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Requests counts HTTP requests by host and status code ("error" when no response was received)
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "HTTP requests made, by host and status code.",
+	}, []string{"host", "status"})
+
+	// Retries counts retried fetches by host
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Fetch retries, by host.",
+	}, []string{"host"})
+
+	// BytesDownloaded counts response body bytes read from the wire by host
+	BytesDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloaded_bytes_total",
+		Help:      "Response body bytes downloaded, by host.",
+	}, []string{"host"})
+
+	// FetchDuration observes the time taken to fetch a URL, including retries
+	FetchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Time taken to fetch a URL including retries, by host and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"host", "outcome"})
+
+	// QueueDepth tracks the number of URLs waiting in worker pool queues
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "URLs waiting to be processed.",
+	})
+
+	// ActiveWorkers tracks the number of workers currently fetching a URL
+	ActiveWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_workers",
+		Help:      "Workers currently fetching a URL.",
+	})
+
+	// ProxyFailures counts requests that failed while going through a proxy
+	ProxyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_failures_total",
+		Help:      "Requests that failed through a proxy, by proxy host.",
+	}, []string{"proxy"})
+
+	// BrowserTabs tracks the number of open headless browser tabs
+	BrowserTabs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "browser_tabs",
+		Help:      "Headless browser tabs currently open.",
+	})
+
+	// BrowserEscalations counts HTTP fetches retried in the browser by the hybrid scraper
+	BrowserEscalations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "browser_escalations_total",
+		Help:      "HTTP fetches escalated to the browser, by host and reason.",
+	}, []string{"host", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		Requests,
+		Retries,
+		BytesDownloaded,
+		FetchDuration,
+		QueueDepth,
+		ActiveWorkers,
+		ProxyFailures,
+		BrowserTabs,
+		BrowserEscalations,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// retried mirrors Retries across every host for readers in this process
+var retried atomic.Int64
+
+// Retry records a retried fetch for a host
+func Retry(host string) {
+	Retries.WithLabelValues(host).Inc()
+	retried.Add(1)
+}
+
+// RetriesTotal returns the number of retries recorded so far across every host
+func RetriesTotal() int64 {
+	return retried.Load()
+}
+
+// Host returns the host label for a URL
+func Host(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Hostname()
+}
+
+// Serve starts the metrics endpoint in the background. The returned server
+// can be shut down once the run is over.
+func Serve(addr, path string) (*http.Server, error) {
+	if path == "" {
+		path = "/metrics"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	return server, nil
+}
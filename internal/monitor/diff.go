@@ -0,0 +1,182 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffEdits bounds the number of changed lines the diff searches for;
+// texts further apart are diffed as one block after trimming their common
+// ends. The search keeps O(edits²) ints, about 8 MB at the bound.
+const maxDiffEdits = 1000
+
+// edit is one line of a diff
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// Diff returns a unified diff of two texts given as lines, with the given
+// number of unchanged lines around each change, or "" if they are equal
+func Diff(old, new []string, context int) string {
+	edits := diffLines(old, new)
+
+	// Find the hunks: runs of changes with their context, merged when they overlap
+	var b strings.Builder
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		from := max(start-context, 0)
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			// Stop once the unchanged run is too long to bridge to the next change
+			run := end
+			for run < len(edits) && edits[run].op == ' ' {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end = min(end+context, len(edits))
+				break
+			}
+			end = run
+		}
+
+		oldLine, newLine := position(edits[:from])
+		oldCount, newCount := position(edits[from:end])
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldLine+1, oldCount, newLine+1, newCount)
+		for _, e := range edits[from:end] {
+			fmt.Fprintf(&b, "%c%s\n", e.op, e.line)
+		}
+		start = end
+	}
+	return b.String()
+}
+
+// position counts the old and new lines covered by edits
+func position(edits []edit) (old, new int) {
+	for _, e := range edits {
+		if e.op != '+' {
+			old++
+		}
+		if e.op != '-' {
+			new++
+		}
+	}
+	return old, new
+}
+
+// diffLines aligns the two texts on their longest common subsequence of lines
+func diffLines(old, new []string) []edit {
+	// Trim the common prefix and suffix, which are usually most of the page
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	var edits []edit
+	for _, line := range old[:prefix] {
+		edits = append(edits, edit{' ', line})
+	}
+	edits = append(edits, alignLines(old[prefix:len(old)-suffix], new[prefix:len(new)-suffix])...)
+	for _, line := range old[len(old)-suffix:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits
+}
+
+// alignLines diffs the changed middle of two texts with Myers' algorithm,
+// which finds a shortest edit script in O((N+M)·D) time for D edits
+func alignLines(old, new []string) []edit {
+	n, m := len(old), len(new)
+	maxD := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k = x-y; trace[d]
+	// keeps v for k in [-d, d] after d edits, to walk the path back
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= maxD && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // insertion
+			} else {
+				x = v[offset+k-1] + 1 // deletion
+			}
+			y := x - k
+			for x < n && y < m && old[x] == new[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	if !found {
+		edits := make([]edit, 0, n+m)
+		for _, line := range old {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range new {
+			edits = append(edits, edit{'+', line})
+		}
+		return edits
+	}
+
+	// Walk back from the end, one edit and the unchanged run after it at a time
+	var reversed []edit
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, edit{' ', old[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, edit{'+', new[y-1]})
+			y--
+		} else {
+			reversed = append(reversed, edit{'-', old[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, edit{' ', old[x-1]})
+		x--
+		y--
+	}
+
+	edits := make([]edit, len(reversed))
+	for i, e := range reversed {
+		edits[len(reversed)-1-i] = e
+	}
+	return edits
+}
@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	lines := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, " ")
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		context  int
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a b c", new: "a b c", context: 3,
+			want: "",
+		},
+		{
+			name: "change with context",
+			old:  "a b c d e", new: "a b X d e", context: 1,
+			want: "@@ -2,3 +2,3 @@\n b\n-c\n+X\n d\n",
+		},
+		{
+			name: "context clipped at the ends",
+			old:  "a b", new: "A b", context: 3,
+			want: "@@ -1,2 +1,2 @@\n-a\n+A\n b\n",
+		},
+		{
+			name: "changes close together share a hunk",
+			old:  "a b c d e f g", new: "a B c d E f g", context: 1,
+			want: "@@ -1,6 +1,6 @@\n a\n-b\n+B\n c\n d\n-e\n+E\n f\n",
+		},
+		{
+			name: "changes far apart get their own hunks",
+			old:  "a b c d e f g", new: "A b c d e f G", context: 1,
+			want: "@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -6,2 +6,2 @@\n f\n-g\n+G\n",
+		},
+		{
+			name: "insertion",
+			old:  "a b", new: "a X b", context: 0,
+			want: "@@ -2,0 +2,1 @@\n+X\n",
+		},
+		{
+			name: "deletion",
+			old:  "a b c", new: "a c", context: 0,
+			want: "@@ -2,1 +2,0 @@\n-b\n",
+		},
+		{
+			name: "from empty",
+			old:  "", new: "a b", context: 3,
+			want: "@@ -1,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "keeps the common lines in a rewrite",
+			old:  "a b c d", new: "b X d Y", context: 0,
+			want: "@@ -1,1 +1,0 @@\n-a\n@@ -3,1 +2,1 @@\n-c\n+X\n@@ -5,0 +4,1 @@\n+Y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(lines(tt.old), lines(tt.new), tt.context)
+			if got != tt.want {
+				t.Errorf("Diff(%q, %q, %d) =\n%s\nwant\n%s", tt.old, tt.new, tt.context, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffBeyondEditBound(t *testing.T) {
+	var old, new []string
+	for i := range maxDiffEdits {
+		old = append(old, fmt.Sprintf("old %d", i))
+		new = append(new, fmt.Sprintf("new %d", i))
+	}
+	old = append(old, "same")
+	new = append(new, "same")
+
+	got := Diff(old, new, 1)
+	want := fmt.Sprintf("@@ -1,%d +1,%d @@\n", maxDiffEdits+1, maxDiffEdits+1)
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("Diff header = %q, want %q", strings.SplitN(got, "\n", 2)[0], want)
+	}
+	if strings.Count(got, "\n-") != maxDiffEdits || strings.Count(got, "\n+") != maxDiffEdits {
+		t.Errorf("Diff should delete and insert every changed line once")
+	}
+}
@@ -0,0 +1,386 @@
+// Package monitor detects what changed on each page since the previous run
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Change types
+const (
+	ChangeNew     = "new"
+	ChangeChanged = "changed"
+	ChangeRemoved = "removed"
+)
+
+// State is what the monitor remembers between runs
+type State struct {
+	UpdatedAt time.Time        `json:"updated_at"`
+	Pages     map[string]*Page `json:"pages"`
+}
+
+// Page is the remembered state of one URL
+type Page struct {
+	Fingerprint string            `json:"fingerprint"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Text        []string          `json:"text,omitempty"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastChanged time.Time         `json:"last_changed"`
+	LastChecked time.Time         `json:"last_checked"`
+}
+
+// Change describes a new, changed or removed page
+type Change struct {
+	URL                 string        `json:"url"`
+	Type                string        `json:"type"`
+	Fingerprint         string        `json:"fingerprint,omitempty"`
+	PreviousFingerprint string        `json:"previous_fingerprint,omitempty"`
+	Fields              []FieldChange `json:"fields,omitempty"`
+	TextDiff            string        `json:"text_diff,omitempty"`
+	LastChanged         *time.Time    `json:"last_changed,omitempty"` // When the page last changed before this run
+	DetectedAt          time.Time     `json:"detected_at"`
+}
+
+// FieldChange is the change of a single extracted field
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// Monitor compares each result with the page's state from the previous run
+type Monitor struct {
+	Config *config.MonitorConfig
+
+	previous  map[string]*Page
+	current   map[string]*Page
+	seen      map[string]bool
+	changes   []Change
+	selectors string
+	patterns  []*regexp.Regexp
+	ignored   map[string]bool
+}
+
+// NewMonitor loads the previous state; a missing state file means every page is new
+func NewMonitor(cfg *config.MonitorConfig) (*Monitor, error) {
+	m := &Monitor{
+		Config:    cfg,
+		previous:  make(map[string]*Page),
+		current:   make(map[string]*Page),
+		seen:      make(map[string]bool),
+		selectors: strings.Join(append([]string{"script", "style", "noscript", "template"}, cfg.IgnoreSelectors...), ", "),
+		ignored:   make(map[string]bool),
+	}
+	for _, pattern := range cfg.IgnorePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	for _, field := range cfg.IgnoreFields {
+		m.ignored[field] = true
+	}
+
+	data, err := os.ReadFile(cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("reading monitor state %s: %w", cfg.StateFile, err)
+	}
+	if state.Pages != nil {
+		m.previous = state.Pages
+	}
+	return m, nil
+}
+
+// Observe compares a result with the previous run and returns the change,
+// or nil when the page is unchanged. Failed fetches keep the previous state,
+// except 404 and 410 responses, which mean the page was removed.
+func (m *Monitor) Observe(result models.Result) *Change {
+	m.seen[result.URL] = true
+	previous := m.previous[result.URL]
+	now := time.Now()
+
+	if result.Err != "" {
+		if previous == nil {
+			return nil
+		}
+		if result.StatusCode == http.StatusNotFound || result.StatusCode == http.StatusGone {
+			return m.record(Change{
+				URL:                 result.URL,
+				Type:                ChangeRemoved,
+				PreviousFingerprint: previous.Fingerprint,
+				LastChanged:         &previous.LastChanged,
+				DetectedAt:          now,
+			})
+		}
+		m.current[result.URL] = previous
+		return nil
+	}
+
+	page := m.snapshot(result)
+	page.LastChecked = now
+	m.current[result.URL] = page
+
+	if previous == nil {
+		page.FirstSeen, page.LastChanged = now, now
+		return m.record(Change{
+			URL:         result.URL,
+			Type:        ChangeNew,
+			Fingerprint: page.Fingerprint,
+			Fields:      diffFields(nil, page.Fields),
+			DetectedAt:  now,
+		})
+	}
+
+	page.FirstSeen = previous.FirstSeen
+	if page.Fingerprint == previous.Fingerprint {
+		page.LastChanged = previous.LastChanged
+		return nil
+	}
+	page.LastChanged = now
+
+	change := Change{
+		URL:                 result.URL,
+		Type:                ChangeChanged,
+		Fingerprint:         page.Fingerprint,
+		PreviousFingerprint: previous.Fingerprint,
+		Fields:              diffFields(previous.Fields, page.Fields),
+		LastChanged:         &previous.LastChanged,
+		DetectedAt:          now,
+	}
+	if m.Config.TextDiff {
+		change.TextDiff = Diff(previous.Text, page.Text, m.Config.ContextLines)
+	}
+	return m.record(change)
+}
+
+// Skip marks a page as fetched without comparing it, keeping its previous
+// state, so it is neither reported as removed nor dropped from the state
+func (m *Monitor) Skip(url string) {
+	m.seen[url] = true
+	if previous := m.previous[url]; previous != nil {
+		m.current[url] = previous
+	}
+}
+
+// Finish reports the pages of the previous run that this run did not fetch
+// as removed and returns every change found
+func (m *Monitor) Finish() []Change {
+	urls := make([]string, 0, len(m.previous))
+	for url := range m.previous {
+		if !m.seen[url] {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+
+	now := time.Now()
+	for _, url := range urls {
+		previous := m.previous[url]
+		m.record(Change{
+			URL:                 url,
+			Type:                ChangeRemoved,
+			PreviousFingerprint: previous.Fingerprint,
+			LastChanged:         &previous.LastChanged,
+			DetectedAt:          now,
+		})
+	}
+	return m.changes
+}
+
+// Save writes the state of this run for the next one to compare against
+func (m *Monitor) Save() error {
+	data, err := json.MarshalIndent(State{UpdatedAt: time.Now(), Pages: m.current}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted save keeps the old state
+	tmp, err := os.CreateTemp(filepath.Dir(m.Config.StateFile), ".monitor-state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.Config.StateFile)
+}
+
+// WriteChanges saves the changes as a JSON array
+func WriteChanges(filename string, changes []Change) error {
+	if changes == nil {
+		changes = []Change{}
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// record adds a change to the run's list
+func (m *Monitor) record(change Change) *Change {
+	m.changes = append(m.changes, change)
+	return &change
+}
+
+// snapshot builds the page state of a result with the noise removed
+func (m *Monitor) snapshot(result models.Result) *Page {
+	page := &Page{Fields: make(map[string]string)}
+	for name, value := range result.Extracted {
+		if m.ignored[name] {
+			continue
+		}
+		page.Fields[name] = m.clean(fieldString(value))
+	}
+	text := m.text(result)
+	if m.Config.TextDiff {
+		page.Text = text
+	}
+
+	// Fingerprint the fields in a stable order, then the text
+	hash := sha256.New()
+	names := make([]string, 0, len(page.Fields))
+	for name := range page.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, page.Fields[name])
+	}
+	for _, line := range text {
+		fmt.Fprintf(hash, "%s\n", line)
+	}
+	page.Fingerprint = hex.EncodeToString(hash.Sum(nil))
+	return page
+}
+
+// text returns the main text of the page as lines: the text of HTML pages
+// without the ignored elements, the content of other pages, or the content
+// hash of binary ones
+func (m *Monitor) text(result models.Result) []string {
+	var raw []string
+	switch {
+	case content.Detect(result.ContentType, []byte(result.Content)) == content.HTML && result.Content != "":
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(result.Content))
+		if err != nil {
+			raw = strings.Split(result.Content, "\n")
+			break
+		}
+		doc.Find(m.selectors).Remove()
+		raw = textLines(doc.Find("body"))
+		if len(raw) == 0 {
+			raw = textLines(doc.Selection)
+		}
+	case result.Content != "":
+		raw = strings.Split(result.Content, "\n")
+	case len(result.Items) > 0:
+		for _, item := range result.Items {
+			raw = append(raw, strings.TrimSpace(item.Title+" "+item.Link))
+		}
+	case result.ContentHash != "":
+		raw = []string{result.ContentHash}
+	}
+
+	lines := make([]string, 0, len(raw))
+	for _, line := range raw {
+		if line = m.clean(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// clean blanks out the ignored patterns and collapses whitespace
+func (m *Monitor) clean(s string) string {
+	for _, re := range m.patterns {
+		s = re.ReplaceAllString(s, "")
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// textLines returns the text nodes under the selection, one per line
+func textLines(sel *goquery.Selection) []string {
+	var lines []string
+	var walk func(*goquery.Selection)
+	walk = func(s *goquery.Selection) {
+		s.Contents().Each(func(_ int, node *goquery.Selection) {
+			if goquery.NodeName(node) == "#text" {
+				if text := strings.TrimSpace(node.Text()); text != "" {
+					lines = append(lines, text)
+				}
+				return
+			}
+			walk(node)
+		})
+	}
+	walk(sel)
+	return lines
+}
+
+// fieldString renders an extracted value for comparison
+func fieldString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// diffFields lists the fields that were added, removed or changed
+func diffFields(old, new map[string]string) []FieldChange {
+	names := make(map[string]bool)
+	for name := range old {
+		names[name] = true
+	}
+	for name := range new {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []FieldChange
+	for _, name := range sorted {
+		oldValue, hadOld := old[name]
+		newValue, hasNew := new[name]
+		if hadOld == hasNew && oldValue == newValue {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: name, Old: oldValue, New: newValue})
+	}
+	return changes
+}
@@ -0,0 +1,220 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// fetch is one page of a test run: a result to observe, or a URL to skip
+type fetch struct {
+	result models.Result
+	skip   string
+}
+
+// page returns a fetched HTML page with the body and extracted fields
+func page(url, body string, fields map[string]interface{}) fetch {
+	return fetch{result: models.Result{
+		URL:         url,
+		StatusCode:  http.StatusOK,
+		ContentType: "text/html",
+		Content:     "<html><body>" + body + "</body></html>",
+		Extracted:   fields,
+	}}
+}
+
+// failed returns a failed fetch with the status code
+func failed(url string, status int) fetch {
+	return fetch{result: models.Result{URL: url, StatusCode: status, Err: fmt.Sprintf("status %d", status)}}
+}
+
+func TestMonitor(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.MonitorConfig
+		runs [][]fetch
+		want []string // Changes of the last run, as "type url"
+	}{
+		{
+			name: "first run",
+			runs: [][]fetch{{page("a", "<p>A</p>", nil), page("b", "<p>B</p>", nil)}},
+			want: []string{"new a", "new b"},
+		},
+		{
+			name: "unchanged",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil)},
+				{page("a", "<p>A</p>", nil)},
+			},
+		},
+		{
+			name: "new, changed and removed",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil), page("b", "<p>B</p>", nil)},
+				{page("a", "<p>A2</p>", nil), page("c", "<p>C</p>", nil)},
+			},
+			want: []string{"changed a", "new c", "removed b"},
+		},
+		{
+			name: "changed field",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", map[string]interface{}{"price": "1"})},
+				{page("a", "<p>A</p>", map[string]interface{}{"price": "2"})},
+			},
+			want: []string{"changed a"},
+		},
+		{
+			name: "404 and 410 remove the page",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil), page("b", "<p>B</p>", nil)},
+				{failed("a", http.StatusNotFound), failed("b", http.StatusGone)},
+			},
+			want: []string{"removed a", "removed b"},
+		},
+		{
+			name: "other failures keep the previous state",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil)},
+				{failed("a", http.StatusInternalServerError)},
+				{page("a", "<p>A</p>", nil)},
+			},
+		},
+		{
+			name: "failures of unknown pages are not reported",
+			runs: [][]fetch{{failed("a", http.StatusNotFound)}},
+		},
+		{
+			name: "skip keeps the state",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil), page("b", "<p>B</p>", nil)},
+				{page("a", "<p>A</p>", nil), {skip: "b"}},
+				{page("a", "<p>A</p>", nil), page("b", "<p>B</p>", nil)},
+			},
+		},
+		{
+			name: "skipped pages are not removed",
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", nil)},
+				{{skip: "a"}},
+			},
+		},
+		{
+			name: "ignore patterns",
+			cfg:  config.MonitorConfig{IgnorePatterns: []string{`\d{2}:\d{2}`}},
+			runs: [][]fetch{
+				{page("a", "<p>Updated 10:15</p>", map[string]interface{}{"time": "at 10:15"})},
+				{page("a", "<p>Updated 11:40</p>", map[string]interface{}{"time": "at 11:40"})},
+			},
+		},
+		{
+			name: "ignore selectors",
+			cfg:  config.MonitorConfig{IgnoreSelectors: []string{".ad"}},
+			runs: [][]fetch{
+				{page("a", `<p>A</p><div class="ad">Buy one</div>`, nil)},
+				{page("a", `<p>A</p><div class="ad">Buy two</div>`, nil)},
+			},
+		},
+		{
+			name: "ignore fields",
+			cfg:  config.MonitorConfig{IgnoreFields: []string{"views"}},
+			runs: [][]fetch{
+				{page("a", "<p>A</p>", map[string]interface{}{"title": "A", "views": "10"})},
+				{page("a", "<p>A</p>", map[string]interface{}{"title": "A", "views": "11"})},
+			},
+		},
+		{
+			name: "scripts and whitespace are not content",
+			runs: [][]fetch{
+				{page("a", "<p>A  B</p><script>var t = 1</script>", nil)},
+				{page("a", "<p>A\n B</p><script>var t = 2</script>", nil)},
+			},
+		},
+		{
+			name: "text outside the ignored elements still counts",
+			cfg:  config.MonitorConfig{IgnoreSelectors: []string{".ad"}, IgnorePatterns: []string{`\d{2}:\d{2}`}},
+			runs: [][]fetch{
+				{page("a", `<p>Price 5 at 10:15</p><div class="ad">X</div>`, nil)},
+				{page("a", `<p>Price 6 at 10:15</p><div class="ad">X</div>`, nil)},
+			},
+			want: []string{"changed a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+			var changes []Change
+			for _, run := range tt.runs {
+				m, err := NewMonitor(&cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range run {
+					if f.skip != "" {
+						m.Skip(f.skip)
+						continue
+					}
+					m.Observe(f.result)
+				}
+				changes = m.Finish()
+				if err := m.Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.Type+" "+change.URL)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveChange(t *testing.T) {
+	cfg := config.MonitorConfig{StateFile: filepath.Join(t.TempDir(), "state.json"), TextDiff: true, ContextLines: 1}
+
+	m, err := NewMonitor(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := page("a", "<p>one</p><p>two</p>", map[string]interface{}{"title": "Old", "tags": []string{"x"}})
+	created := m.Observe(first.result)
+	if created == nil || created.Type != ChangeNew {
+		t.Fatalf("Observe() of a new page = %+v, want a new change", created)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = NewMonitor(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := page("a", "<p>one</p><p>three</p>", map[string]interface{}{"title": "New", "tags": []string{"x"}})
+	change := m.Observe(second.result)
+	if change == nil || change.Type != ChangeChanged {
+		t.Fatalf("Observe() of a changed page = %+v, want a changed change", change)
+	}
+	if change.PreviousFingerprint != created.Fingerprint || change.Fingerprint == created.Fingerprint {
+		t.Errorf("fingerprints %s -> %s, want the previous one followed by a new one", change.PreviousFingerprint, change.Fingerprint)
+	}
+	wantFields := []FieldChange{{Field: "title", Old: "Old", New: "New"}}
+	if fmt.Sprint(change.Fields) != fmt.Sprint(wantFields) {
+		t.Errorf("Fields = %+v, want %+v", change.Fields, wantFields)
+	}
+	if want := "@@ -1,2 +1,2 @@\n one\n-two\n+three\n"; change.TextDiff != want {
+		t.Errorf("TextDiff = %q, want %q", change.TextDiff, want)
+	}
+	if change.LastChanged == nil || !change.LastChanged.Equal(created.DetectedAt) {
+		t.Errorf("LastChanged = %v, want when the page was first seen (%v)", change.LastChanged, created.DetectedAt)
+	}
+}
@@ -0,0 +1,221 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// redrawInterval is how often the progress block is redrawn on a terminal
+const redrawInterval = 500 * time.Millisecond
+
+// maxHosts is the number of hosts shown in the error rate breakdown
+const maxHosts = 5
+
+// hostStats counts the outcomes for a single host
+type hostStats struct {
+	done   int
+	failed int
+}
+
+// Reporter tracks the progress of a run. On a terminal it redraws a progress
+// block in place; otherwise it logs a summary line at a fixed interval.
+type Reporter struct {
+	Total    int
+	Interval time.Duration
+
+	// Redraw draws the progress block in place. It defaults to whether the
+	// output is a terminal, and must be off when logs go to the same terminal
+	// since the redraw would overwrite them.
+	Redraw bool
+
+	out   io.Writer
+	start time.Time
+
+	// retriesAtStart is the process-wide retry count when the run started;
+	// retries are counted as they happen, for URLs still being fetched too
+	retriesAtStart int64
+
+	mu        sync.Mutex
+	completed int
+	failed    int
+	hosts     map[string]*hostStats
+	drawn     int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReporter creates a reporter for total URLs writing to out. The interval
+// sets how often summary lines are logged when out is not a terminal.
+func NewReporter(total int, out *os.File, interval time.Duration) *Reporter {
+	return &Reporter{
+		Total:    total,
+		Interval: interval,
+		Redraw:   isTerminal(out),
+		out:      out,
+		hosts:    make(map[string]*hostStats),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins reporting in the background
+func (r *Reporter) Start() {
+	r.start = time.Now()
+	r.retriesAtStart = metrics.RetriesTotal()
+
+	interval := r.Interval
+	if r.Redraw {
+		interval = redrawInterval
+	}
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.report()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Observe records a finished result
+func (r *Reporter) Observe(result models.Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	host := metrics.Host(result.URL)
+	stats, ok := r.hosts[host]
+	if !ok {
+		stats = &hostStats{}
+		r.hosts[host] = stats
+	}
+
+	r.completed++
+	stats.done++
+	if result.Err != "" {
+		r.failed++
+		stats.failed++
+	}
+}
+
+// Stop stops the background reporting and renders the final state
+func (r *Reporter) Stop() {
+	close(r.stop)
+	<-r.done
+	r.report()
+}
+
+// report renders the current progress
+func (r *Reporter) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := time.Since(r.start)
+	retries := metrics.RetriesTotal() - r.retriesAtStart
+	rate := float64(r.completed) / elapsed.Seconds()
+
+	var eta time.Duration
+	if rate > 0 && r.completed < r.Total {
+		eta = time.Duration(float64(r.Total-r.completed) / rate * float64(time.Second)).Round(time.Second)
+	}
+
+	if !r.Redraw {
+		slog.Info("Progress",
+			"completed", r.completed,
+			"failed", r.failed,
+			"total", r.Total,
+			"retries", retries,
+			"rate", fmt.Sprintf("%.2f/s", rate),
+			"eta", eta,
+		)
+		return
+	}
+
+	percent := 0.0
+	if r.Total > 0 {
+		percent = float64(r.completed) / float64(r.Total) * 100
+	}
+
+	lines := []string{
+		fmt.Sprintf("Progress: %d/%d (%.1f%%) | succeeded %d | failed %d | retries %d",
+			r.completed, r.Total, percent, r.completed-r.failed, r.failed, retries),
+		fmt.Sprintf("Rate: %.2f URLs/s | elapsed %s | ETA %s",
+			rate, elapsed.Round(time.Second), eta),
+	}
+	if hosts := r.hostErrors(); hosts != "" {
+		lines = append(lines, "Errors by host: "+hosts)
+	}
+
+	// Move back over the previous block and redraw it
+	var b strings.Builder
+	if r.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", r.drawn)
+	}
+	for _, line := range lines {
+		b.WriteString("\033[2K")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	io.WriteString(r.out, b.String())
+	r.drawn = len(lines)
+}
+
+// hostErrors formats the hosts with the highest error rates
+func (r *Reporter) hostErrors() string {
+	type hostRate struct {
+		host  string
+		stats *hostStats
+		rate  float64
+	}
+
+	var rates []hostRate
+	for host, stats := range r.hosts {
+		if stats.failed == 0 {
+			continue
+		}
+		rates = append(rates, hostRate{host, stats, float64(stats.failed) / float64(stats.done)})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].rate != rates[j].rate {
+			return rates[i].rate > rates[j].rate
+		}
+		return rates[i].host < rates[j].host
+	})
+	if len(rates) > maxHosts {
+		rates = rates[:maxHosts]
+	}
+
+	parts := make([]string, 0, len(rates))
+	for _, hr := range rates {
+		parts = append(parts, fmt.Sprintf("%s %.0f%% (%d/%d)", hr.host, hr.rate*100, hr.stats.failed, hr.stats.done))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// isTerminal reports whether the file is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -60,3 +60,12 @@ func (m *Manager) ApplyToTransport(transport *http.Transport) (string, error) {
 
 	return "", nil
 }
+
+// Redacted returns a proxy URL with its password masked, for logging
+func Redacted(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return proxyURL
+	}
+	return u.Redacted()
+}
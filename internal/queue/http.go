@@ -0,0 +1,294 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// WorkerHeader names the worker making a request to a queue server
+const WorkerHeader = "X-Scraper-Worker"
+
+// maxMessageSize limits the size of a request to the queue server; results
+// carry the page content
+const maxMessageSize = 64 << 20
+
+// errCoordinatorOnly is returned by the methods of an HTTP queue that only
+// the coordinator, which holds the queue, may call
+var errCoordinatorOnly = errors.New("only the coordinator can add URLs to an http queue")
+
+// message is the body of the queue server's requests and responses
+type message struct {
+	ID       string         `json:"id"`
+	URL      string         `json:"url,omitempty"`
+	Attempts int            `json:"attempts,omitempty"`
+	Lease    time.Duration  `json:"lease,omitempty"`
+	Token    string         `json:"token"`
+	Result   *models.Result `json:"result,omitempty"`
+}
+
+// Server shares a SQLite queue with workers on other machines over HTTP.
+// Requests must carry the token, when one is set, as a bearer token.
+type Server struct {
+	Queue *SQLite
+	Token string
+}
+
+// NewServer creates a server for the queue
+func NewServer(q *SQLite, token string) *Server {
+	return &Server{Queue: q, Token: token}
+}
+
+// Handler returns the HTTP handler for the queue
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /lease", s.handleLease)
+	mux.HandleFunc("POST /renew", s.handleRenew)
+	mux.HandleFunc("POST /ack", s.handleAck)
+	mux.HandleFunc("POST /results", s.handleResult)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong queue token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Serve serves the queue on addr until the returned server is closed
+func (s *Server) Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Queue server stopped", "error", err)
+		}
+	}()
+	return server, nil
+}
+
+// handleLease hands out the next job: 200 with the job, 204 when there is
+// none right now and 410 once the queue is drained
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	job, err := s.Queue.lease(r.Context(), worker(r))
+	switch {
+	case errors.Is(err, ErrDrained):
+		writeError(w, http.StatusGone, err)
+	case err != nil:
+		s.fail(w, "lease", err)
+	case job == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, message{ID: job.ID, URL: job.URL, Attempts: job.Attempts, Lease: job.Lease, Token: job.token})
+	}
+}
+
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	s.handleJob(w, r, "renew", func(ctx context.Context, job *Job, _ *message) error {
+		return s.Queue.Renew(ctx, job)
+	})
+}
+
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	s.handleJob(w, r, "ack", func(ctx context.Context, job *Job, _ *message) error {
+		return s.Queue.Ack(ctx, job)
+	})
+}
+
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	s.handleJob(w, r, "store result", func(ctx context.Context, job *Job, msg *message) error {
+		if msg.Result == nil {
+			return errors.New("no result")
+		}
+		return s.Queue.putAs(ctx, job, worker(r), *msg.Result)
+	})
+}
+
+// handleJob decodes a job and applies op to it: 204 when it succeeds and 409
+// when the lease was lost
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request, name string, op func(context.Context, *Job, *message) error) {
+	var msg message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageSize)).Decode(&msg); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	err := op(r.Context(), &Job{ID: msg.ID, token: msg.Token}, &msg)
+	switch {
+	case errors.Is(err, ErrLeaseLost):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		s.fail(w, name, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// fail logs a queue error and reports it to the worker
+func (s *Server) fail(w http.ResponseWriter, op string, err error) {
+	slog.Warn("Queue server error", "op", op, "path", s.Queue.Config.Path, "error", err)
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// worker returns the name the worker gave, or its address
+func worker(r *http.Request) string {
+	if name := r.Header.Get(WorkerHeader); name != "" {
+		return name
+	}
+	return r.RemoteAddr
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// HTTP is a worker's view of a queue served by the coordinator's Server, for
+// workers on other machines. Only the coordinator can push URLs.
+type HTTP struct {
+	Config *config.QueueConfig
+	Worker string // Sent with every request, hostname:pid by default
+
+	client *http.Client
+}
+
+// NewHTTP creates a client for the queue served at cfg.URL
+func NewHTTP(cfg *config.QueueConfig) *HTTP {
+	host, _ := os.Hostname()
+	return &HTTP{
+		Config: cfg,
+		Worker: host + ":" + strconv.Itoa(os.Getpid()),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Push fails; the coordinator adds the URLs
+func (q *HTTP) Push(ctx context.Context, urls ...string) error {
+	return errCoordinatorOnly
+}
+
+// Seal fails; the coordinator seals the queue
+func (q *HTTP) Seal(ctx context.Context) error {
+	return errCoordinatorOnly
+}
+
+// Lease waits for a job, retrying while the coordinator can't be reached,
+// so workers may be started before it
+func (q *HTTP) Lease(ctx context.Context) (*Job, error) {
+	for {
+		var msg message
+		found, err := q.call(ctx, "/lease", nil, &msg)
+		if found {
+			return &Job{ID: msg.ID, URL: msg.URL, Attempts: msg.Attempts, Lease: msg.Lease, token: msg.Token}, nil
+		}
+		if errors.Is(err, ErrDrained) {
+			return nil, err
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Error leasing from the queue", "url", q.Config.URL, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(q.Config.PollInterval):
+		}
+	}
+}
+
+// Renew extends the lease of a job still being worked on
+func (q *HTTP) Renew(ctx context.Context, job *Job) error {
+	_, err := q.call(ctx, "/renew", &message{ID: job.ID, Token: job.token}, nil)
+	return err
+}
+
+// Ack marks a leased job done
+func (q *HTTP) Ack(ctx context.Context, job *Job) error {
+	_, err := q.call(ctx, "/ack", &message{ID: job.ID, Token: job.token}, nil)
+	return err
+}
+
+// Put sends the result of a job to the coordinator
+func (q *HTTP) Put(ctx context.Context, job *Job, result models.Result) error {
+	_, err := q.call(ctx, "/results", &message{ID: job.ID, Token: job.token, Result: &result}, nil)
+	return err
+}
+
+// call posts a request to the queue server and decodes its response into
+// out, reporting whether there was one. Lost leases and a drained queue come
+// back as ErrLeaseLost and ErrDrained.
+func (q *HTTP) call(ctx context.Context, path string, in *message, out *message) (bool, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return false, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.Config.URL+path, &body)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WorkerHeader, q.Worker)
+	if q.Config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+q.Config.Token)
+	}
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if out == nil {
+			return true, nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return false, fmt.Errorf("invalid response from the queue: %w", err)
+		}
+		return true, nil
+	case http.StatusNoContent:
+		return false, nil
+	case http.StatusConflict:
+		return false, ErrLeaseLost
+	case http.StatusGone:
+		return false, ErrDrained
+	}
+
+	var problem struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &problem) != nil || problem.Error == "" {
+		problem.Error = string(bytes.TrimSpace(data))
+	}
+	return false, fmt.Errorf("queue server returned %s: %s", resp.Status, problem.Error)
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// newTestHTTP serves the queue and returns a worker's client for it
+func newTestHTTP(t *testing.T, q *SQLite, serverToken, clientToken string) *HTTP {
+	t.Helper()
+	server := httptest.NewServer(NewServer(q, serverToken).Handler())
+	t.Cleanup(server.Close)
+
+	cfg := *q.Config
+	cfg.Backend, cfg.URL, cfg.Token = "http", server.URL, clientToken
+	remote := NewHTTP(&cfg)
+	remote.Worker = "remote:1"
+	return remote
+}
+
+func TestHTTPQueue(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	remote := newTestHTTP(t, q, "secret", "secret")
+	ctx := context.Background()
+
+	job := lease(t, remote)
+	if job.URL != "http://a.test/" || job.Attempts != 1 || job.Lease != testLease {
+		t.Fatalf("Lease = %+v", job)
+	}
+	if err := remote.Renew(ctx, job); err != nil {
+		t.Fatalf("Renew failed: %v", err)
+	}
+	if err := remote.Put(ctx, job, models.Result{URL: job.URL, StatusCode: 200, Content: "hello"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := remote.Ack(ctx, job); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if _, err := remote.Lease(ctx); !errors.Is(err, ErrDrained) {
+		t.Fatalf("Lease on a finished queue = %v, want ErrDrained", err)
+	}
+
+	results := collect(t, q)
+	if len(results) != 1 || results[0].Content != "hello" {
+		t.Fatalf("Collect = %+v, want the remote worker's result", results)
+	}
+	var worker string
+	if err := q.db.QueryRow(`SELECT worker FROM results`).Scan(&worker); err != nil || worker != "remote:1" {
+		t.Errorf("result stored for worker %q (%v), want remote:1", worker, err)
+	}
+}
+
+func TestHTTPQueueLostLease(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	remote := newTestHTTP(t, q, "", "")
+	ctx := context.Background()
+
+	first := lease(t, remote)
+	time.Sleep(2 * testLease)
+	second := lease(t, remote)
+	if second.Attempts != 2 {
+		t.Fatalf("Lease after expiry = %+v, want the second attempt", second)
+	}
+	if err := remote.Renew(ctx, first); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew of the expired lease = %v, want ErrLeaseLost", err)
+	}
+	if err := remote.Ack(ctx, first); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Ack of the expired lease = %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestHTTPQueueRejectsWrongToken(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	remote := newTestHTTP(t, q, "secret", "guess")
+
+	_, err := remote.call(context.Background(), "/lease", nil, &message{})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("lease with the wrong token = %v, want a 401", err)
+	}
+
+	// Nothing was leased
+	if job := lease(t, q); job.Attempts != 1 {
+		t.Errorf("job was leased %d times, want once", job.Attempts)
+	}
+}
+
+func TestHTTPQueueIsFilledByTheCoordinator(t *testing.T) {
+	remote := NewHTTP(&config.QueueConfig{URL: "http://127.0.0.1:1"})
+	if err := remote.Push(context.Background(), "http://a.test/"); err == nil {
+		t.Error("Push to an http queue should fail")
+	}
+}
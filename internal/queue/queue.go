@@ -0,0 +1,116 @@
+// Package queue hands URLs out to workers and collects their results, either
+// in-process or through a database shared by several scraper processes
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// ErrDrained is returned by Lease once the queue is sealed and every job is finished
+var ErrDrained = errors.New("queue drained")
+
+// Job is a URL leased to a worker
+type Job struct {
+	ID       string
+	URL      string
+	Attempts int           // Times the job has been leased, this one included
+	Lease    time.Duration // How long the lease lasts without renewal (0 if it never expires)
+
+	token string // Identifies this lease, so a worker can't finish a job handed to another
+}
+
+// Queue hands out URLs to workers. A leased job that is neither acknowledged
+// nor renewed before its lease runs out goes back to the queue.
+type Queue interface {
+	// Push adds URLs to the queue
+	Push(ctx context.Context, urls ...string) error
+	// Seal marks that no more URLs are coming
+	Seal(ctx context.Context) error
+	// Lease waits for the next job; it returns ErrDrained once the queue is
+	// sealed and every job is finished
+	Lease(ctx context.Context) (*Job, error)
+	// Renew extends the lease of a job still being worked on
+	Renew(ctx context.Context, job *Job) error
+	// Ack marks a leased job finished
+	Ack(ctx context.Context, job *Job) error
+}
+
+// Sink receives the result of each job before it is acknowledged
+type Sink interface {
+	Put(ctx context.Context, job *Job, result models.Result) error
+}
+
+// Memory is an in-process queue for a single worker pool
+type Memory struct {
+	jobs chan *Job
+	once sync.Once
+	next int
+	mu   sync.Mutex
+}
+
+// NewMemory creates an in-process queue holding up to size URLs without blocking
+func NewMemory(size int) *Memory {
+	return &Memory{jobs: make(chan *Job, size)}
+}
+
+// Push adds URLs to the queue, waiting while it is full
+func (q *Memory) Push(ctx context.Context, urls ...string) error {
+	for _, url := range urls {
+		q.mu.Lock()
+		q.next++
+		job := &Job{ID: strconv.Itoa(q.next), URL: url, Attempts: 1}
+		q.mu.Unlock()
+
+		metrics.QueueDepth.Inc()
+		select {
+		case q.jobs <- job:
+		case <-ctx.Done():
+			metrics.QueueDepth.Dec()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Seal marks that no more URLs are coming
+func (q *Memory) Seal(ctx context.Context) error {
+	q.once.Do(func() { close(q.jobs) })
+	return nil
+}
+
+// Lease returns the next URL. Jobs are handed out even after ctx is
+// cancelled, so the workers can drain the queue without fetching them.
+func (q *Memory) Lease(ctx context.Context) (*Job, error) {
+	job, ok := <-q.jobs
+	if !ok {
+		return nil, ErrDrained
+	}
+	metrics.QueueDepth.Dec()
+	return job, nil
+}
+
+// Renew does nothing; in-process leases never expire
+func (q *Memory) Renew(ctx context.Context, job *Job) error {
+	return nil
+}
+
+// Ack does nothing; a job is finished once it is leased
+func (q *Memory) Ack(ctx context.Context, job *Job) error {
+	return nil
+}
+
+// ChannelSink sends every result to a channel
+type ChannelSink chan<- models.Result
+
+// Put sends the result to the channel
+func (s ChannelSink) Put(ctx context.Context, job *Job, result models.Result) error {
+	s <- result
+	return nil
+}
@@ -0,0 +1,407 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+
+	// Pure-Go SQLite driver, keeps the build cgo-free
+	_ "modernc.org/sqlite"
+)
+
+// ErrLeaseLost is returned when a job's lease ran out and it was handed to another worker
+var ErrLeaseLost = errors.New("lease lost")
+
+// sqliteQueueSchema creates the jobs, their results and the queue flags
+const sqliteQueueSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	url          TEXT NOT NULL,
+	state        TEXT NOT NULL DEFAULT 'pending',
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	worker       TEXT,
+	token        TEXT,
+	leased_until INTEGER,
+	created_at   INTEGER NOT NULL,
+	finished_at  INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS jobs_state ON jobs (state, id);
+
+CREATE TABLE IF NOT EXISTS results (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	job_id     INTEGER NOT NULL UNIQUE REFERENCES jobs(id),
+	worker     TEXT,
+	result     TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS queue (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// SQLite is a queue in a SQLite file shared by a coordinator, which pushes
+// the URLs and collects the results, and any number of worker processes on
+// the same machine; a Server shares it with workers on other machines.
+// Times are stored as Unix milliseconds.
+type SQLite struct {
+	Config *config.QueueConfig
+	Worker string // Recorded on leased jobs and results, hostname:pid by default
+
+	db *sql.DB
+}
+
+// NewSQLite opens or creates the queue database
+func NewSQLite(cfg *config.QueueConfig) (*SQLite, error) {
+	// Wait for other processes' writes instead of failing, and take the write
+	// lock when a transaction starts so two leases can't deadlock
+	db, err := sql.Open("sqlite", cfg.Path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteQueueSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	return &SQLite{
+		Config: cfg,
+		Worker: host + ":" + strconv.Itoa(os.Getpid()),
+		db:     db,
+	}, nil
+}
+
+// Reset empties the queue for a new run
+func (q *SQLite) Reset(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, `DELETE FROM results; DELETE FROM jobs; DELETE FROM queue;`)
+	return err
+}
+
+// Push adds URLs to the queue
+func (q *SQLite) Push(ctx context.Context, urls ...string) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	for _, url := range urls {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO jobs (url, created_at) VALUES (?, ?)`, url, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Seal marks that no more URLs are coming, so workers stop once the queue is empty
+func (q *SQLite) Seal(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, `INSERT INTO queue (key, value) VALUES ('sealed', '1')
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`)
+	return err
+}
+
+// Lease waits for a pending job or one whose lease ran out. Jobs whose lease
+// ran out max_attempts times are given up as failed instead.
+func (q *SQLite) Lease(ctx context.Context) (*Job, error) {
+	for {
+		job, err := q.lease(ctx, q.Worker)
+		if job != nil || errors.Is(err, ErrDrained) {
+			return job, err
+		}
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Error leasing from the queue", "path", q.Config.Path, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(q.Config.PollInterval):
+		}
+	}
+}
+
+// lease takes the next job for the named worker, returning nil if there is
+// none right now
+func (q *SQLite) lease(ctx context.Context, worker string) (*Job, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if err := q.abandon(ctx, tx, now); err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{Lease: q.Config.LeaseTimeout, token: token}
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE jobs SET state = 'leased', attempts = attempts + 1, worker = ?, token = ?, leased_until = ?
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE state = 'pending' OR (state = 'leased' AND leased_until < ?)
+			ORDER BY id LIMIT 1
+		)
+		RETURNING id, url, attempts`,
+		worker, token, now.Add(q.Config.LeaseTimeout).UnixMilli(), now.UnixMilli(),
+	).Scan(&id, &job.URL, &job.Attempts)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing to lease: finished if sealed with no job left in progress
+		var sealed, open int
+		err := tx.QueryRowContext(ctx, `SELECT
+			(SELECT COUNT(*) FROM queue WHERE key = 'sealed'),
+			(SELECT COUNT(*) FROM jobs WHERE state IN ('pending', 'leased'))`,
+		).Scan(&sealed, &open)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if sealed > 0 && open == 0 {
+			return nil, ErrDrained
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	job.ID = strconv.FormatInt(id, 10)
+	if job.Attempts > 1 {
+		slog.Warn("Leasing job again after its lease ran out", "url", job.URL, "attempt", job.Attempts)
+	}
+	return job, nil
+}
+
+// abandon fails the jobs whose lease ran out on their last attempt, storing
+// a failed result for each
+func (q *SQLite) abandon(ctx context.Context, tx *sql.Tx, now time.Time) error {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE jobs SET state = 'failed', finished_at = ?
+		WHERE state = 'leased' AND leased_until < ? AND attempts >= ?
+		RETURNING id, url, attempts`,
+		now.UnixMilli(), now.UnixMilli(), q.Config.MaxAttempts,
+	)
+	if err != nil {
+		return err
+	}
+
+	var failed []models.Result
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var url string
+		var attempts int
+		if err := rows.Scan(&id, &url, &attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		failed = append(failed, models.Result{
+			URL:        url,
+			Err:        fmt.Sprintf("no result after %d expired leases", attempts),
+			ErrorClass: scraper.ClassAbandoned,
+			Timestamp:  now,
+		})
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for i, result := range failed {
+		slog.Error("Giving up on job", "url", result.URL, "error", result.Err)
+		if err := q.put(ctx, tx, ids[i], q.Worker, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renew extends the lease of a job still being worked on
+func (q *SQLite) Renew(ctx context.Context, job *Job) error {
+	until := time.Now().Add(q.Config.LeaseTimeout).UnixMilli()
+	res, err := q.db.ExecContext(ctx, `UPDATE jobs SET leased_until = ? WHERE id = ? AND token = ? AND state = 'leased'`,
+		until, job.ID, job.token)
+	return leaseResult(res, err)
+}
+
+// Ack marks a leased job done
+func (q *SQLite) Ack(ctx context.Context, job *Job) error {
+	res, err := q.db.ExecContext(ctx, `UPDATE jobs SET state = 'done', finished_at = ?, leased_until = NULL WHERE id = ? AND token = ?`,
+		time.Now().UnixMilli(), job.ID, job.token)
+	return leaseResult(res, err)
+}
+
+// leaseResult turns an update of no rows into ErrLeaseLost
+func leaseResult(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Put stores the result of a job; when a job ran twice the first result is kept
+func (q *SQLite) Put(ctx context.Context, job *Job, result models.Result) error {
+	return q.putAs(ctx, job, q.Worker, result)
+}
+
+// putAs stores the result of a job fetched by the named worker
+func (q *SQLite) putAs(ctx context.Context, job *Job, worker string, result models.Result) error {
+	id, err := strconv.ParseInt(job.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return q.put(ctx, q.db, id, worker, result)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// put inserts a result row
+func (q *SQLite) put(ctx context.Context, db execer, jobID int64, worker string, result models.Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO results (job_id, worker, result, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(job_id) DO NOTHING`,
+		jobID, worker, string(data), time.Now().UnixMilli())
+	return err
+}
+
+// Collect streams the stored results until the queue is sealed and every
+// job is finished, or ctx is cancelled
+func (q *SQLite) Collect(ctx context.Context) <-chan models.Result {
+	results := make(chan models.Result)
+	go func() {
+		defer close(results)
+
+		var last int64
+		for {
+			// Check for the end before reading, so no result stored before it is missed
+			done, err := q.finished(ctx)
+			var batch []models.Result
+			if err == nil {
+				batch, last, err = q.results(ctx, last)
+			}
+			if err != nil && ctx.Err() == nil {
+				slog.Warn("Error reading results from the queue", "path", q.Config.Path, "error", err)
+			}
+
+			for _, result := range batch {
+				select {
+				case results <- result:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if done && err == nil && len(batch) == 0 {
+				return
+			}
+			if len(batch) > 0 {
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(q.Config.PollInterval):
+			}
+		}
+	}()
+	return results
+}
+
+// finished reports whether the queue is sealed with no job left to do, and
+// records the number of jobs left in the queue depth metric
+func (q *SQLite) finished(ctx context.Context) (bool, error) {
+	var sealed, pending, leased int
+	err := q.db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM queue WHERE key = 'sealed'),
+		(SELECT COUNT(*) FROM jobs WHERE state = 'pending'),
+		(SELECT COUNT(*) FROM jobs WHERE state = 'leased')`,
+	).Scan(&sealed, &pending, &leased)
+	if err != nil {
+		return false, err
+	}
+	metrics.QueueDepth.Set(float64(pending))
+	return sealed > 0 && pending == 0 && leased == 0, nil
+}
+
+// results reads the next batch of results stored after the row last
+func (q *SQLite) results(ctx context.Context, last int64) ([]models.Result, int64, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT id, result FROM results WHERE id > ? ORDER BY id LIMIT 500`, last)
+	if err != nil {
+		return nil, last, err
+	}
+	defer rows.Close()
+
+	var batch []models.Result
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return batch, last, err
+		}
+		last = id
+		var result models.Result
+		if err := json.Unmarshal([]byte(data), &result); err != nil {
+			slog.Warn("Skipping unreadable result", "path", q.Config.Path, "id", id, "error", err)
+			continue
+		}
+		batch = append(batch, result)
+	}
+	return batch, last, rows.Err()
+}
+
+// Close closes the database
+func (q *SQLite) Close() error {
+	return q.db.Close()
+}
+
+// newToken returns a random lease token
+func newToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
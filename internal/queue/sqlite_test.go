@@ -0,0 +1,202 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+const testLease = 50 * time.Millisecond
+
+// newTestSQLite opens a queue in a temporary directory holding the sealed URLs
+func newTestSQLite(t *testing.T, maxAttempts int, urls ...string) *SQLite {
+	t.Helper()
+	q, err := NewSQLite(&config.QueueConfig{
+		Backend:      "sqlite",
+		Path:         filepath.Join(t.TempDir(), "queue.db"),
+		LeaseTimeout: testLease,
+		PollInterval: 5 * time.Millisecond,
+		MaxAttempts:  maxAttempts,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	ctx := context.Background()
+	if err := q.Push(ctx, urls...); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Seal(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// lease leases a job, failing the test if there is none within a second
+func lease(t *testing.T, q Queue) *Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	job, err := q.Lease(ctx)
+	if err != nil {
+		t.Fatalf("Lease failed: %v", err)
+	}
+	return job
+}
+
+// collect returns every result stored in the queue
+func collect(t *testing.T, q *SQLite) []models.Result {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var results []models.Result
+	for result := range q.Collect(ctx) {
+		results = append(results, result)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("Collect didn't finish")
+	}
+	return results
+}
+
+func TestSQLiteLeaseAndAck(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/", "http://b.test/")
+	ctx := context.Background()
+
+	for _, want := range []string{"http://a.test/", "http://b.test/"} {
+		job := lease(t, q)
+		if job.URL != want || job.Attempts != 1 || job.Lease != testLease {
+			t.Fatalf("Lease = %+v, want %s on its first attempt", job, want)
+		}
+		if err := q.Put(ctx, job, models.Result{URL: job.URL, StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Ack(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := q.Lease(ctx); !errors.Is(err, ErrDrained) {
+		t.Fatalf("Lease on a finished queue = %v, want ErrDrained", err)
+	}
+	if results := collect(t, q); len(results) != 2 {
+		t.Errorf("Collect returned %d results, want 2", len(results))
+	}
+}
+
+func TestSQLiteLeaseWaitsForURLs(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	job := lease(t, q)
+
+	// The only job is leased: the next lease waits rather than returning
+	ctx, cancel := context.WithTimeout(context.Background(), testLease/2)
+	defer cancel()
+	if _, err := q.Lease(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lease while the only job is leased = %v, want a timeout", err)
+	}
+	if err := q.Ack(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteExpiredLeaseIsHandedOut(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	ctx := context.Background()
+
+	first := lease(t, q)
+	time.Sleep(2 * testLease)
+
+	second := lease(t, q)
+	if second.ID != first.ID || second.Attempts != 2 {
+		t.Fatalf("Lease after expiry = %+v, want job %s on its second attempt", second, first.ID)
+	}
+
+	// The first worker lost the job to the second
+	if err := q.Renew(ctx, first); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew of the expired lease = %v, want ErrLeaseLost", err)
+	}
+	if err := q.Ack(ctx, first); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Ack of the expired lease = %v, want ErrLeaseLost", err)
+	}
+	if err := q.Ack(ctx, second); err != nil {
+		t.Errorf("Ack of the new lease failed: %v", err)
+	}
+}
+
+func TestSQLiteRenewKeepsLease(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	ctx := context.Background()
+
+	job := lease(t, q)
+	for range 4 {
+		time.Sleep(testLease / 2)
+		if err := q.Renew(ctx, job); err != nil {
+			t.Fatalf("Renew failed: %v", err)
+		}
+	}
+
+	// Well past the first lease, the job is still this worker's
+	short, cancel := context.WithTimeout(ctx, testLease/2)
+	defer cancel()
+	if other, err := q.Lease(short); err == nil {
+		t.Fatalf("Lease of a renewed job handed out %+v", other)
+	}
+	if err := q.Ack(ctx, job); err != nil {
+		t.Errorf("Ack after renewing failed: %v", err)
+	}
+}
+
+func TestSQLiteAbandonsAfterMaxAttempts(t *testing.T) {
+	q := newTestSQLite(t, 2, "http://a.test/")
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if job := lease(t, q); job.Attempts != attempt {
+			t.Fatalf("Lease = attempt %d, want %d", job.Attempts, attempt)
+		}
+		time.Sleep(2 * testLease)
+	}
+
+	if job, err := q.Lease(ctx); !errors.Is(err, ErrDrained) {
+		t.Fatalf("Lease after the last attempt expired = %+v, %v, want ErrDrained", job, err)
+	}
+	results := collect(t, q)
+	if len(results) != 1 {
+		t.Fatalf("Collect returned %d results, want 1", len(results))
+	}
+	if got := results[0]; got.URL != "http://a.test/" || got.ErrorClass != scraper.ClassAbandoned || got.Err == "" {
+		t.Errorf("abandoned result = %+v, want a failure with class %q", got, scraper.ClassAbandoned)
+	}
+}
+
+func TestSQLiteKeepsFirstResult(t *testing.T) {
+	q := newTestSQLite(t, 3, "http://a.test/")
+	ctx := context.Background()
+
+	first := lease(t, q)
+	time.Sleep(2 * testLease)
+	second := lease(t, q)
+
+	// Both workers finish: the result stored first wins
+	if err := q.Put(ctx, second, models.Result{URL: second.URL, StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Put(ctx, first, models.Result{URL: first.URL, StatusCode: 500}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := collect(t, q)
+	if len(results) != 1 || results[0].StatusCode != 200 {
+		t.Errorf("Collect = %+v, want the first stored result only", results)
+	}
+}
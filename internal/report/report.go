@@ -0,0 +1,367 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/proxy"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// maxFailureSamples is the number of failed URLs kept per error class
+const maxFailureSamples = 20
+
+// Report summarizes a finished run
+type Report struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	Total      int           `json:"total"`
+	Succeeded  int           `json:"succeeded"`
+	Failed     int           `json:"failed"`
+	Retries    int           `json:"retries"`
+	Bytes      int64         `json:"bytes"`
+
+	Failures   map[string]*FailureClass `json:"failures,omitempty"`
+	Slowest    []Timing                 `json:"slowest,omitempty"`
+	Hosts      map[string]*HostStats    `json:"hosts,omitempty"`
+	Proxies    map[string]int           `json:"proxies,omitempty"`
+	FetchPaths map[string]int           `json:"fetch_paths,omitempty"`
+	Extraction map[string]*Coverage     `json:"extraction,omitempty"`
+}
+
+// FailureClass groups the failures of one error class
+type FailureClass struct {
+	Count   int       `json:"count"`
+	Samples []Failure `json:"samples"`
+}
+
+// Failure describes a single failed URL
+type Failure struct {
+	URL        string `json:"url"`
+	Error      string `json:"error"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+// Timing records how long a URL took to fetch
+type Timing struct {
+	URL      string        `json:"url"`
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status_code,omitempty"`
+}
+
+// HostStats aggregates the results for one host
+type HostStats struct {
+	Requests        int           `json:"requests"`
+	Failures        int           `json:"failures"`
+	Retries         int           `json:"retries"`
+	Bytes           int64         `json:"bytes"`
+	AverageDuration time.Duration `json:"average_duration"`
+
+	totalDuration time.Duration
+}
+
+// Coverage counts how often an extraction field came back empty
+type Coverage struct {
+	Pages int     `json:"pages"`
+	Empty int     `json:"empty"`
+	Rate  float64 `json:"coverage"`
+}
+
+// Builder accumulates results into a report
+type Builder struct {
+	Config *config.AppConfig
+
+	mu      sync.Mutex
+	report  *Report
+	timings []Timing
+	fields  []string
+
+	// sites are the fields of the site profiles with their own extraction rules
+	sites map[*config.SiteConfig][]string
+}
+
+// NewBuilder creates a new report builder for a run starting now
+func NewBuilder(cfg *config.AppConfig) *Builder {
+	fields := extractionFields(&cfg.Extraction)
+	sites := make(map[*config.SiteConfig][]string)
+	for i := range cfg.Sites {
+		if cfg.Sites[i].Extraction != nil {
+			sites[&cfg.Sites[i]] = extractionFields(cfg.Sites[i].Extraction)
+		}
+	}
+
+	report := &Report{
+		StartedAt:  time.Now(),
+		Failures:   make(map[string]*FailureClass),
+		Hosts:      make(map[string]*HostStats),
+		Proxies:    make(map[string]int),
+		FetchPaths: make(map[string]int),
+		Extraction: make(map[string]*Coverage),
+	}
+	for _, name := range fields {
+		report.Extraction[name] = &Coverage{}
+	}
+	for _, siteFields := range sites {
+		for _, name := range siteFields {
+			report.Extraction[name] = &Coverage{}
+		}
+	}
+
+	return &Builder{
+		Config: cfg,
+		report: report,
+		fields: fields,
+		sites:  sites,
+	}
+}
+
+// extractionFields returns the sorted names of every field of the rules
+func extractionFields(rules *config.ExtractionConfig) []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, set := range []map[string]string{rules.Selectors, rules.XPath, rules.Regex} {
+		for name := range set {
+			if !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// fieldsFor returns the fields expected of a result: those of the site
+// profile it was fetched with, or the global ones
+func (b *Builder) fieldsFor(result *models.Result) []string {
+	var profile *config.SiteConfig
+	if result.Site != "" {
+		for i := range b.Config.Sites {
+			if b.Config.Sites[i].Name == result.Site {
+				profile = &b.Config.Sites[i]
+				break
+			}
+		}
+	} else {
+		// Profiles without a name don't label their results
+		profile = b.Config.SiteFor(result.URL)
+	}
+	if fields, ok := b.sites[profile]; ok {
+		return fields
+	}
+	return b.fields
+}
+
+// Observe adds a result to the report
+func (b *Builder) Observe(result models.Result) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.report
+	r.Total++
+	r.Retries += result.Retries
+	r.Bytes += result.Size
+
+	host := metrics.Host(result.URL)
+	stats, ok := r.Hosts[host]
+	if !ok {
+		stats = &HostStats{}
+		r.Hosts[host] = stats
+	}
+	stats.Requests++
+	stats.Retries += result.Retries
+	stats.Bytes += result.Size
+	stats.totalDuration += result.Duration
+
+	if result.ProxyUsed != "" {
+		r.Proxies[proxy.Redacted(result.ProxyUsed)]++
+	}
+	if result.FetchPath != "" {
+		r.FetchPaths[result.FetchPath]++
+	}
+
+	b.timings = append(b.timings, Timing{
+		URL:      result.URL,
+		Duration: result.Duration,
+		Status:   result.StatusCode,
+	})
+
+	if result.Err != "" {
+		r.Failed++
+		stats.Failures++
+
+		class := result.ErrorClass
+		if class == "" {
+			class = "other"
+		}
+		failures, ok := r.Failures[class]
+		if !ok {
+			failures = &FailureClass{}
+			r.Failures[class] = failures
+		}
+		failures.Count++
+		if len(failures.Samples) < maxFailureSamples {
+			failures.Samples = append(failures.Samples, Failure{
+				URL:        result.URL,
+				Error:      result.Err,
+				StatusCode: result.StatusCode,
+			})
+		}
+		return
+	}
+
+	r.Succeeded++
+
+	// Only HTML pages go through the extractor
+	if result.ContentType != "" && result.ContentType != "text/html" && result.ContentType != "application/xhtml+xml" {
+		return
+	}
+	if b.Config.Scraper.HeadOnly {
+		return
+	}
+	for _, name := range b.fieldsFor(&result) {
+		coverage := r.Extraction[name]
+		coverage.Pages++
+		if models.IsEmpty(result.Extracted[name]) {
+			coverage.Empty++
+		}
+	}
+}
+
+// Finish completes the report
+func (b *Builder) Finish() *Report {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.report
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt)
+
+	for _, stats := range r.Hosts {
+		if stats.Requests > 0 {
+			stats.AverageDuration = stats.totalDuration / time.Duration(stats.Requests)
+		}
+	}
+
+	for _, coverage := range r.Extraction {
+		if coverage.Pages > 0 {
+			coverage.Rate = float64(coverage.Pages-coverage.Empty) / float64(coverage.Pages)
+		}
+	}
+
+	slowest := b.Config.Report.Slowest
+	if slowest <= 0 {
+		slowest = 10
+	}
+	sort.SliceStable(b.timings, func(i, j int) bool {
+		return b.timings[i].Duration > b.timings[j].Duration
+	})
+	if len(b.timings) < slowest {
+		slowest = len(b.timings)
+	}
+	r.Slowest = append([]Timing(nil), b.timings[:slowest]...)
+
+	return r
+}
+
+// WriteJSON writes the report to a JSON file
+func (r *Report) WriteJSON(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// WriteSummary writes a human-readable summary of the report
+func (r *Report) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "\nRun summary\n")
+	fmt.Fprintf(w, "  URLs: %d total, %d succeeded, %d failed in %s\n",
+		r.Total, r.Succeeded, r.Failed, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "  Retries: %d, downloaded: %s\n", r.Retries, formatBytes(r.Bytes))
+
+	if len(r.Failures) > 0 {
+		fmt.Fprintf(w, "\nFailures by class\n")
+		for _, class := range sortedKeys(r.Failures) {
+			failures := r.Failures[class]
+			fmt.Fprintf(w, "  %-12s %d\n", class, failures.Count)
+			for i, f := range failures.Samples {
+				if i == 3 {
+					fmt.Fprintf(w, "    ... and %d more\n", failures.Count-i)
+					break
+				}
+				fmt.Fprintf(w, "    %s: %s\n", f.URL, f.Error)
+			}
+		}
+	}
+
+	if len(r.Slowest) > 0 {
+		fmt.Fprintf(w, "\nSlowest URLs\n")
+		for _, t := range r.Slowest {
+			fmt.Fprintf(w, "  %10s  %s\n", t.Duration.Round(time.Millisecond), t.URL)
+		}
+	}
+
+	if len(r.Hosts) > 0 {
+		fmt.Fprintf(w, "\nHosts\n")
+		for _, host := range sortedKeys(r.Hosts) {
+			stats := r.Hosts[host]
+			fmt.Fprintf(w, "  %-30s %d requests, %d failed, %d retries, %s, avg %s\n",
+				host, stats.Requests, stats.Failures, stats.Retries,
+				formatBytes(stats.Bytes), stats.AverageDuration.Round(time.Millisecond))
+		}
+	}
+
+	if len(r.Proxies) > 0 {
+		fmt.Fprintf(w, "\nProxies\n")
+		for _, p := range sortedKeys(r.Proxies) {
+			fmt.Fprintf(w, "  %-30s %d\n", p, r.Proxies[p])
+		}
+	}
+
+	if len(r.FetchPaths) > 0 {
+		fmt.Fprintf(w, "\nFetch paths\n")
+		for _, p := range sortedKeys(r.FetchPaths) {
+			fmt.Fprintf(w, "  %-30s %d\n", p, r.FetchPaths[p])
+		}
+	}
+
+	if len(r.Extraction) > 0 {
+		fmt.Fprintf(w, "\nExtraction coverage\n")
+		for _, name := range sortedKeys(r.Extraction) {
+			coverage := r.Extraction[name]
+			fmt.Fprintf(w, "  %-20s %5.1f%% (%d of %d pages empty)\n",
+				name, coverage.Rate*100, coverage.Empty, coverage.Pages)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
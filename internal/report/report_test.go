@@ -0,0 +1,55 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+func TestExtractionCoverageBySite(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Extraction = config.ExtractionConfig{Selectors: map[string]string{"title": "h1"}}
+	cfg.Sites = []config.SiteConfig{
+		{Name: "shop", Hosts: []string{"shop.example"}, Extraction: &config.ExtractionConfig{
+			Selectors: map[string]string{"price": ".price"},
+		}},
+		{Hosts: []string{"blog.example"}, Extraction: &config.ExtractionConfig{
+			Regex: map[string]string{"author": "by (\\w+)"},
+		}},
+	}
+
+	b := NewBuilder(cfg)
+	for _, result := range []models.Result{
+		{URL: "https://news.example/a", Extracted: map[string]interface{}{"title": "A"}},
+		{URL: "https://news.example/b", Extracted: map[string]interface{}{"title": " "}},
+		{URL: "https://shop.example/p", Site: "shop", Extracted: map[string]interface{}{"price": "9.99"}},
+		{URL: "https://blog.example/post", Extracted: map[string]interface{}{"author": []string{""}}},
+		{URL: "https://news.example/c", Err: "timeout"},
+	} {
+		b.Observe(result)
+	}
+	r := b.Finish()
+
+	tests := []struct {
+		field string
+		want  Coverage
+	}{
+		{field: "title", want: Coverage{Pages: 2, Empty: 1, Rate: 0.5}},
+		{field: "price", want: Coverage{Pages: 1, Empty: 0, Rate: 1}},
+		{field: "author", want: Coverage{Pages: 1, Empty: 1, Rate: 0}},
+	}
+	for _, tt := range tests {
+		got, ok := r.Extraction[tt.field]
+		if !ok {
+			t.Errorf("no coverage for %q", tt.field)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("coverage of %q = %+v, want %+v", tt.field, *got, tt.want)
+		}
+	}
+	if len(r.Extraction) != len(tests) {
+		t.Errorf("coverage of %d fields, want %d", len(r.Extraction), len(tests))
+	}
+}
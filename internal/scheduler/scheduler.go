@@ -0,0 +1,350 @@
+// Package scheduler runs named scrape jobs on cron expressions or intervals
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cron"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+)
+
+// Catch-up policies for runs missed while the scheduler was down
+const (
+	CatchUpSkip = "skip" // Wait for the next scheduled time
+	CatchUpOnce = "once" // Run once straight away
+	CatchUpAll  = "all"  // Run once for every missed time
+)
+
+// Run outcomes
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// maxCatchUp bounds the number of missed runs made up for under the "all"
+// policy; the most recent ones are kept
+const maxCatchUp = 100
+
+// RunFunc runs one scrape for a job; cancelling ctx should stop it early
+type RunFunc func(ctx context.Context, job *config.ScheduledJob) (*report.Report, error)
+
+// JobState is the persisted record of a job's runs
+type JobState struct {
+	LastScheduled time.Time `json:"last_scheduled"` // The scheduled time of the last run
+	LastStarted   time.Time `json:"last_started"`
+	LastFinished  time.Time `json:"last_finished"`
+	LastStatus    string    `json:"last_status"`
+	LastError     string    `json:"last_error,omitempty"`
+	Total         int       `json:"total"`
+	Succeeded     int       `json:"succeeded"`
+	Failed        int       `json:"failed"`
+	Runs          int       `json:"runs"`
+	Skipped       int       `json:"skipped"` // Scheduled times skipped because the previous run was still going
+}
+
+// Scheduler runs every job of the schedule on its own timeline. A job never
+// overlaps with itself: times that come round while it is running are skipped.
+type Scheduler struct {
+	Config *config.ScheduleConfig
+	Run    RunFunc
+
+	mu    sync.Mutex
+	state map[string]*JobState
+}
+
+// NewScheduler creates a scheduler, loading the state left by an earlier one
+func NewScheduler(cfg *config.ScheduleConfig, run RunFunc) (*Scheduler, error) {
+	s := &Scheduler{
+		Config: cfg,
+		Run:    run,
+		state:  make(map[string]*JobState),
+	}
+
+	data, err := os.ReadFile(cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("reading schedule state %s: %w", cfg.StateFile, err)
+	}
+	return s, nil
+}
+
+// Start runs the jobs until ctx is cancelled, then waits for the running
+// ones to stop
+func (s *Scheduler) Start(ctx context.Context) error {
+	if len(s.Config.Jobs) == 0 {
+		return errors.New("no jobs scheduled")
+	}
+
+	timings := make([]timing, len(s.Config.Jobs))
+	for i := range s.Config.Jobs {
+		t, err := newTiming(&s.Config.Jobs[i])
+		if err != nil {
+			return fmt.Errorf("job %q: %w", s.Config.Jobs[i].Name, err)
+		}
+		timings[i] = t
+	}
+
+	var wg sync.WaitGroup
+	for i := range s.Config.Jobs {
+		wg.Add(1)
+		go func(job *config.ScheduledJob, t timing) {
+			defer wg.Done()
+			s.loop(ctx, job, t)
+		}(&s.Config.Jobs[i], timings[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// State returns a copy of the recorded state of every job
+func (s *Scheduler) State() map[string]JobState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := make(map[string]JobState, len(s.state))
+	for name, js := range s.state {
+		state[name] = *js
+	}
+	return state
+}
+
+// loop runs one job: first the runs missed while the scheduler was down,
+// as the catch-up policy says, then every scheduled time until ctx is cancelled
+func (s *Scheduler) loop(ctx context.Context, job *config.ScheduledJob, t timing) {
+	now := time.Now()
+	last := s.State()[job.Name].LastScheduled
+
+	// Work out the next scheduled time, and which runs were missed
+	var next, latest time.Time
+	var missed []time.Time
+	count := 0
+	switch {
+	case last.IsZero() && t.interval > 0:
+		// A new interval job runs straight away
+		next = now
+	case last.IsZero():
+		next = t.next(now)
+	default:
+		next = t.next(last)
+		for !next.IsZero() && !next.After(now) {
+			count++
+			latest = next
+			if missed = append(missed, next); len(missed) > maxCatchUp {
+				missed = missed[1:]
+			}
+			next = t.next(next)
+		}
+	}
+
+	if count > 0 {
+		policy := s.catchUp(job)
+		slog.Info("Missed scheduled runs", "job", job.Name, "missed", count, "since", last, "catch_up", policy)
+		switch policy {
+		case CatchUpOnce:
+			s.execute(ctx, job, latest)
+		case CatchUpAll:
+			for _, at := range missed {
+				if ctx.Err() != nil {
+					return
+				}
+				s.execute(ctx, job, at)
+			}
+		}
+		// The catch-up runs may have taken past the next scheduled time
+		if policy != CatchUpSkip {
+			next = t.next(time.Now())
+		}
+	}
+
+	for {
+		if next.IsZero() {
+			slog.Error("Job has no upcoming scheduled time", "job", job.Name, "cron", job.Cron)
+			return
+		}
+		slog.Info("Next scheduled run", "job", job.Name, "at", next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.execute(ctx, job, next)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Skip the times that came round while the job was running
+		scheduled := next
+		next = t.next(next)
+		skipped := 0
+		for !next.IsZero() && !next.After(time.Now()) {
+			skipped++
+			next = t.next(next)
+		}
+		if skipped > 0 {
+			slog.Warn("Skipped runs that would have overlapped the previous one", "job", job.Name, "skipped", skipped, "scheduled", scheduled)
+			s.update(job.Name, func(js *JobState) { js.Skipped += skipped })
+		}
+	}
+}
+
+// execute waits for the jitter, runs the job and records its outcome
+func (s *Scheduler) execute(ctx context.Context, job *config.ScheduledJob, scheduled time.Time) {
+	if jitter := s.jitter(job); jitter > 0 {
+		delay := time.Duration(rand.Int63n(int64(jitter)))
+		slog.Debug("Delaying run by jitter", "job", job.Name, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	started := time.Now()
+	s.update(job.Name, func(js *JobState) {
+		js.LastScheduled = scheduled
+		js.LastStarted = started
+		js.LastStatus = StatusRunning
+		js.LastError = ""
+	})
+	slog.Info("Starting scheduled run", "job", job.Name, "scheduled", scheduled)
+
+	summary, err := s.Run(ctx, job)
+
+	s.update(job.Name, func(js *JobState) {
+		js.LastFinished = time.Now()
+		js.Runs++
+		switch {
+		case err != nil:
+			js.LastStatus = StatusFailed
+			js.LastError = err.Error()
+		case ctx.Err() != nil:
+			js.LastStatus = StatusCancelled
+		default:
+			js.LastStatus = StatusCompleted
+		}
+		js.Total, js.Succeeded, js.Failed = 0, 0, 0
+		if summary != nil {
+			js.Total, js.Succeeded, js.Failed = summary.Total, summary.Succeeded, summary.Failed
+		}
+	})
+
+	if err != nil {
+		slog.Error("Scheduled run failed", "job", job.Name, "error", err, "duration", time.Since(started))
+		return
+	}
+	attrs := []any{"job", job.Name, "duration", time.Since(started)}
+	if summary != nil {
+		attrs = append(attrs, "urls", summary.Total, "succeeded", summary.Succeeded, "failed", summary.Failed)
+	}
+	slog.Info("Scheduled run finished", attrs...)
+}
+
+// update changes a job's state and saves the state file
+func (s *Scheduler) update(name string, change func(*JobState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	js := s.state[name]
+	if js == nil {
+		js = &JobState{}
+		s.state[name] = js
+	}
+	change(js)
+
+	if err := s.save(); err != nil {
+		slog.Error("Error saving schedule state", "file", s.Config.StateFile, "error", err)
+	}
+}
+
+// save writes the state file; callers must hold the lock
+func (s *Scheduler) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted save keeps the old state
+	tmp, err := os.CreateTemp(filepath.Dir(s.Config.StateFile), ".schedule-state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Config.StateFile)
+}
+
+// jitter returns the job's jitter, falling back to the schedule's
+func (s *Scheduler) jitter(job *config.ScheduledJob) time.Duration {
+	if job.Jitter != nil {
+		return *job.Jitter
+	}
+	return s.Config.Jitter
+}
+
+// catchUp returns the job's catch-up policy, falling back to the schedule's
+func (s *Scheduler) catchUp(job *config.ScheduledJob) string {
+	switch {
+	case job.CatchUp != "":
+		return job.CatchUp
+	case s.Config.CatchUp != "":
+		return s.Config.CatchUp
+	}
+	return CatchUpSkip
+}
+
+// timing computes a job's scheduled times from its cron expression or interval
+type timing struct {
+	cron     *cron.Schedule
+	interval time.Duration
+}
+
+// newTiming parses the job's cron expression or interval
+func newTiming(job *config.ScheduledJob) (timing, error) {
+	if job.Cron != "" {
+		schedule, err := cron.Parse(job.Cron)
+		if err != nil {
+			return timing{}, err
+		}
+		return timing{cron: schedule}, nil
+	}
+	if job.Every <= 0 {
+		return timing{}, errors.New("needs either cron or every")
+	}
+	return timing{interval: job.Every}, nil
+}
+
+// next returns the first scheduled time after t
+func (t timing) next(after time.Time) time.Time {
+	if t.cron != nil {
+		return t.cron.Next(after)
+	}
+	return after.Add(t.interval)
+}
@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+)
+
+// writeState writes a state file recording the given last scheduled time for a job
+func writeState(t *testing.T, file, name string, last time.Time) {
+	t.Helper()
+	data, err := json.Marshal(map[string]JobState{name: {LastScheduled: last, LastStatus: StatusCompleted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor polls until cond holds or the deadline passes
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestCatchUp(t *testing.T) {
+	const interval = time.Hour
+	last := time.Now().Add(-150*interval - 30*time.Minute).Truncate(time.Second)
+	latest := last.Add(150 * interval)
+
+	tests := []struct {
+		name   string
+		policy string
+		runs   int
+		first  time.Time
+	}{
+		{name: "skip", policy: CatchUpSkip, runs: 0},
+		{name: "once", policy: CatchUpOnce, runs: 1, first: latest},
+		{name: "all", policy: CatchUpAll, runs: maxCatchUp, first: last.Add(51 * interval)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ScheduleConfig{
+				StateFile: filepath.Join(t.TempDir(), "state.json"),
+				CatchUp:   tt.policy,
+				Jobs:      []config.ScheduledJob{{Name: "job", Every: interval}},
+			}
+			writeState(t, cfg.StateFile, "job", last)
+
+			var mu sync.Mutex
+			var scheduled []time.Time
+			var s *Scheduler
+			s, err := NewScheduler(cfg, func(ctx context.Context, job *config.ScheduledJob) (*report.Report, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				scheduled = append(scheduled, s.State()[job.Name].LastScheduled)
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- s.Start(ctx) }()
+
+			if tt.runs > 0 {
+				waitFor(t, 5*time.Second, func() bool { return s.State()["job"].Runs >= tt.runs })
+			} else {
+				time.Sleep(100 * time.Millisecond)
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("Start() = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(scheduled) != tt.runs {
+				t.Fatalf("ran %d times, want %d", len(scheduled), tt.runs)
+			}
+			if tt.runs == 0 {
+				if got := s.State()["job"].LastScheduled; !got.Equal(last) {
+					t.Errorf("LastScheduled = %v, want %v", got, last)
+				}
+				return
+			}
+			if !scheduled[0].Equal(tt.first) {
+				t.Errorf("first run scheduled at %v, want %v", scheduled[0], tt.first)
+			}
+			if got := scheduled[len(scheduled)-1]; !got.Equal(latest) {
+				t.Errorf("last run scheduled at %v, want the latest missed time %v", got, latest)
+			}
+			if got := s.State()["job"].LastScheduled; !got.Equal(latest) {
+				t.Errorf("LastScheduled = %v, want %v", got, latest)
+			}
+		})
+	}
+}
+
+func TestOverlapSkipped(t *testing.T) {
+	cfg := &config.ScheduleConfig{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Jobs:      []config.ScheduledJob{{Name: "slow", Every: 20 * time.Millisecond}},
+	}
+
+	var running, overlaps atomic.Int32
+	s, err := NewScheduler(cfg, func(ctx context.Context, job *config.ScheduledJob) (*report.Report, error) {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		defer running.Add(-1)
+		time.Sleep(70 * time.Millisecond)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Start(ctx) }()
+	waitFor(t, 5*time.Second, func() bool { return s.State()["slow"].Runs >= 3 })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+
+	state := s.State()["slow"]
+	if state.Runs < 3 {
+		t.Fatalf("Runs = %d, want at least 3", state.Runs)
+	}
+	if state.Skipped == 0 {
+		t.Errorf("Skipped = 0, want the times that came round during each run")
+	}
+	if n := overlaps.Load(); n != 0 {
+		t.Errorf("%d runs overlapped the previous one", n)
+	}
+}
+
+func TestStatePersisted(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *report.Report
+		err    error
+		want   JobState
+	}{
+		{
+			name:   "completed",
+			result: &report.Report{Total: 3, Succeeded: 2, Failed: 1},
+			want:   JobState{LastStatus: StatusCompleted, Total: 3, Succeeded: 2, Failed: 1, Runs: 1},
+		},
+		{
+			name: "failed",
+			err:  errors.New("boom"),
+			want: JobState{LastStatus: StatusFailed, LastError: "boom", Runs: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ScheduleConfig{
+				StateFile: filepath.Join(t.TempDir(), "state.json"),
+				Jobs:      []config.ScheduledJob{{Name: "job", Every: time.Hour}},
+			}
+			s, err := NewScheduler(cfg, func(ctx context.Context, job *config.ScheduledJob) (*report.Report, error) {
+				return tt.result, tt.err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- s.Start(ctx) }()
+			waitFor(t, 5*time.Second, func() bool { return s.State()["job"].Runs >= 1 })
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("Start() = %v", err)
+			}
+
+			// A new scheduler picks up where the old one left off
+			reloaded, err := NewScheduler(cfg, nil)
+			if err != nil {
+				t.Fatalf("NewScheduler() = %v", err)
+			}
+			got := reloaded.State()["job"]
+			if got.LastScheduled.IsZero() || got.LastStarted.IsZero() || got.LastFinished.IsZero() {
+				t.Errorf("reloaded state is missing run times: %+v", got)
+			}
+			got.LastScheduled, got.LastStarted, got.LastFinished = time.Time{}, time.Time{}, time.Time{}
+			if got != tt.want {
+				t.Errorf("reloaded state = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSchedulerBadState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(file, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewScheduler(&config.ScheduleConfig{StateFile: file}, nil); err == nil {
+		t.Error("NewScheduler() with a corrupt state file = nil error")
+	}
+}
@@ -0,0 +1,309 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/chromedp"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+)
+
+// Authenticator runs the configured login sequence and tracks the resulting sessions
+type Authenticator struct {
+	Config  *config.AppConfig
+	Cookies *cookies.Jar
+	Client  *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// session tracks the login state of a single host, or of the whole run
+type session struct {
+	mu         sync.Mutex
+	loggedIn   bool
+	generation int
+}
+
+// NewAuthenticator creates a new authenticator that stores its session cookies in jar
+func NewAuthenticator(config *config.AppConfig, jar *cookies.Jar) *Authenticator {
+	client := &http.Client{Timeout: config.Scraper.Timeout}
+	if jar != nil {
+		client.Jar = jar
+	}
+
+	return &Authenticator{
+		Config:   config,
+		Cookies:  jar,
+		Client:   client,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Applies reports whether the URL requires a login
+func (a *Authenticator) Applies(target *url.URL) bool {
+	if len(a.Config.Auth.Domains) == 0 {
+		return true
+	}
+
+	host := strings.ToLower(target.Hostname())
+	for _, domain := range a.Config.Auth.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ensure logs in for the URL unless a valid session already exists. Concurrent
+// callers for the same session wait for a single login. The returned generation
+// identifies the login so that Invalidate only discards that one.
+func (a *Authenticator) Ensure(target *url.URL, client *http.Client) (int, error) {
+	sess := a.session(target)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.loggedIn {
+		return sess.generation, nil
+	}
+
+	loginURL, err := target.Parse(a.Config.Auth.LoginURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid login URL: %w", err)
+	}
+
+	if a.Config.Auth.Type == "browser" {
+		err = a.browserLogin(loginURL)
+	} else {
+		if client == nil {
+			client = a.Client
+		}
+		err = a.formLogin(loginURL, client)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w for %s: %w", errLoginFailed, loginURL.Host, err)
+	}
+
+	sess.loggedIn = true
+	sess.generation++
+	slog.Info("Logged in", "host", loginURL.Host, "type", a.Config.Auth.Type)
+	return sess.generation, nil
+}
+
+// Invalidate discards the session for the URL if it is still the given login
+func (a *Authenticator) Invalidate(target *url.URL, generation int) {
+	sess := a.session(target)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.generation == generation {
+		sess.loggedIn = false
+	}
+}
+
+// Expired reports whether a response shows that the session has expired
+func (a *Authenticator) Expired(target *url.URL, resp *http.Response) bool {
+	for _, code := range a.Config.Auth.ExpiredStatus {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return a.ExpiredLocation(target, resp.Request.URL)
+}
+
+// ExpiredLocation reports whether a request for target ended up on the login page
+func (a *Authenticator) ExpiredLocation(target, final *url.URL) bool {
+	ref := a.Config.Auth.ExpiredURL
+	if ref == "" {
+		ref = a.Config.Auth.LoginURL
+	}
+	if ref == "" || final == nil {
+		return false
+	}
+
+	loginURL, err := target.Parse(ref)
+	if err != nil {
+		return false
+	}
+
+	// Scraping the login page itself is not a sign of expiry
+	if sameLocation(target, loginURL) {
+		return false
+	}
+	return sameLocation(final, loginURL)
+}
+
+// session returns the session tracking state for the URL
+func (a *Authenticator) session(target *url.URL) *session {
+	key := ""
+	if a.Config.Auth.Scope != "session" {
+		key = strings.ToLower(target.Hostname())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, ok := a.sessions[key]
+	if !ok {
+		sess = &session{}
+		a.sessions[key] = sess
+	}
+	return sess
+}
+
+// formLogin posts the configured credentials to the login form
+func (a *Authenticator) formLogin(loginURL *url.URL, client *http.Client) error {
+	values := url.Values{}
+	for name, value := range a.Config.Auth.Fields {
+		values.Set(name, os.ExpandEnv(value))
+	}
+
+	// Take the CSRF token from the login page
+	if a.Config.Auth.CSRF.Selector != "" {
+		doc, err := a.fetchDocument(client, "GET", loginURL.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		token := doc.Find(a.Config.Auth.CSRF.Selector).First()
+		if token.Length() == 0 {
+			return fmt.Errorf("CSRF token %q not found on login page", a.Config.Auth.CSRF.Selector)
+		}
+
+		attribute := a.Config.Auth.CSRF.Attribute
+		if attribute == "" {
+			attribute = "value"
+		}
+		field := a.Config.Auth.CSRF.Field
+		if field == "" {
+			field = token.AttrOr("name", "")
+		}
+		if field == "" {
+			return errors.New("CSRF token element has no name; set csrf.field")
+		}
+		values.Set(field, token.AttrOr(attribute, ""))
+	}
+
+	submitURL := loginURL
+	if a.Config.Auth.SubmitURL != "" {
+		var err error
+		submitURL, err = loginURL.Parse(a.Config.Auth.SubmitURL)
+		if err != nil {
+			return fmt.Errorf("invalid submit URL: %w", err)
+		}
+	}
+
+	doc, err := a.fetchDocument(client, "POST", submitURL.String(), values)
+	if err != nil {
+		return err
+	}
+
+	if a.Config.Auth.SuccessSelector != "" && doc.Find(a.Config.Auth.SuccessSelector).Length() == 0 {
+		return fmt.Errorf("success selector %q not found after logging in", a.Config.Auth.SuccessSelector)
+	}
+	return nil
+}
+
+// fetchDocument performs a login request and parses the response
+func (a *Authenticator) fetchDocument(client *http.Client, method, target string, form url.Values) (*goquery.Document, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if len(a.Config.Scraper.UserAgents) > 0 {
+		req.Header.Set("User-Agent", a.Config.Scraper.UserAgents[0])
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s %s returned status %d", method, target, resp.StatusCode)
+	}
+
+	return goquery.NewDocumentFromReader(resp.Body)
+}
+
+// browserLogin drives the login form in a browser and copies the resulting
+// cookies into the shared jar
+func (a *Authenticator) browserLogin(loginURL *url.URL) error {
+	if a.Cookies == nil {
+		return errors.New("browser login requires a cookie jar")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.Config.Scraper.Timeout)
+	defer cancel()
+
+	allocCtx, cancel := chromedp.NewExecAllocator(ctx, allocatorOptions(a.Config)...)
+	defer cancel()
+
+	browserCtx, cancel := chromedp.NewContext(allocCtx)
+	defer cancel()
+	metrics.BrowserTabs.Inc()
+	defer metrics.BrowserTabs.Dec()
+
+	login := a.Config.Auth.Browser
+	tasks := []chromedp.Action{
+		importCookies(a.Cookies),
+		chromedp.Navigate(loginURL.String()),
+	}
+
+	// Fill in the fields in a stable order
+	selectors := make([]string, 0, len(login.Fields))
+	for selector := range login.Fields {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+	for _, selector := range selectors {
+		tasks = append(tasks,
+			chromedp.WaitVisible(selector, chromedp.ByQuery),
+			chromedp.SendKeys(selector, os.ExpandEnv(login.Fields[selector]), chromedp.ByQuery),
+		)
+	}
+
+	if login.Submit != "" {
+		tasks = append(tasks, chromedp.Click(login.Submit, chromedp.ByQuery))
+	}
+
+	switch {
+	case login.WaitFor != "":
+		tasks = append(tasks, chromedp.WaitVisible(login.WaitFor, chromedp.ByQuery))
+	case a.Config.Auth.SuccessSelector != "":
+		tasks = append(tasks, chromedp.WaitVisible(a.Config.Auth.SuccessSelector, chromedp.ByQuery))
+	default:
+		tasks = append(tasks, chromedp.Sleep(a.Config.Browser.WaitTime))
+	}
+
+	tasks = append(tasks, exportCookies(a.Cookies))
+	return chromedp.Run(browserCtx, tasks...)
+}
+
+// sameLocation reports whether two URLs point at the same host and path
+func sameLocation(a, b *url.URL) bool {
+	return strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		strings.TrimSuffix(a.Path, "/") == strings.TrimSuffix(b.Path, "/")
+}
@@ -0,0 +1,114 @@
+package scraper
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// errBodyTooLarge is returned when a response body exceeds a configured limit
+var errBodyTooLarge = errors.New("response body too large")
+
+// acceptEncoding lists the content codings readBody can decode
+const acceptEncoding = "gzip, deflate"
+
+// limitedReader counts the bytes read through it and fails once more than
+// max bytes have been read. A max of zero or less means no limit.
+type limitedReader struct {
+	r    io.Reader
+	max  int64
+	n    int64
+	what string
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Never read more than one byte past the limit
+	if l.max > 0 && int64(len(p)) > l.max-l.n+1 {
+		p = p[:l.max-l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.max > 0 && l.n > l.max {
+		return n, fmt.Errorf("%w: %s exceeds the %d byte limit", errBodyTooLarge, l.what, l.max)
+	}
+	return n, err
+}
+
+// readBody streams a response body, enforcing the size limits and decoding
+// any Content-Encoding itself so that compression bombs are caught. It returns
+// the decoded body and the number of bytes read from the wire.
+func readBody(resp *http.Response, limits config.ContentConfig) ([]byte, int64, error) {
+	// Fail fast when the server announces an oversized body
+	if limits.MaxBodySize > 0 && resp.ContentLength > limits.MaxBodySize {
+		return nil, 0, fmt.Errorf("%w: Content-Length %d exceeds the %d byte limit",
+			errBodyTooLarge, resp.ContentLength, limits.MaxBodySize)
+	}
+
+	wire := &limitedReader{r: resp.Body, max: limits.MaxBodySize, what: "body"}
+
+	var r io.Reader = wire
+	switch coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); coding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, wire.n, fmt.Errorf("reading gzip body: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case "deflate":
+		deflated, err := newDeflateReader(wire)
+		if err != nil {
+			return nil, wire.n, fmt.Errorf("reading deflate body: %w", err)
+		}
+		defer deflated.Close()
+		r = deflated
+	default:
+		return nil, 0, fmt.Errorf("unsupported Content-Encoding %q", coding)
+	}
+
+	// Only apply the decompressed limit when the body was actually compressed
+	if r != io.Reader(wire) {
+		r = &limitedReader{r: r, max: limits.MaxDecompressedSize, what: "decompressed body"}
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, wire.n, err
+	}
+	return body, wire.n, nil
+}
+
+// newDeflateReader decodes a "deflate" body, which is meant to be zlib-wrapped
+// but is sent as raw DEFLATE by some servers
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zlib header uses compression method 8 and is a multiple of 31
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// flattenHeaders converts response headers into a map of comma-joined values
+func flattenHeaders(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for name, values := range header {
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
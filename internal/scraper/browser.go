@@ -2,31 +2,47 @@ package scraper
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/chromedp"
 	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
 	"github.com/williampepple1/concurrent-web-scraper/internal/extraction"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
 	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
 )
 
 // BrowserScraper implements browser-based scraping
 type BrowserScraper struct {
 	Config    *config.AppConfig
-	Extractor *extraction.Extractor
+	Extractor Extractor
+	Cookies   *cookies.Jar
+	Auth      *Authenticator
+	Hooks     *Hooks
 }
 
 // NewBrowserScraper creates a new browser scraper
-func NewBrowserScraper(config *config.AppConfig) *BrowserScraper {
-	return &BrowserScraper{
+func NewBrowserScraper(config *config.AppConfig, jar *cookies.Jar) *BrowserScraper {
+	s := &BrowserScraper{
 		Config:    config,
 		Extractor: extraction.NewExtractor(&config.Extraction),
+		Cookies:   jar,
 	}
+	if config.Auth.Enabled {
+		s.Auth = NewAuthenticator(config, jar)
+	}
+	return s
 }
 
 // Fetch fetches a URL using a headless browser for JavaScript rendering
@@ -37,59 +53,70 @@ func (s *BrowserScraper) Fetch(url string) models.Result {
 	ctx, cancel := context.WithTimeout(context.Background(), s.Config.Scraper.Timeout)
 	defer cancel()
 
-	// Configure browser options
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("headless", s.Config.Browser.Headless),
-		chromedp.UserAgent(s.Config.Browser.UserAgent),
-	)
-
 	// Create a new ExecAllocator
-	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opts...)
+	allocCtx, cancel := chromedp.NewExecAllocator(ctx, allocatorOptions(s.Config)...)
 	defer cancel()
 
 	// Create a new browser context
 	browserCtx, cancel := chromedp.NewContext(allocCtx)
 	defer cancel()
+	metrics.BrowserTabs.Inc()
+	defer metrics.BrowserTabs.Dec()
 
-	// Create a channel to capture errors
-	errChan := make(chan error, 1)
-	var html string
-	var screenshot []byte
+	var page renderedPage
 	var statusCode int
 
-	// Run the browser tasks
-	go func() {
-		tasks := []chromedp.Action{
-			chromedp.Navigate(url),
-			chromedp.Sleep(s.Config.Browser.WaitTime),
-			chromedp.OuterHTML("html", &html),
+	// Let hooks adjust or reject the request; their headers are sent with the page load
+	headers, err := s.requestHeaders(url)
+	if err != nil {
+		return models.Result{
+			URL:        url,
+			Err:        err.Error(),
+			ErrorClass: classifyError(err),
+			Duration:   time.Since(start),
+			Timestamp:  time.Now(),
+			JSRendered: true,
 		}
+	}
 
-		// Add screenshot task if enabled
-		if s.Config.Browser.Screenshot {
-			tasks = append(tasks, chromedp.CaptureScreenshot(&screenshot))
+	// Log in first if the page is protected
+	var target *neturl.URL
+	var generation int
+	if s.Auth != nil {
+		parsed, err := neturl.Parse(url)
+		if err == nil && s.Auth.Applies(parsed) {
+			target = parsed
+			generation, err = s.Auth.Ensure(target, nil)
 		}
-
-		// Run the tasks
-		errChan <- chromedp.Run(browserCtx, tasks...)
-	}()
-
-	// Wait for completion or timeout
-	select {
-	case err := <-errChan:
 		if err != nil {
 			return models.Result{
 				URL:        url,
 				Err:        err.Error(),
+				ErrorClass: classifyError(err),
 				Duration:   time.Since(start),
 				Timestamp:  time.Now(),
 				JSRendered: true,
 			}
 		}
-	case <-ctx.Done():
+	}
+
+	err = s.render(ctx, browserCtx, url, headers, &page)
+
+	// Log in again and retry once if the session expired
+	if err == nil && target != nil {
+		if final, perr := neturl.Parse(page.Location); perr == nil && s.Auth.ExpiredLocation(target, final) {
+			s.Auth.Invalidate(target, generation)
+			if _, err = s.Auth.Ensure(target, nil); err == nil {
+				err = s.render(ctx, browserCtx, url, headers, &page)
+			}
+		}
+	}
+
+	if err != nil {
 		return models.Result{
 			URL:        url,
-			Err:        "browser timeout",
+			Err:        err.Error(),
+			ErrorClass: classifyError(err),
 			Duration:   time.Since(start),
 			Timestamp:  time.Now(),
 			JSRendered: true,
@@ -97,12 +124,13 @@ func (s *BrowserScraper) Fetch(url string) models.Result {
 	}
 
 	// Create a goquery document from the HTML
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page.HTML))
 	if err != nil {
 		return models.Result{
 			URL:        url,
-			Content:    html,
+			Content:    page.HTML,
 			Err:        err.Error(),
+			ErrorClass: ClassParse,
 			Duration:   time.Since(start),
 			Timestamp:  time.Now(),
 			JSRendered: true,
@@ -114,7 +142,7 @@ func (s *BrowserScraper) Fetch(url string) models.Result {
 
 	// Save screenshot if enabled
 	var screenshotPath string
-	if s.Config.Browser.Screenshot && len(screenshot) > 0 {
+	if s.Config.Browser.Screenshot && len(page.Screenshot) > 0 {
 		// Create screenshot directory if it doesn't exist
 		if err := os.MkdirAll(s.Config.Browser.ScreenshotDir, 0755); err == nil {
 			// Generate a filename based on the URL
@@ -122,15 +150,15 @@ func (s *BrowserScraper) Fetch(url string) models.Result {
 			screenshotPath = filepath.Join(s.Config.Browser.ScreenshotDir, filename)
 
 			// Save the screenshot
-			if err := os.WriteFile(screenshotPath, screenshot, 0644); err != nil {
-				fmt.Printf("Error saving screenshot: %v\n", err)
+			if err := os.WriteFile(screenshotPath, page.Screenshot, 0644); err != nil {
+				slog.Warn("Error saving screenshot", "url", url, "path", screenshotPath, "error", err)
 			}
 		}
 	}
 
 	return models.Result{
 		URL:        url,
-		Content:    html,
+		Content:    page.HTML,
 		Extracted:  extracted,
 		Err:        "",
 		Duration:   time.Since(start),
@@ -138,5 +166,159 @@ func (s *BrowserScraper) Fetch(url string) models.Result {
 		Timestamp:  time.Now(),
 		Screenshot: screenshotPath,
 		JSRendered: true,
+		Encoding:   strings.ToLower(page.Encoding),
 	}
 }
+
+// renderedPage holds what the browser captured from a page
+type renderedPage struct {
+	HTML       string
+	Screenshot []byte
+	Location   string
+	Encoding   string
+}
+
+// render loads the page in the browser and captures its HTML, final location,
+// character encoding and optionally a screenshot
+func (s *BrowserScraper) render(ctx, browserCtx context.Context, url string, headers network.Headers, page *renderedPage) error {
+	// Create a channel to capture errors
+	errChan := make(chan error, 1)
+
+	// Run the browser tasks
+	go func() {
+		var tasks []chromedp.Action
+
+		// Import the shared session cookies before navigating
+		if s.Cookies != nil {
+			tasks = append(tasks, importCookies(s.Cookies))
+		}
+
+		// Send the headers added by hooks
+		if len(headers) > 0 {
+			tasks = append(tasks, network.Enable(), network.SetExtraHTTPHeaders(headers))
+		}
+
+		tasks = append(tasks, chromedp.Navigate(url))
+
+		// Wait for the configured element before the fixed wait
+		if s.Config.Browser.WaitFor != "" {
+			tasks = append(tasks, chromedp.WaitVisible(s.Config.Browser.WaitFor, chromedp.ByQuery))
+		}
+
+		tasks = append(tasks,
+			chromedp.Sleep(s.Config.Browser.WaitTime),
+			chromedp.Location(&page.Location),
+			chromedp.Evaluate("document.characterSet", &page.Encoding),
+			chromedp.OuterHTML("html", &page.HTML),
+		)
+
+		// Export any cookies the page set back into the shared jar
+		if s.Cookies != nil {
+			tasks = append(tasks, exportCookies(s.Cookies))
+		}
+
+		// Add screenshot task if enabled
+		if s.Config.Browser.Screenshot {
+			tasks = append(tasks, chromedp.CaptureScreenshot(&page.Screenshot))
+		}
+
+		// Run the tasks
+		errChan <- chromedp.Run(browserCtx, tasks...)
+	}()
+
+	// Wait for completion or timeout
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return errors.New("browser timeout")
+	}
+}
+
+// requestHeaders runs the BeforeRequest hooks on a stand-in request and
+// returns the headers they set
+func (s *BrowserScraper) requestHeaders(url string) (network.Headers, error) {
+	if s.Hooks == nil || len(s.Hooks.BeforeRequest) == 0 {
+		return nil, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Hooks.beforeRequest(req); err != nil {
+		return nil, err
+	}
+
+	headers := make(network.Headers, len(req.Header))
+	for name := range req.Header {
+		headers[name] = req.Header.Get(name)
+	}
+	return headers, nil
+}
+
+// allocatorOptions returns the Chrome options shared by every browser session
+func allocatorOptions(config *config.AppConfig) []chromedp.ExecAllocatorOption {
+	return append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("headless", config.Browser.Headless),
+		chromedp.UserAgent(config.Browser.UserAgent),
+	)
+}
+
+// importCookies copies the cookies from the jar into the browser
+func importCookies(jar *cookies.Jar) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		jarCookies := jar.All()
+		if len(jarCookies) == 0 {
+			return nil
+		}
+
+		params := make([]*network.CookieParam, 0, len(jarCookies))
+		for _, c := range jarCookies {
+			param := &network.CookieParam{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				Secure:   c.Secure,
+				HTTPOnly: c.HTTPOnly,
+			}
+			if !c.Expires.IsZero() {
+				expires := cdp.TimeSinceEpoch(c.Expires)
+				param.Expires = &expires
+			}
+			params = append(params, param)
+		}
+
+		return network.SetCookies(params).Do(ctx)
+	})
+}
+
+// exportCookies copies the browser's cookies into the jar
+func exportCookies(jar *cookies.Jar) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		browserCookies, err := network.GetCookies().Do(ctx)
+		if err != nil {
+			return err
+		}
+
+		jarCookies := make([]cookies.Cookie, 0, len(browserCookies))
+		for _, c := range browserCookies {
+			cookie := cookies.Cookie{
+				Name:     c.Name,
+				Value:    c.Value,
+				Domain:   c.Domain,
+				Path:     c.Path,
+				Secure:   c.Secure,
+				HTTPOnly: c.HTTPOnly,
+			}
+			if !c.Session && c.Expires > 0 {
+				cookie.Expires = time.Unix(int64(c.Expires), 0)
+			}
+			jarCookies = append(jarCookies, cookie)
+		}
+
+		jar.Add(jarCookies)
+		return nil
+	})
+}
@@ -0,0 +1,84 @@
+package scraper
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// process fills in a result from a response body according to its content type:
+// HTML is run through the extractor, feeds are parsed into items, PDFs have
+// their text and metadata extracted and anything else is saved to disk
+func (s *HTTPScraper) process(result *models.Result, contentType string, body []byte) error {
+	mediaType := content.MediaType(contentType, body)
+	result.ContentType = mediaType
+	result.ContentHash = content.Hash(body)
+
+	switch content.Detect(mediaType, body) {
+	case content.HTML:
+		// Transcode the page to UTF-8
+		decoded, encoding, err := decodeBody(body, contentType)
+		if err != nil {
+			return fmt.Errorf("decoding %s body: %w", encoding, err)
+		}
+
+		// Create a goquery document for HTML parsing
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(decoded))
+		if err != nil {
+			return err
+		}
+
+		// Get the HTML content
+		html, err := doc.Html()
+		if err != nil {
+			return err
+		}
+
+		// Extract data using CSS selectors, XPath, and regex
+		result.Extracted = s.Extractor.Extract(doc)
+		result.Content = html
+		result.Encoding = encoding
+
+	case content.Feed:
+		feed, err := content.ParseFeed(body)
+		if err != nil {
+			return err
+		}
+
+		result.Items = feed.Items
+		if feed.Title != "" {
+			result.Metadata = map[string]string{"title": feed.Title}
+		}
+
+	case content.PDF:
+		doc, err := content.ParsePDF(body)
+		if err != nil {
+			return err
+		}
+
+		result.Content = doc.Text
+		result.Metadata = doc.Metadata
+
+	case content.Text:
+		decoded, encoding, err := decodeBody(body, contentType)
+		if err != nil {
+			return fmt.Errorf("decoding %s body: %w", encoding, err)
+		}
+
+		result.Content = string(decoded)
+		result.Encoding = encoding
+
+	default:
+		path, err := content.Save(s.Config.Content.DownloadDir, body, mediaType, result.URL)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errSave, err)
+		}
+
+		result.Download = path
+	}
+
+	return nil
+}
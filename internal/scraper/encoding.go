@@ -0,0 +1,29 @@
+package scraper
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// utf8BOM is the byte order mark some servers prepend to UTF-8 documents
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// decodeBody detects the character encoding of a response body from its byte
+// order mark, the Content-Type header and any <meta charset> tag, and
+// transcodes it to UTF-8. It returns the UTF-8 body and the detected encoding.
+func decodeBody(body []byte, contentType string) ([]byte, string, error) {
+	encoding, name, _ := charset.DetermineEncoding(body, contentType)
+	name = strings.ToLower(name)
+
+	if name == "utf-8" {
+		return bytes.TrimPrefix(body, utf8BOM), name, nil
+	}
+
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		return nil, name, err
+	}
+	return decoded, name, nil
+}
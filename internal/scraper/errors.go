@@ -0,0 +1,148 @@
+package scraper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Error classes recorded on failed results
+const (
+	ClassDNS         = "dns"
+	ClassTimeout     = "timeout"
+	ClassTLS         = "tls"
+	ClassConnection  = "connection"
+	ClassClientError = "4xx"
+	ClassServerError = "5xx"
+	ClassStatus      = "status"
+	ClassAuth        = "auth"
+	ClassContentType = "content_type"
+	ClassTooLarge    = "too_large"
+	ClassParse       = "parse"
+	ClassIO          = "io"
+	ClassProxy       = "proxy"
+	ClassFiltered    = "filtered"
+	ClassHook        = "hook"
+	ClassAbandoned   = "abandoned" // Shared queue job whose workers kept dying
+	ClassOther       = "other"
+)
+
+var (
+	// errLoginFailed is returned when the configured login sequence fails
+	errLoginFailed = errors.New("login failed")
+
+	// errSessionExpired is returned when a session keeps expiring
+	errSessionExpired = errors.New("session expired")
+
+	// errContentTypeNotAllowed is returned for responses of a disallowed type
+	errContentTypeNotAllowed = errors.New("content type not allowed")
+
+	// errProxy is returned when the proxy cannot be applied
+	errProxy = errors.New("error applying proxy")
+
+	// errFiltered is returned for URLs dropped by a URL filter
+	errFiltered = errors.New("URL filtered")
+
+	// errHook is returned when a hook rejects a request or response
+	errHook = errors.New("hook failed")
+
+	// errSave is returned when a downloaded body cannot be written to disk
+	errSave = errors.New("saving download failed")
+)
+
+// StatusError is returned for responses with an unexpected status code
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("received non-200 status code: %d", e.Code)
+}
+
+// parseError wraps a failure to process a response body
+type parseError struct {
+	err error
+}
+
+func (e *parseError) Error() string { return e.err.Error() }
+func (e *parseError) Unwrap() error { return e.err }
+
+// classifyError maps a fetch error onto one of the error classes
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	var statusErr *StatusError
+	var parseErr *parseError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.Code >= 500:
+			return ClassServerError
+		case statusErr.Code >= 400:
+			return ClassClientError
+		default:
+			return ClassStatus
+		}
+	case errors.Is(err, errLoginFailed), errors.Is(err, errSessionExpired):
+		return ClassAuth
+	case errors.Is(err, errContentTypeNotAllowed):
+		return ClassContentType
+	case errors.Is(err, errBodyTooLarge):
+		return ClassTooLarge
+	case errors.Is(err, errProxy):
+		return ClassProxy
+	case errors.Is(err, errFiltered):
+		return ClassFiltered
+	case errors.Is(err, errHook):
+		return ClassHook
+	case errors.Is(err, errSave):
+		return ClassIO
+	case errors.As(err, &parseErr):
+		return ClassParse
+	case errors.As(err, &dnsErr):
+		return ClassDNS
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return ClassTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	case errors.As(err, &opErr):
+		return ClassConnection
+	}
+
+	return classifyMessage(err.Error())
+}
+
+// classifyMessage classifies errors that only carry a message, such as the
+// network errors reported by Chrome
+func classifyMessage(msg string) string {
+	switch {
+	case strings.Contains(msg, "ERR_NAME_NOT_RESOLVED"), strings.Contains(msg, "no such host"):
+		return ClassDNS
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "ERR_TIMED_OUT"):
+		return ClassTimeout
+	case strings.Contains(msg, "ERR_CERT_"), strings.Contains(msg, "ERR_SSL_"), strings.Contains(msg, "tls:"):
+		return ClassTLS
+	case strings.Contains(msg, "ERR_CONNECTION_"), strings.Contains(msg, "ERR_ADDRESS_UNREACHABLE"):
+		return ClassConnection
+	case strings.Contains(msg, "ERR_PROXY_"), strings.Contains(msg, "ERR_TUNNEL_"):
+		return ClassProxy
+	default:
+		return ClassOther
+	}
+}
@@ -1,32 +1,47 @@
 package scraper
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
 	"github.com/williampepple1/concurrent-web-scraper/internal/extraction"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
 	"github.com/williampepple1/concurrent-web-scraper/internal/proxy"
+	"github.com/williampepple1/concurrent-web-scraper/internal/warc"
 	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
 )
 
 // HTTPScraper implements HTTP-based scraping
 type HTTPScraper struct {
 	Config    *config.AppConfig
-	Extractor *extraction.Extractor
+	Extractor Extractor
 	Proxy     *proxy.Manager
+	Cookies   *cookies.Jar
+	Auth      *Authenticator
+	Hooks     *Hooks
+	Archive   *warc.Writer
 }
 
 // NewHTTPScraper creates a new HTTP scraper
-func NewHTTPScraper(config *config.AppConfig) *HTTPScraper {
-	return &HTTPScraper{
+func NewHTTPScraper(config *config.AppConfig, jar *cookies.Jar) *HTTPScraper {
+	s := &HTTPScraper{
 		Config:    config,
 		Extractor: extraction.NewExtractor(&config.Extraction),
 		Proxy:     proxy.NewManager(&config.Proxies),
+		Cookies:   jar,
 	}
+	if config.Auth.Enabled {
+		s.Auth = NewAuthenticator(config, jar)
+	}
+	return s
 }
 
 // Fetch fetches the content of a URL and returns a Result
@@ -36,9 +51,17 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 	var lastErr error
 	var statusCode int
 	var proxyUsed string
+	var relogged bool
+
+	// Link checks only need the status and headers
+	method := http.MethodGet
+	if s.Config.Scraper.HeadOnly {
+		method = http.MethodHead
+	}
 
-	// Create a transport with proxy support
-	transport := &http.Transport{}
+	// Create a transport with proxy support. Decompression is handled by
+	// readBody so that decompressed sizes can be limited.
+	transport := &http.Transport{DisableCompression: true}
 
 	// Add proxy if enabled
 	if s.Config.Proxies.Enabled && len(s.Config.Proxies.List) > 0 {
@@ -46,10 +69,11 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 		proxyUsed, err = s.Proxy.ApplyToTransport(transport)
 		if err != nil {
 			return models.Result{
-				URL:       url,
-				Err:       fmt.Sprintf("Error applying proxy: %v", err),
-				Duration:  time.Since(start),
-				Timestamp: time.Now(),
+				URL:        url,
+				Err:        fmt.Sprintf("%v: %v", errProxy, err),
+				ErrorClass: ClassProxy,
+				Duration:   time.Since(start),
+				Timestamp:  time.Now(),
 			}
 		}
 	}
@@ -60,11 +84,34 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 		Timeout:   s.Config.Scraper.Timeout,
 	}
 
+	// Share the session cookies with every other request
+	if s.Cookies != nil {
+		client.Jar = s.Cookies
+	}
+
+	// Archive every exchange of this fetch, including retries. Logins go
+	// through a client that doesn't archive, keeping credentials out of the
+	// archive.
+	loginClient := client
+	if s.Archive != nil {
+		archived := *client
+		archived.Transport = s.Archive.Transport(transport)
+		client = &archived
+	}
+
 	for retries <= s.Config.Scraper.MaxRetries {
 		if retries > 0 {
 			// Wait before retrying
 			retryWait := s.Config.Scraper.RetryDelay * time.Duration(retries)
-			fmt.Printf("Retrying %s after %v (attempt %d/%d)\n", url, retryWait, retries, s.Config.Scraper.MaxRetries)
+			slog.Debug("Retrying",
+				"url", url,
+				"attempt", retries,
+				"max_retries", s.Config.Scraper.MaxRetries,
+				"delay", retryWait,
+				"proxy", proxy.Redacted(proxyUsed),
+				"error", lastErr,
+			)
+			metrics.Retry(metrics.Host(url))
 			time.Sleep(retryWait)
 
 			// Rotate proxy if enabled
@@ -74,7 +121,7 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 		}
 
 		// Create a new request
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequest(method, url, nil)
 		if err != nil {
 			lastErr = err
 			retries++
@@ -86,58 +133,143 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 			userAgent := s.Config.Scraper.UserAgents[rand.Intn(len(s.Config.Scraper.UserAgents))]
 			req.Header.Set("User-Agent", userAgent)
 		}
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		// Let hooks adjust or reject the request
+		if err := s.Hooks.beforeRequest(req); err != nil {
+			lastErr = err
+			break
+		}
+
+		// Log in first if the page is protected
+		authenticated := s.Auth != nil && s.Auth.Applies(req.URL)
+		var generation int
+		if authenticated {
+			generation, err = s.Auth.Ensure(req.URL, loginClient)
+			if err != nil {
+				lastErr = err
+				retries++
+				continue
+			}
+		}
 
 		// Make the request
 		resp, err := client.Do(req)
 		if err != nil {
+			metrics.Requests.WithLabelValues(req.URL.Hostname(), "error").Inc()
+			if proxyUsed != "" {
+				metrics.ProxyFailures.WithLabelValues(metrics.Host(proxyUsed)).Inc()
+			}
 			lastErr = err
 			retries++
 			continue
 		}
+		metrics.Requests.WithLabelValues(req.URL.Hostname(), strconv.Itoa(resp.StatusCode)).Inc()
 
 		// Ensure the response body is closed
 		defer resp.Body.Close()
 		statusCode = resp.StatusCode
 
-		// Check for non-200 status codes
-		if resp.StatusCode != http.StatusOK {
-			lastErr = fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		// Let hooks inspect or reject the response
+		if err := s.Hooks.afterResponse(resp); err != nil {
+			lastErr = err
 			retries++
 			continue
 		}
 
-		// Create a goquery document for HTML parsing
-		doc, err := goquery.NewDocumentFromReader(resp.Body)
-		if err != nil {
-			lastErr = err
+		// Log in again if the session expired; the first re-login does not count as a retry
+		if authenticated && s.Auth.Expired(req.URL, resp) {
+			s.Auth.Invalidate(req.URL, generation)
+			lastErr = fmt.Errorf("%w (status %d, landed on %s)", errSessionExpired, resp.StatusCode, resp.Request.URL)
+			if !relogged {
+				relogged = true
+			} else {
+				retries++
+			}
+			continue
+		}
+
+		// Some servers reject HEAD requests; fall back to a GET whose body is never read
+		if method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			method = http.MethodGet
+			continue
+		}
+
+		// Check for non-200 status codes
+		if resp.StatusCode != http.StatusOK {
+			lastErr = &StatusError{Code: resp.StatusCode}
 			retries++
 			continue
 		}
 
-		// Extract data using CSS selectors, XPath, and regex
-		extracted := s.Extractor.Extract(doc)
+		// In head-only mode record the status and headers without reading the body
+		contentType := resp.Header.Get("Content-Type")
+		if s.Config.Scraper.HeadOnly {
+			result := models.Result{
+				URL:        url,
+				Duration:   time.Since(start),
+				Retries:    retries,
+				StatusCode: statusCode,
+				Timestamp:  time.Now(),
+				ProxyUsed:  proxyUsed,
+				Headers:    flattenHeaders(resp.Header),
+			}
+			if contentType != "" {
+				result.ContentType = content.MediaType(contentType, nil)
+			}
+			if resp.ContentLength > 0 {
+				result.Size = resp.ContentLength
+			}
+			return result
+		}
+
+		// Reject content types that are not allowed before reading the body
+		if contentType != "" && !content.Allowed(content.MediaType(contentType, nil), s.Config.Content.AllowedTypes) {
+			lastErr = fmt.Errorf("%w: %s", errContentTypeNotAllowed, content.MediaType(contentType, nil))
+			break
+		}
 
-		// Get the HTML content
-		html, err := doc.Html()
+		// Stream the body, enforcing the configured size limits
+		body, size, err := readBody(resp, s.Config.Content)
 		if err != nil {
 			lastErr = err
+			if errors.Is(err, errBodyTooLarge) {
+				break
+			}
 			retries++
 			continue
 		}
 
-		// Success! Return the result
-		return models.Result{
+		// Without a Content-Type header the type can only be checked once it is sniffed
+		if contentType == "" && !content.Allowed(content.MediaType("", body), s.Config.Content.AllowedTypes) {
+			lastErr = fmt.Errorf("%w: %s", errContentTypeNotAllowed, content.MediaType("", body))
+			break
+		}
+
+		// Parse the body according to its content type
+		result := models.Result{
 			URL:        url,
-			Content:    html,
-			Extracted:  extracted,
-			Err:        "",
-			Duration:   time.Since(start),
 			Retries:    retries,
 			StatusCode: statusCode,
-			Timestamp:  time.Now(),
 			ProxyUsed:  proxyUsed,
 			JSRendered: false,
+			Size:       size,
 		}
+		if err := s.process(&result, contentType, body); err != nil {
+			// Fetching the body again won't help if it can't be written to disk
+			if errors.Is(err, errSave) {
+				lastErr = err
+				break
+			}
+			lastErr = &parseError{err}
+			retries++
+			continue
+		}
+
+		// Success! Return the result
+		result.Duration = time.Since(start)
+		result.Timestamp = time.Now()
+		return result
 	}
 
 	// If we get here, all retries failed
@@ -146,6 +278,7 @@ func (s *HTTPScraper) Fetch(url string) models.Result {
 		Content:    "",
 		Extracted:  nil,
 		Err:        lastErr.Error(),
+		ErrorClass: classifyError(lastErr),
 		Duration:   time.Since(start),
 		Retries:    retries,
 		StatusCode: statusCode,
@@ -0,0 +1,137 @@
+package scraper
+
+import (
+	"fmt"
+	"log/slog"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Fetch paths recorded on results
+const (
+	PathHTTP    = "http"
+	PathBrowser = "browser"
+)
+
+// Escalation reasons
+const (
+	reasonHost          = "host"
+	reasonRequiredField = "required_field"
+	reasonSmallBody     = "small_body"
+	reasonMarker        = "marker"
+)
+
+// HybridScraper fetches pages over plain HTTP and escalates to the browser
+// when the page looks like it needs JavaScript
+type HybridScraper struct {
+	Config  *config.AppConfig
+	HTTP    *HTTPScraper
+	Browser *BrowserScraper
+}
+
+// NewHybridScraper creates a new hybrid scraper sharing the cookie jar between both paths
+func NewHybridScraper(config *config.AppConfig, jar *cookies.Jar) *HybridScraper {
+	return &HybridScraper{
+		Config:  config,
+		HTTP:    NewHTTPScraper(config, jar),
+		Browser: NewBrowserScraper(config, jar),
+	}
+}
+
+// Fetch fetches the URL over HTTP, then renders it in the browser if any of
+// the configured signals fire
+func (s *HybridScraper) Fetch(url string) models.Result {
+	// Some sites always need the browser
+	if s.browserHost(url) {
+		return s.escalate(url, nil, reasonHost, "host is on the browser list")
+	}
+
+	result := s.HTTP.Fetch(url)
+	result.FetchPath = PathHTTP
+	if result.Err != "" || s.Config.Scraper.HeadOnly || content.Detect(result.ContentType, nil) != content.HTML {
+		return result
+	}
+
+	if reason, detail := s.signal(&result); reason != "" {
+		return s.escalate(url, &result, reason, detail)
+	}
+	return result
+}
+
+// signal returns the first escalation signal that fires for an HTTP result
+func (s *HybridScraper) signal(result *models.Result) (string, string) {
+	hybrid := &s.Config.Hybrid
+
+	for _, field := range hybrid.RequiredFields {
+		if models.IsEmpty(result.Extracted[field]) {
+			return reasonRequiredField, fmt.Sprintf("required field %q is empty", field)
+		}
+	}
+
+	if hybrid.MinBodySize > 0 && result.Size < hybrid.MinBodySize {
+		return reasonSmallBody, fmt.Sprintf("body is %d bytes, under %d", result.Size, hybrid.MinBodySize)
+	}
+
+	page := strings.ToLower(result.Content)
+	for _, marker := range hybrid.Markers {
+		if marker != "" && strings.Contains(page, strings.ToLower(marker)) {
+			return reasonMarker, fmt.Sprintf("page contains %q", marker)
+		}
+	}
+
+	return "", ""
+}
+
+// escalate renders the URL in the browser. If the browser fails, the HTTP
+// result is kept when there is one.
+func (s *HybridScraper) escalate(url string, httpResult *models.Result, reason, detail string) models.Result {
+	metrics.BrowserEscalations.WithLabelValues(metrics.Host(url), reason).Inc()
+	slog.Debug("Escalating to browser", "url", url, "reason", detail)
+
+	result := s.Browser.Fetch(url)
+	result.FetchPath = PathBrowser
+	result.Escalation = detail
+
+	if httpResult == nil {
+		return result
+	}
+
+	// Account for the time and retries spent on the HTTP attempt
+	result.Duration += httpResult.Duration
+	result.Retries += httpResult.Retries
+	if result.Err != "" {
+		slog.Warn("Browser fallback failed, keeping HTTP result", "url", url, "error", result.Err)
+		httpResult.Escalation = fmt.Sprintf("%s; browser failed: %s", detail, result.Err)
+		httpResult.Duration = result.Duration
+		return *httpResult
+	}
+	if result.StatusCode == 0 {
+		result.StatusCode = httpResult.StatusCode
+	}
+	return result
+}
+
+// browserHost reports whether the URL's host is always fetched with the browser
+func (s *HybridScraper) browserHost(rawURL string) bool {
+	if len(s.Config.Hybrid.Hosts) == 0 {
+		return false
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range s.Config.Hybrid.Hosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
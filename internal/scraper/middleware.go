@@ -0,0 +1,206 @@
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Middleware wraps a scraper to add behaviour around Fetch
+type Middleware func(next Scraper) Scraper
+
+// ScraperFunc adapts a function to the Scraper interface
+type ScraperFunc func(url string) models.Result
+
+// Fetch calls f(url)
+func (f ScraperFunc) Fetch(url string) models.Result {
+	return f(url)
+}
+
+// Chain wraps s with the middlewares; the first middleware is the outermost
+func Chain(s Scraper, middlewares ...Middleware) Scraper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		s = middlewares[i](s)
+	}
+	return s
+}
+
+// Hooks are callbacks run at fixed points of every fetch. BeforeRequest and
+// AfterResponse run on each HTTP attempt, including retries. An error from
+// BeforeRequest fails the fetch, while an error from AfterResponse fails the
+// attempt, which is then retried. The browser scraper runs BeforeRequest on a
+// stand-in request and sends its headers with the page load, but cannot run
+// AfterResponse. OnError and OnResult run once per URL on the final result.
+type Hooks struct {
+	BeforeRequest []func(req *http.Request) error
+	AfterResponse []func(resp *http.Response) error
+	OnError       []func(result models.Result)
+	OnResult      []func(result *models.Result)
+}
+
+// Add appends the other hooks to h
+func (h *Hooks) Add(other Hooks) {
+	h.BeforeRequest = append(h.BeforeRequest, other.BeforeRequest...)
+	h.AfterResponse = append(h.AfterResponse, other.AfterResponse...)
+	h.OnError = append(h.OnError, other.OnError...)
+	h.OnResult = append(h.OnResult, other.OnResult...)
+}
+
+// beforeRequest runs the BeforeRequest hooks
+func (h *Hooks) beforeRequest(req *http.Request) error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range h.BeforeRequest {
+		if err := hook(req); err != nil {
+			return fmt.Errorf("%w: before request: %v", errHook, err)
+		}
+	}
+	return nil
+}
+
+// afterResponse runs the AfterResponse hooks
+func (h *Hooks) afterResponse(resp *http.Response) error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range h.AfterResponse {
+		if err := hook(resp); err != nil {
+			return fmt.Errorf("%w: after response: %v", errHook, err)
+		}
+	}
+	return nil
+}
+
+// Middleware returns a middleware running the OnError and OnResult hooks
+func (h *Hooks) Middleware() Middleware {
+	return func(next Scraper) Scraper {
+		return ScraperFunc(func(url string) models.Result {
+			result := next.Fetch(url)
+			if result.Err != "" {
+				for _, hook := range h.OnError {
+					hook(result)
+				}
+			}
+			for _, hook := range h.OnResult {
+				hook(&result)
+			}
+			return result
+		})
+	}
+}
+
+// InjectHeaders returns a BeforeRequest hook that sets the headers on every
+// request. Values may reference environment variables as ${VAR}.
+func InjectHeaders(headers map[string]string) func(req *http.Request) error {
+	return func(req *http.Request) error {
+		for name, value := range headers {
+			req.Header.Set(name, os.ExpandEnv(value))
+		}
+		return nil
+	}
+}
+
+// FilterURLs returns a middleware that only fetches URLs matching one of the
+// allow patterns (all if empty) and none of the deny patterns. Dropped URLs
+// come back as failed results with the "filtered" error class.
+func FilterURLs(allow, deny []string) (Middleware, error) {
+	allowed, err := compilePatterns(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := compilePatterns(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next Scraper) Scraper {
+		return ScraperFunc(func(url string) models.Result {
+			if reason := filterReason(url, allowed, denied); reason != "" {
+				err := fmt.Errorf("%w: %s", errFiltered, reason)
+				return models.Result{
+					URL:        url,
+					Err:        err.Error(),
+					ErrorClass: classifyError(err),
+					Timestamp:  time.Now(),
+				}
+			}
+			return next.Fetch(url)
+		})
+	}, nil
+}
+
+// filterReason reports why a URL is dropped, or "" if it is allowed
+func filterReason(url string, allowed, denied []*regexp.Regexp) string {
+	for _, re := range denied {
+		if re.MatchString(url) {
+			return "matches deny pattern " + re.String()
+		}
+	}
+	if len(allowed) == 0 {
+		return ""
+	}
+	for _, re := range allowed {
+		if re.MatchString(url) {
+			return ""
+		}
+	}
+	return "matches no allow pattern"
+}
+
+// compilePatterns compiles a list of regular expressions
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Enrich returns an OnResult hook that adds the values, plus the URL's host,
+// to each result's metadata
+func Enrich(values map[string]string) func(result *models.Result) {
+	return func(result *models.Result) {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]string)
+		}
+		if u, err := neturl.Parse(result.URL); err == nil && u.Host != "" {
+			result.Metadata["host"] = u.Hostname()
+		}
+		for key, value := range values {
+			result.Metadata[key] = value
+		}
+	}
+}
+
+// configHooks returns the built-in middleware and hooks enabled in the configuration
+func configHooks(config *config.MiddlewareConfig) ([]Middleware, Hooks, error) {
+	var middlewares []Middleware
+	var hooks Hooks
+
+	if len(config.Allow) > 0 || len(config.Deny) > 0 {
+		filter, err := FilterURLs(config.Allow, config.Deny)
+		if err != nil {
+			return nil, hooks, err
+		}
+		middlewares = append(middlewares, filter)
+	}
+	if len(config.Headers) > 0 {
+		hooks.BeforeRequest = append(hooks.BeforeRequest, InjectHeaders(config.Headers))
+	}
+	if len(config.Metadata) > 0 {
+		hooks.OnResult = append(hooks.OnResult, Enrich(config.Metadata))
+	}
+
+	return middlewares, hooks, nil
+}
@@ -0,0 +1,131 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/internal/extraction"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// OfflineScraper re-runs parsing and extraction on stored pages without
+// touching the network
+type OfflineScraper struct {
+	Config    *config.AppConfig
+	Extractor Extractor
+
+	// sites are the extractors of the site profiles, by profile
+	sites map[*config.SiteConfig]Extractor
+}
+
+// NewOfflineScraper creates an offline scraper using the configured
+// extraction rules, or those of the site profile matching each URL
+func NewOfflineScraper(cfg *config.AppConfig) (*OfflineScraper, error) {
+	s := &OfflineScraper{
+		Config:    cfg,
+		Extractor: extraction.NewExtractor(&cfg.Extraction),
+		sites:     make(map[*config.SiteConfig]Extractor, len(cfg.Sites)),
+	}
+	for i := range cfg.Sites {
+		siteConfig, err := cfg.ForSite(&cfg.Sites[i])
+		if err != nil {
+			return nil, err
+		}
+		s.sites[&cfg.Sites[i]] = extraction.NewExtractor(&siteConfig.Extraction)
+	}
+	return s, nil
+}
+
+// extractor returns the extractor for the URL, the same way a live run
+// resolves the site profiles
+func (s *OfflineScraper) extractor(url string) Extractor {
+	if profile := s.Config.SiteFor(url); profile != nil {
+		return s.sites[profile]
+	}
+	return s.Extractor
+}
+
+// FromResult re-extracts a saved result from its HTML content. Failed
+// results and non-HTML pages are returned unchanged; everything but the
+// extracted fields is kept as it was fetched.
+func (s *OfflineScraper) FromResult(stored models.Result) models.Result {
+	if stored.Err != "" {
+		return stored
+	}
+
+	mediaType := stored.ContentType
+	if mediaType == "" {
+		mediaType = content.MediaType("", []byte(stored.Content))
+	}
+	if content.Detect(mediaType, []byte(stored.Content)) != content.HTML {
+		return stored
+	}
+	if stored.Content == "" {
+		stored.Err = "result has no saved content to re-extract from"
+		stored.ErrorClass = ClassParse
+		return stored
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(stored.Content))
+	if err != nil {
+		stored.Err = err.Error()
+		stored.ErrorClass = ClassParse
+		return stored
+	}
+	stored.Extracted = s.extractor(stored.URL).Extract(doc)
+	return stored
+}
+
+// FromResponse processes an archived response for url the way a fetch
+// would have, from the status check to extraction
+func (s *OfflineScraper) FromResponse(url string, resp *http.Response, fetched time.Time) models.Result {
+	start := time.Now()
+	result := models.Result{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Timestamp:  fetched,
+	}
+
+	fail := func(err error) models.Result {
+		result.Err = err.Error()
+		result.ErrorClass = classifyError(err)
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fail(&StatusError{Code: resp.StatusCode})
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !content.Allowed(content.MediaType(contentType, nil), s.Config.Content.AllowedTypes) {
+		return fail(fmt.Errorf("%w: %s", errContentTypeNotAllowed, content.MediaType(contentType, nil)))
+	}
+
+	body, size, err := readBody(resp, s.Config.Content)
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			return fail(err)
+		}
+		return fail(&parseError{err})
+	}
+	result.Size = size
+
+	if contentType == "" && !content.Allowed(content.MediaType("", body), s.Config.Content.AllowedTypes) {
+		return fail(fmt.Errorf("%w: %s", errContentTypeNotAllowed, content.MediaType("", body)))
+	}
+
+	// Parse the body with the HTTP scraper's processing and this scraper's extractor
+	processor := &HTTPScraper{Config: s.Config, Extractor: s.extractor(url)}
+	if err := processor.process(&result, contentType, body); err != nil {
+		return fail(&parseError{err})
+	}
+	result.Duration = time.Since(start)
+	return result
+}
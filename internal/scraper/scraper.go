@@ -1,7 +1,12 @@
 package scraper
 
 import (
+	"fmt"
+
+	"github.com/PuerkitoBio/goquery"
 	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
+	"github.com/williampepple1/concurrent-web-scraper/internal/warc"
 	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
 )
 
@@ -10,10 +15,66 @@ type Scraper interface {
 	Fetch(url string) models.Result
 }
 
-// New creates a new scraper based on the configuration
-func New(config *config.AppConfig) Scraper {
-	if config.Browser.Enabled {
-		return NewBrowserScraper(config)
+// Extractor defines the interface for extracting data from an HTML page
+type Extractor interface {
+	Extract(doc *goquery.Document) map[string]interface{}
+}
+
+// Options customize the scrapers created by NewWithOptions
+type Options struct {
+	Extractor  Extractor    // Replaces the configured selectors and patterns
+	Hooks      Hooks        // Run in addition to the hooks from the configuration
+	Middleware []Middleware // Wrap the scraper inside the configured URL filter
+	Archive    *warc.Writer // Records the raw HTTP exchanges
+}
+
+// New creates a new scraper based on the configuration: a browser scraper when
+// the browser is enabled, a hybrid scraper that falls back from HTTP to the
+// browser when hybrid mode is enabled, and an HTTP scraper otherwise.
+// The cookie jar may be nil when cookies are disabled. It fails if the
+// middleware configuration is invalid.
+func New(config *config.AppConfig, jar *cookies.Jar) (Scraper, error) {
+	return NewWithOptions(config, jar, Options{})
+}
+
+// NewWithOptions creates a new scraper like New, customized with extra hooks,
+// middleware or a custom extractor
+func NewWithOptions(config *config.AppConfig, jar *cookies.Jar, opts Options) (Scraper, error) {
+	middlewares, hooks, err := configHooks(&config.Middleware)
+	if err != nil {
+		return nil, fmt.Errorf("invalid middleware configuration: %w", err)
 	}
-	return NewHTTPScraper(config)
+	hooks.Add(opts.Hooks)
+
+	var s Scraper
+	if config.Hybrid.Enabled && !config.Browser.Enabled {
+		hybrid := NewHybridScraper(config, jar)
+		hybrid.HTTP.Hooks = &hooks
+		hybrid.HTTP.Archive = opts.Archive
+		hybrid.Browser.Hooks = &hooks
+		if opts.Extractor != nil {
+			hybrid.HTTP.Extractor = opts.Extractor
+			hybrid.Browser.Extractor = opts.Extractor
+		}
+		s = hybrid
+	} else if config.Browser.Enabled {
+		browser := NewBrowserScraper(config, jar)
+		browser.Hooks = &hooks
+		if opts.Extractor != nil {
+			browser.Extractor = opts.Extractor
+		}
+		s = browser
+	} else {
+		fetcher := NewHTTPScraper(config, jar)
+		fetcher.Hooks = &hooks
+		fetcher.Archive = opts.Archive
+		if opts.Extractor != nil {
+			fetcher.Extractor = opts.Extractor
+		}
+		s = fetcher
+	}
+
+	// Result hooks see every result, including the ones dropped by the URL filter
+	middlewares = append(middlewares, opts.Middleware...)
+	return Chain(s, append([]Middleware{hooks.Middleware()}, middlewares...)...), nil
 }
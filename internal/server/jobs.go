@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Job states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusFailed    = "failed"
+)
+
+// Job is a single scrape submitted to the server
+type Job struct {
+	ID        string
+	URLs      []string
+	Config    *config.AppConfig
+	CreatedAt time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	status     string
+	err        string
+	startedAt  time.Time
+	finishedAt time.Time
+	results    []models.Result
+	succeeded  int
+	failed     int
+	report     *report.Report
+	changed    chan struct{}
+}
+
+// JobStatus is the JSON view of a job
+type JobStatus struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	Error      string         `json:"error,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Progress   Progress       `json:"progress"`
+	Report     *report.Report `json:"report,omitempty"`
+}
+
+// Progress reports how far a job has got
+type Progress struct {
+	Total     int     `json:"total"`
+	Completed int     `json:"completed"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Percent   float64 `json:"percent"`
+}
+
+// newJob creates a queued job
+func newJob(id string, urls []string, cfg *config.AppConfig) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Job{
+		ID:        id,
+		URLs:      urls,
+		Config:    cfg,
+		CreatedAt: time.Now(),
+		ctx:       ctx,
+		cancel:    cancel,
+		status:    StatusQueued,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Status returns a snapshot of the job's state
+func (j *Job) Status(withReport bool) JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:        j.ID,
+		Status:    j.status,
+		Error:     j.err,
+		CreatedAt: j.CreatedAt,
+		Progress: Progress{
+			Total:     len(j.URLs),
+			Completed: len(j.results),
+			Succeeded: j.succeeded,
+			Failed:    j.failed,
+		},
+	}
+	if len(j.URLs) > 0 {
+		status.Progress.Percent = float64(len(j.results)) / float64(len(j.URLs)) * 100
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+	if withReport {
+		status.Report = j.report
+	}
+	return status
+}
+
+// Cancel stops the job from fetching any more URLs
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Results returns the results from index onwards, whether the job has
+// finished, and a channel that is closed when more results arrive
+func (j *Job) Results(from int) ([]models.Result, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var results []models.Result
+	if from < len(j.results) {
+		results = append(results, j.results[from:]...)
+	}
+	return results, j.finished(), j.changed
+}
+
+// start marks the job as running
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.status = StatusRunning
+	j.startedAt = time.Now()
+	j.notify()
+}
+
+// add records a result
+func (j *Job) add(result models.Result) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.results = append(j.results, result)
+	if result.Err != "" {
+		j.failed++
+	} else {
+		j.succeeded++
+	}
+	j.notify()
+}
+
+// finish marks the job as done
+func (j *Job) finish(r *report.Report, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case err != nil:
+		j.status = StatusFailed
+		j.err = err.Error()
+	case j.ctx.Err() != nil:
+		j.status = StatusCancelled
+	default:
+		j.status = StatusCompleted
+	}
+	j.finishedAt = time.Now()
+	j.report = r
+	j.notify()
+}
+
+// doneAt returns when the job finished, if it has
+func (j *Job) doneAt() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.finishedAt, j.finished()
+}
+
+// finished reports whether the job is done; callers must hold the lock
+func (j *Job) finished() bool {
+	return j.status == StatusCompleted || j.status == StatusCancelled || j.status == StatusFailed
+}
+
+// notify wakes up anyone waiting for changes; callers must hold the lock
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
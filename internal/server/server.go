@@ -0,0 +1,390 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/canonical"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/internal/webhook"
+	"github.com/williampepple1/concurrent-web-scraper/internal/worker"
+)
+
+// maxRequestSize limits the size of a job submission
+const maxRequestSize = 10 << 20
+
+// JobOverrides are the configuration paths a job submission may override.
+// Anything that names a file, sends headers or credentials, or reads
+// ${ENV_VARS} stays with the server configuration, since the API has no
+// authentication.
+var JobOverrides = []string{
+	"scraper.workers",
+	"scraper.rate_limit",
+	"scraper.max_retries",
+	"scraper.retry_delay",
+	"scraper.timeout",
+	"scraper.head_only",
+	"extraction",
+	"canonical",
+	"near_duplicates",
+}
+
+// Server exposes scrape jobs over a REST API. Every job gets its own worker
+// pool, scraper and cookie jar, while all jobs share one concurrency budget.
+type Server struct {
+	Config *config.AppConfig
+
+	budget chan struct{}
+
+	// stopping is cancelled when the server shuts down, so webhook
+	// deliveries don't hold up the shutdown
+	stopping context.Context
+	stop     context.CancelFunc
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	wg   sync.WaitGroup
+}
+
+// SubmitRequest is the body of a job submission
+type SubmitRequest struct {
+	URLs   []string        `json:"urls"`
+	Config json.RawMessage `json:"config,omitempty"` // Overrides applied on top of the server configuration
+}
+
+// NewServer creates a new API server using config as the base for every job
+func NewServer(config *config.AppConfig) *Server {
+	concurrency := config.Server.MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = config.Scraper.Workers
+	}
+
+	stopping, stop := context.WithCancel(context.Background())
+	return &Server{
+		Config:   config,
+		budget:   make(chan struct{}, concurrency),
+		stopping: stopping,
+		stop:     stop,
+		jobs:     make(map[string]*Job),
+	}
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("GET /jobs/{id}/results", s.handleResults)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancel)
+	return mux
+}
+
+// ListenAndServe serves the API until ctx is cancelled, then cancels all jobs
+// and waits for them to stop
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.Config.Server.Address,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+	slog.Info("API server listening", "address", s.Config.Server.Address)
+
+	// Delete finished jobs as they expire
+	ticker := time.NewTicker(min(s.Config.Server.JobRetention, time.Minute))
+	defer ticker.Stop()
+
+	for done := false; !done; {
+		select {
+		case err := <-errChan:
+			return err
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.evict(now)
+			s.mu.Unlock()
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+
+	s.stop()
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		job.Cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	return err
+}
+
+// Submit creates a job for the URLs and starts it in the background
+func (s *Server) Submit(urls []string, override []byte) (*Job, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no URLs to scrape")
+	}
+
+	jobConfig, err := s.Config.MergeOnly(override, JobOverrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := jobConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	urls = canonical.NewCanonicalizer(&jobConfig.Canonical).Dedupe(urls)
+	job := newJob(id, urls, jobConfig)
+
+	s.mu.Lock()
+	s.evict(time.Now())
+	s.jobs[id] = job
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.run(job)
+
+	return job, nil
+}
+
+// Job returns the job with the given ID
+func (s *Server) Job(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// evict deletes the finished jobs older than the retention, and the oldest
+// finished jobs beyond the maximum; callers must hold the lock
+func (s *Server) evict(now time.Time) {
+	type finishedJob struct {
+		id string
+		at time.Time
+	}
+
+	var finished []finishedJob
+	for id, job := range s.jobs {
+		if at, ok := job.doneAt(); ok {
+			finished = append(finished, finishedJob{id, at})
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].at.After(finished[j].at)
+	})
+
+	for i, f := range finished {
+		if i >= s.Config.Server.MaxJobs || now.Sub(f.at) > s.Config.Server.JobRetention {
+			delete(s.jobs, f.id)
+			slog.Debug("Job deleted", "job_id", f.id)
+		}
+	}
+}
+
+// run scrapes the job's URLs through its own worker pool
+func (s *Server) run(job *Job) {
+	defer s.wg.Done()
+
+	logger := slog.With("job_id", job.ID)
+	logger.Info("Job started", "urls", len(job.URLs))
+
+	pool, err := worker.NewPool(job.Config, job.URLs)
+	if err != nil {
+		logger.Error("Error creating worker pool", "error", err)
+		job.finish(nil, err)
+		return
+	}
+	pool.Context = job.ctx
+	pool.Budget = s.budget
+
+	if pool.Cookies != nil && job.Config.Cookies.ImportFile != "" {
+		if err := pool.Cookies.Load(job.Config.Cookies.ImportFile); err != nil {
+			logger.Error("Error loading cookies", "error", err)
+			job.finish(nil, fmt.Errorf("loading cookies: %w", err))
+			return
+		}
+	}
+
+	// Deliver results to the job's webhook as they come in
+	var deliverer *webhook.Deliverer
+	if job.Config.Webhook.Enabled && job.Config.Webhook.URL != "" {
+		deliverer = webhook.NewDeliverer(s.stopping, &job.Config.Webhook, job.ID)
+	}
+
+	builder := report.NewBuilder(job.Config)
+	job.start()
+	pool.Start()
+	pool.AddJobs(job.URLs)
+
+	for result := range pool.Results {
+		builder.Observe(result)
+		job.add(result)
+		if deliverer != nil {
+			deliverer.Send(result)
+		}
+	}
+
+	summary := builder.Finish()
+	job.finish(summary, nil)
+	status := job.Status(false)
+	if deliverer != nil {
+		deliverer.Complete(status.Status, summary)
+	}
+	logger.Info("Job finished",
+		"status", status.Status,
+		"succeeded", status.Progress.Succeeded,
+		"failed", status.Progress.Failed,
+	)
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req SubmitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	urls := make([]string, 0, len(req.URLs))
+	for _, u := range req.URLs {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	job, err := s.Submit(urls, req.Config)
+	if err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error":    "invalid configuration",
+				"problems": verr.Problems,
+			})
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusCreated, job.Status(false))
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		statuses = append(statuses, job.Status(false))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.Before(statuses[j].CreatedAt)
+	})
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Status(true))
+}
+
+// handleResults streams results as newline-delimited JSON. Unless follow=false
+// is given, the stream stays open until the job finishes.
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	follow := r.URL.Query().Get("follow") != "false"
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	next := 0
+	for {
+		results, finished, changed := job.Results(next)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return
+			}
+		}
+		next += len(results)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if finished || !follow {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	job.Cancel()
+	slog.Info("Job cancelled", "job_id", job.ID)
+	writeJSON(w, http.StatusAccepted, job.Status(false))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// newID generates a random job ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// newTestServer starts the API in front of a site whose pages take delay to load
+func newTestServer(t *testing.T, delay time.Duration) (*Server, *httptest.Server, *httptest.Server) {
+	t.Helper()
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head><body><h1>Page</h1></body></html>", r.URL.Path)
+	}))
+	t.Cleanup(site.Close)
+
+	cfg := config.Defaults()
+	cfg.Scraper.RateLimit = time.Millisecond
+	cfg.Scraper.MaxRetries = 0
+	cfg.Progress.Enabled = false
+	cfg.Cookies.Enabled = false
+
+	s := NewServer(cfg)
+	api := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		api.Close()
+		s.mu.Lock()
+		for _, job := range s.jobs {
+			job.Cancel()
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+	})
+	return s, api, site
+}
+
+// submit posts a job and decodes the response
+func submit(t *testing.T, api *httptest.Server, body string) (int, map[string]interface{}) {
+	t.Helper()
+	resp, err := http.Post(api.URL+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, decoded
+}
+
+// waitFinished waits for the job to finish and returns its status
+func waitFinished(t *testing.T, job *Job) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, done := job.doneAt(); done {
+			return job.Status(true)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", job.ID)
+	return JobStatus{}
+}
+
+func pageURLs(site *httptest.Server, n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/page%d", site.URL, i)
+	}
+	return urls
+}
+
+func TestSubmitAndStream(t *testing.T) {
+	s, api, site := newTestServer(t, 20*time.Millisecond)
+
+	urls := pageURLs(site, 5)
+	body, _ := json.Marshal(SubmitRequest{
+		URLs:   append(urls, " "),
+		Config: json.RawMessage(`{"scraper": {"workers": 2}, "extraction": {"selectors": {"heading": "h1"}}}`),
+	})
+	status, created := submit(t, api, string(body))
+	if status != http.StatusCreated {
+		t.Fatalf("POST /jobs = %d %v, want 201", status, created)
+	}
+	id, _ := created["id"].(string)
+	job, ok := s.Job(id)
+	if !ok {
+		t.Fatalf("job %q not found", id)
+	}
+	if job.Config.Scraper.Workers != 2 {
+		t.Errorf("job workers = %d, want the override 2", job.Config.Scraper.Workers)
+	}
+	if s.Config.Scraper.Workers != 3 {
+		t.Errorf("server workers = %d, changed by a job override", s.Config.Scraper.Workers)
+	}
+
+	// Following the results stays open until the job finishes
+	resp, err := http.Get(api.URL + "/jobs/" + id + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+	}
+	var streamed []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var result models.Result
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("result line %q: %v", scanner.Text(), err)
+		}
+		if result.Err != "" {
+			t.Errorf("%s failed: %s", result.URL, result.Err)
+		}
+		if result.Extracted["heading"] != "Page" {
+			t.Errorf("%s heading = %v, want Page", result.URL, result.Extracted["heading"])
+		}
+		streamed = append(streamed, result.URL)
+	}
+	sort.Strings(streamed)
+	if strings.Join(streamed, " ") != strings.Join(urls, " ") {
+		t.Errorf("streamed %v, want each URL once: %v", streamed, urls)
+	}
+
+	final := waitFinished(t, job)
+	if final.Status != StatusCompleted || final.Progress.Succeeded != len(urls) {
+		t.Errorf("status = %s with %d succeeded, want completed with %d", final.Status, final.Progress.Succeeded, len(urls))
+	}
+
+	// Without following, the results so far come back straight away
+	resp, err = http.Get(api.URL + "/jobs/" + id + "/results?follow=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := 0
+	for scanner = bufio.NewScanner(resp.Body); scanner.Scan(); {
+		lines++
+	}
+	if lines != len(urls) {
+		t.Errorf("follow=false returned %d results, want %d", lines, len(urls))
+	}
+
+	// The status carries the report, the list doesn't
+	var detail JobStatus
+	getJSON(t, api.URL+"/jobs/"+id, &detail)
+	if detail.Report == nil || detail.Report.Total != len(urls) {
+		t.Errorf("status report = %+v, want %d URLs", detail.Report, len(urls))
+	}
+	var list []JobStatus
+	getJSON(t, api.URL+"/jobs", &list)
+	if len(list) != 1 || list[0].ID != id || list[0].Report != nil {
+		t.Errorf("GET /jobs = %+v, want the one job without its report", list)
+	}
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s = %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	_, api, _ := newTestServer(t, 0)
+
+	tests := []struct {
+		name     string
+		body     string
+		problems []string // Paths of the reported problems
+	}{
+		{name: "invalid JSON", body: `{"urls": [`},
+		{name: "no URLs", body: `{"urls": [" "]}`},
+		{
+			name:     "disallowed section",
+			body:     `{"urls": ["https://a.example"], "config": {"io": {"output_file": "/etc/passwd"}}}`,
+			problems: []string{"io"},
+		},
+		{
+			name:     "disallowed key in an allowed section",
+			body:     `{"urls": ["https://a.example"], "config": {"scraper": {"workers": 2, "user_agents": ["x"]}}}`,
+			problems: []string{"scraper.user_agents"},
+		},
+		{
+			name:     "several disallowed keys",
+			body:     `{"urls": ["https://a.example"], "config": {"webhook": {"url": "http://x"}, "proxies": {"enabled": true}}}`,
+			problems: []string{"proxies", "webhook"},
+		},
+		{
+			name:     "unknown key under an allowed path",
+			body:     `{"urls": ["https://a.example"], "config": {"extraction": {"selector": {"x": "h2"}}}}`,
+			problems: []string{"extraction.selector"},
+		},
+		{
+			name:     "invalid value",
+			body:     `{"urls": ["https://a.example"], "config": {"scraper": {"workers": -1}}}`,
+			problems: []string{"scraper.workers"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, decoded := submit(t, api, tt.body)
+			if status != http.StatusBadRequest {
+				t.Fatalf("POST /jobs = %d %v, want 400", status, decoded)
+			}
+			if decoded["error"] == nil {
+				t.Errorf("response %v has no error", decoded)
+			}
+
+			var paths []string
+			problems, _ := decoded["problems"].([]interface{})
+			for _, p := range problems {
+				problem, _ := p.(map[string]interface{})
+				path, _ := problem["path"].(string)
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			if strings.Join(paths, " ") != strings.Join(tt.problems, " ") {
+				t.Errorf("problem paths = %v, want %v", paths, tt.problems)
+			}
+		})
+	}
+}
+
+func TestSubmitOverrideYAML(t *testing.T) {
+	s, _, _ := newTestServer(t, 0)
+
+	tests := []struct {
+		name     string
+		override string
+		problem  string
+	}{
+		{
+			name:     "merge key smuggling a disallowed key",
+			override: "scraper:\n  <<: {user_agents: [x]}\n",
+			problem:  "scraper.<<",
+		},
+		{
+			name:     "merge key at the root",
+			override: "<<: {io: {output_file: x}}\n",
+			problem:  "<<",
+		},
+		{
+			name:     "anchor in a disallowed section",
+			override: "cookies: &c {enabled: true}\nextraction: {selectors: {x: h2}}\n",
+			problem:  "cookies",
+		},
+		{
+			name:     "scalar instead of a section",
+			override: "scraper: 3\n",
+			problem:  "scraper",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Submit([]string{"https://a.example"}, []byte(tt.override))
+			var verr *config.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Submit() = %v, want a validation error", err)
+			}
+			if len(verr.Problems) != 1 || verr.Problems[0].Path != tt.problem {
+				t.Errorf("problems = %v, want one at %q", verr.Problems, tt.problem)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		method, path string
+	}{
+		{http.MethodDelete, "/jobs/%s"},
+		{http.MethodPost, "/jobs/%s/cancel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			s, api, site := newTestServer(t, 100*time.Millisecond)
+			cfg := []byte(`{"scraper": {"workers": 1}}`)
+			job, err := s.Submit(pageURLs(site, 20), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(tt.method, api.URL+fmt.Sprintf(tt.path, job.ID), nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Errorf("%s %s = %d, want 202", tt.method, tt.path, resp.StatusCode)
+			}
+
+			final := waitFinished(t, job)
+			if final.Status != StatusCancelled {
+				t.Errorf("status = %s, want cancelled", final.Status)
+			}
+			if final.Progress.Completed >= len(job.URLs) {
+				t.Errorf("cancelled job still fetched all %d URLs", final.Progress.Completed)
+			}
+		})
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	_, api, _ := newTestServer(t, 0)
+
+	for _, tt := range []struct{ method, path string }{
+		{http.MethodGet, "/jobs/missing"},
+		{http.MethodGet, "/jobs/missing/results"},
+		{http.MethodDelete, "/jobs/missing"},
+		{http.MethodPost, "/jobs/missing/cancel"},
+	} {
+		req, _ := http.NewRequest(tt.method, api.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s = %d, want 404", tt.method, tt.path, resp.StatusCode)
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		retention time.Duration
+		maxJobs   int
+		finished  map[string]time.Duration // Job ID to how long ago it finished; 0 means still running
+		want      []string
+	}{
+		{
+			name:      "retention",
+			retention: time.Hour,
+			maxJobs:   10,
+			finished:  map[string]time.Duration{"old": 2 * time.Hour, "recent": time.Minute, "running": 0},
+			want:      []string{"recent", "running"},
+		},
+		{
+			name:      "max jobs keeps the newest",
+			retention: time.Hour,
+			maxJobs:   2,
+			finished:  map[string]time.Duration{"a": 3 * time.Minute, "b": 2 * time.Minute, "c": time.Minute, "running": 0},
+			want:      []string{"b", "c", "running"},
+		},
+		{
+			name:      "running jobs are never evicted",
+			retention: time.Minute,
+			maxJobs:   0,
+			finished:  map[string]time.Duration{"a": time.Second, "running": 0, "queued": 0},
+			want:      []string{"queued", "running"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Defaults()
+			cfg.Server.JobRetention = tt.retention
+			cfg.Server.MaxJobs = tt.maxJobs
+			s := NewServer(cfg)
+
+			for id, ago := range tt.finished {
+				job := newJob(id, nil, cfg)
+				if ago > 0 {
+					job.finish(nil, nil)
+					job.finishedAt = now.Add(-ago)
+				}
+				s.jobs[id] = job
+			}
+			s.evict(now)
+
+			var got []string
+			for id := range s.jobs {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenAndServeShutdown(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Server.Address = "127.0.0.1:0"
+	s := NewServer(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ListenAndServe(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ListenAndServe() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe() did not return after cancellation")
+	}
+	if s.stopping.Err() == nil {
+		t.Error("webhook deliveries were not told to stop")
+	}
+}
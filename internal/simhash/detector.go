@@ -0,0 +1,87 @@
+package simhash
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/content"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// boilerplate are the elements left out of a page's main text
+var boilerplate = []string{"script", "style", "noscript", "template", "nav", "header", "footer", "aside"}
+
+// Detector fingerprints the main text of each result and flags the ones
+// nearly identical to a result seen earlier. It is safe for concurrent use.
+type Detector struct {
+	Config *config.NearDupConfig
+
+	selectors string
+	mu        sync.Mutex
+	index     *Index
+}
+
+// NewDetector creates a detector
+func NewDetector(cfg *config.NearDupConfig) *Detector {
+	// Pages are near-duplicates when at most this many of the 64 bits differ
+	distance := int(math.Floor((1-cfg.Similarity)*64 + 1e-9))
+	return &Detector{
+		Config:    cfg,
+		selectors: strings.Join(append(boilerplate[:len(boilerplate):len(boilerplate)], cfg.IgnoreSelectors...), ", "),
+		index:     NewIndex(distance),
+	}
+}
+
+// Observe sets the result's fingerprint and, when an earlier result is
+// within the similarity threshold, its near_duplicate_of and similarity. Failed
+// results and pages without text are left alone.
+func (d *Detector) Observe(result *models.Result) {
+	if result.Err != "" {
+		return
+	}
+	words := Words(d.text(*result))
+	if len(words) == 0 {
+		return
+	}
+	fp := Fingerprint(words, d.Config.ShingleSize)
+	result.SimHash = fmt.Sprintf("%016x", fp)
+
+	// Short pages, such as error and login pages, look alike without being duplicates
+	if len(words) < d.Config.MinWords {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, distance, ok := d.index.Find(fp); ok {
+		result.NearDuplicateOf = id
+		result.Similarity = 1 - float64(distance)/64
+		return
+	}
+	d.index.Add(fp, result.URL)
+}
+
+// text returns the main text of a page: the main or article element of an
+// HTML page without its boilerplate, or the content of a text page
+func (d *Detector) text(result models.Result) string {
+	if result.Content == "" {
+		return ""
+	}
+	if content.Detect(result.ContentType, []byte(result.Content)) != content.HTML {
+		return result.Content
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(result.Content))
+	if err != nil {
+		return ""
+	}
+	doc.Find(d.selectors).Remove()
+	if main := doc.Find(`main, article, [role="main"]`).First(); strings.TrimSpace(main.Text()) != "" {
+		return main.Text()
+	}
+	return doc.Find("body").Text()
+}
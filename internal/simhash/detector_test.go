@@ -0,0 +1,48 @@
+package simhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+func TestDetector(t *testing.T) {
+	d := NewDetector(&config.NearDupConfig{Enabled: true, Similarity: 0.9, ShingleSize: 3, MinWords: 20})
+	page := func(url, body string) *models.Result {
+		return &models.Result{
+			URL:         url,
+			ContentType: "text/html",
+			Content:     "<html><body><nav>Home About Contact</nav><main>" + body + "</main></body></html>",
+		}
+	}
+	text := strings.Join(article(1, 300), " ")
+
+	first := page("https://a.test/1", text)
+	d.Observe(first)
+	if first.SimHash == "" || first.NearDuplicateOf != "" {
+		t.Fatalf("first page = simhash %q, near_duplicate_of %q, want a fingerprint only", first.SimHash, first.NearDuplicateOf)
+	}
+
+	// The same text with a different menu; canonical duplicates keep their own flag
+	second := page("https://a.test/2", text)
+	second.Content = strings.Replace(second.Content, "About", "Team", 1)
+	second.DuplicateOf = "https://a.test/canonical"
+	d.Observe(second)
+	if second.NearDuplicateOf != "https://a.test/1" || second.Similarity != 1 || second.DuplicateOf != "https://a.test/canonical" {
+		t.Errorf("second page = near_duplicate_of %q, similarity %g, duplicate_of %q", second.NearDuplicateOf, second.Similarity, second.DuplicateOf)
+	}
+
+	other := page("https://a.test/3", strings.Join(article(2, 300), " "))
+	d.Observe(other)
+	if other.NearDuplicateOf != "" {
+		t.Errorf("a different page was flagged as a near-duplicate of %q", other.NearDuplicateOf)
+	}
+
+	short := page("https://a.test/4", "Not found")
+	d.Observe(short)
+	if short.NearDuplicateOf != "" {
+		t.Errorf("a page below min_words was flagged as a near-duplicate of %q", short.NearDuplicateOf)
+	}
+}
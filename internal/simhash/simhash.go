@@ -0,0 +1,143 @@
+// Package simhash fingerprints page text so that near-identical pages get
+// fingerprints that differ in only a few bits
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// Words splits text into lowercase words
+func Words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Fingerprint returns the 64-bit SimHash of the words, built from shingles
+// of size consecutive words
+func Fingerprint(words []string, size int) uint64 {
+	if len(words) == 0 {
+		return 0
+	}
+	size = max(min(size, len(words)), 1)
+
+	var weights [64]int
+	for i := 0; i+size <= len(words); i++ {
+		h := hash(strings.Join(words[i:i+size], " "))
+		for b := 0; b < 64; b++ {
+			if h&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+
+	var fp uint64
+	for b, w := range weights {
+		if w > 0 {
+			fp |= 1 << b
+		}
+	}
+	return fp
+}
+
+// Distance returns the number of bits in which two fingerprints differ
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Similarity returns the share of bits two fingerprints have in common
+func Similarity(a, b uint64) float64 {
+	return 1 - float64(Distance(a, b))/64
+}
+
+// hash is FNV-1a followed by the SplitMix64 finalizer, whose output bits
+// are independent enough for every one of them to vote fairly
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Index finds the closest fingerprint within a distance of a new one. The
+// fingerprints are split into distance+1 bands: two fingerprints within the
+// distance agree exactly on at least one band, so only the fingerprints
+// sharing a band with the new one need comparing.
+type Index struct {
+	distance int
+	bands    []band
+	entries  []entry
+}
+
+// band is a range of bits and the entries by their value in it
+type band struct {
+	shift   uint
+	mask    uint64
+	buckets map[uint64][]int
+}
+
+// entry is an indexed fingerprint
+type entry struct {
+	fp uint64
+	id string
+}
+
+// NewIndex creates an index matching fingerprints up to distance bits apart
+func NewIndex(distance int) *Index {
+	distance = max(min(distance, 63), 0)
+	n := distance + 1
+	idx := &Index{distance: distance}
+	start := 0
+	for i := 0; i < n; i++ {
+		width := 64 / n
+		if i < 64%n {
+			width++
+		}
+		idx.bands = append(idx.bands, band{
+			shift:   uint(start),
+			mask:    1<<uint(width) - 1,
+			buckets: make(map[uint64][]int),
+		})
+		start += width
+	}
+	return idx
+}
+
+// Find returns the ID of the closest indexed fingerprint within the
+// distance, the earliest added on a tie, and its distance
+func (idx *Index) Find(fp uint64) (id string, distance int, ok bool) {
+	best := -1
+	distance = idx.distance + 1
+	for _, b := range idx.bands {
+		for _, i := range b.buckets[fp>>b.shift&b.mask] {
+			d := Distance(fp, idx.entries[i].fp)
+			if d < distance || (d == distance && i < best) {
+				best, distance = i, d
+			}
+		}
+	}
+	if best < 0 {
+		return "", 0, false
+	}
+	return idx.entries[best].id, distance, true
+}
+
+// Add indexes a fingerprint under an ID
+func (idx *Index) Add(fp uint64, id string) {
+	i := len(idx.entries)
+	idx.entries = append(idx.entries, entry{fp: fp, id: id})
+	for _, b := range idx.bands {
+		key := fp >> b.shift & b.mask
+		b.buckets[key] = append(b.buckets[key], i)
+	}
+}
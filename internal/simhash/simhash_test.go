@@ -0,0 +1,148 @@
+package simhash
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// article returns n words of varied text, different for each seed
+func article(seed, n int) []string {
+	words := make([]string, n)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", hash(fmt.Sprint(seed, ":", i))%5000)
+	}
+	return words
+}
+
+func TestWords(t *testing.T) {
+	got := Words("Hello, World! It's 2026 — déjà vu.")
+	want := []string{"hello", "world", "it", "s", "2026", "déjà", "vu"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Words = %q, want %q", got, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	page := article(1, 400)
+
+	if a, b := Fingerprint(page, 3), Fingerprint(append([]string(nil), page...), 3); a != b {
+		t.Errorf("identical words got fingerprints %016x and %016x", a, b)
+	}
+
+	edited := append([]string(nil), page...)
+	edited[200] = "changed"
+	if d := Distance(Fingerprint(page, 3), Fingerprint(edited, 3)); d > 6 {
+		t.Errorf("one changed word in 400 moved the fingerprint %d bits, want at most 6", d)
+	}
+
+	if d := Distance(Fingerprint(page, 3), Fingerprint(article(2, 400), 3)); d < 16 {
+		t.Errorf("different pages are only %d bits apart, want at least 16", d)
+	}
+
+	if fp := Fingerprint(nil, 3); fp != 0 {
+		t.Errorf("Fingerprint of no words = %016x, want 0", fp)
+	}
+	if Fingerprint([]string{"one", "two"}, 3) == 0 {
+		t.Error("Fingerprint of fewer words than a shingle should use them all")
+	}
+}
+
+func TestDistanceAndSimilarity(t *testing.T) {
+	if d := Distance(0, 0xff); d != 8 {
+		t.Errorf("Distance = %d, want 8", d)
+	}
+	if s := Similarity(0, 0xffff); s != 0.75 {
+		t.Errorf("Similarity = %g, want 0.75", s)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	const base = uint64(0x0123456789abcdef)
+	// flip returns base with the given bits flipped
+	flip := func(bits ...int) uint64 {
+		fp := base
+		for _, b := range bits {
+			fp ^= 1 << b
+		}
+		return fp
+	}
+
+	idx := NewIndex(3)
+	idx.Add(base, "first")
+	idx.Add(flip(0, 20, 40, 60), "far")
+
+	tests := []struct {
+		name     string
+		fp       uint64
+		id       string
+		distance int
+		ok       bool
+	}{
+		{"exact", base, "first", 0, true},
+		{"within the distance", flip(1, 30, 63), "first", 3, true},
+		// Bits spread over every band, so no band matches exactly
+		{"beyond the distance", flip(5, 21, 37, 53, 60), "", 0, false},
+		{"closest wins", flip(0, 20, 40), "far", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, distance, ok := idx.Find(tt.fp)
+			if id != tt.id || distance != tt.distance || ok != tt.ok {
+				t.Errorf("Find(%016x) = %q, %d, %v, want %q, %d, %v", tt.fp, id, distance, ok, tt.id, tt.distance, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIndexPrefersEarliestOnTie(t *testing.T) {
+	idx := NewIndex(4)
+	idx.Add(0b0011, "first")
+	idx.Add(0b1100, "second")
+	idx.Add(0b0011<<32, "other band")
+
+	// Two bits from both of the first two
+	if id, distance, ok := idx.Find(0); id != "first" || distance != 2 || !ok {
+		t.Errorf("Find = %q, %d, %v, want first at distance 2", id, distance, ok)
+	}
+}
+
+func TestIndexDistanceBounds(t *testing.T) {
+	// Distance 0 is one band of all 64 bits: only exact matches
+	exact := NewIndex(0)
+	exact.Add(^uint64(0), "all")
+	if _, _, ok := exact.Find(^uint64(0) >> 1); ok {
+		t.Error("an index of distance 0 matched a different fingerprint")
+	}
+	if id, _, ok := exact.Find(^uint64(0)); !ok || id != "all" {
+		t.Error("an index of distance 0 missed an exact match")
+	}
+
+	// Distances past 63 are capped: 64 bands of one bit each
+	loose := NewIndex(100)
+	loose.Add(0, "zero")
+	if id, distance, ok := loose.Find(^uint64(0) >> 1); !ok || id != "zero" || distance != 63 {
+		t.Errorf("Find in an index of distance 63 = %q, %d, %v, want zero at 63", id, distance, ok)
+	}
+	if _, _, ok := loose.Find(^uint64(0)); ok {
+		t.Error("an index of distance 63 matched a fingerprint 64 bits away")
+	}
+}
+
+func TestIndexManyEntries(t *testing.T) {
+	idx := NewIndex(8)
+	for i := range 1000 {
+		idx.Add(Fingerprint(article(i, 200), 3), fmt.Sprint(i))
+	}
+
+	words := article(500, 200)
+	words[100] = strings.ToUpper(words[100])
+	fp := Fingerprint(words, 3)
+	if d := Distance(fp, Fingerprint(article(500, 200), 3)); d > 8 {
+		t.Fatalf("the edited page is %d bits from the original, want at most 8", d)
+	}
+	if id, _, ok := idx.Find(fp); !ok || id != "500" {
+		t.Errorf("Find of an edited page = %q, %v, want 500", id, ok)
+	}
+}
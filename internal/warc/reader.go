@@ -0,0 +1,117 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader reads records from a WARC file, compressed or not
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a reader; gzip-compressed input is detected and
+// decompressed, whether it is one member per record or a single stream
+func NewReader(r io.Reader) (*Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		buffered = bufio.NewReader(gz)
+	}
+	return &Reader{r: buffered}, nil
+}
+
+// Next returns the next record, or io.EOF when there are no more
+func (r *Reader) Next() (*Record, error) {
+	// Skip the blank lines separating records
+	var version string
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("reading WARC record: %w", err)
+		}
+		if version = strings.TrimSpace(line); version != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("reading WARC record: unexpected line %q", version)
+	}
+
+	header, err := textproto.NewReader(r.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("reading WARC record header: %w", err)
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("reading WARC record: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return nil, fmt.Errorf("reading WARC record block: %w", err)
+	}
+
+	record := &Record{
+		Type:    header.Get("WARC-Type"),
+		ID:      header.Get("WARC-Record-ID"),
+		Headers: make(map[string]string),
+		Block:   block,
+	}
+	record.Date, _ = time.Parse(time.RFC3339Nano, header.Get("WARC-Date"))
+	for name := range header {
+		switch name {
+		case "Warc-Type", "Warc-Record-Id", "Warc-Date", "Content-Length":
+		default:
+			record.Headers[warcHeaderName(name)] = header.Get(name)
+		}
+	}
+	return record, nil
+}
+
+// Header returns a named field of the record, matched case-insensitively
+func (r *Record) Header(name string) string {
+	if value, ok := r.Headers[name]; ok {
+		return value
+	}
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// warcHeaderName restores the WARC spelling of a canonicalised header name,
+// e.g. Warc-Target-Uri becomes WARC-Target-URI
+func warcHeaderName(name string) string {
+	parts := strings.Split(name, "-")
+	for i, part := range parts {
+		switch strings.ToUpper(part) {
+		case "WARC", "URI", "ID", "IP":
+			parts[i] = strings.ToUpper(part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Method returns the method of an archived HTTP request
+func Method(request *Record) string {
+	line, _, _ := bytes.Cut(request.Block, []byte("\r\n"))
+	method, _, _ := bytes.Cut(line, []byte(" "))
+	return string(method)
+}
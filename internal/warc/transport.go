@@ -0,0 +1,186 @@
+package warc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/proxy"
+)
+
+// Transport returns a round tripper that archives every exchange made
+// through next as a request record, a response record and a metadata record
+// with the attempt number, timing, proxy and any error. The records are
+// written once the response body has been read or closed, or straight away
+// when the request fails. Use a new one for every fetch so attempts are
+// numbered per fetch.
+func (w *Writer) Transport(next http.RoundTripper) http.RoundTripper {
+	return &recorder{writer: w, next: next, attempts: make(map[string]int)}
+}
+
+// recorder archives the exchanges of a single fetch
+type recorder struct {
+	writer *Writer
+	next   http.RoundTripper
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+// RoundTrip sends the request through the wrapped transport and archives it
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	r.mu.Lock()
+	r.attempts[target]++
+	attempt := r.attempts[target]
+	r.mu.Unlock()
+
+	// Capture the request as it goes out, including cookies set by the client
+	request := NewRecord(TypeRequest, nil)
+	request.Headers["WARC-Target-URI"] = target
+	request.Headers["Content-Type"] = "application/http;msgtype=request"
+	if dump, err := httputil.DumpRequestOut(req, true); err == nil {
+		request.Block = dump
+	} else {
+		slog.Warn("Error capturing request for the WARC archive", "url", target, "error", err)
+	}
+	request.Headers["WARC-Block-Digest"] = Digest(request.Block)
+
+	ex := &exchange{
+		writer:  r.writer,
+		target:  target,
+		attempt: attempt,
+		proxy:   r.proxyFor(req),
+		start:   time.Now(),
+		request: request,
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		ex.fail(err)
+		return nil, err
+	}
+
+	// Record the body as it is read; the records are written once it is done
+	ex.response = resp
+	resp.Body = &recordingBody{ReadCloser: resp.Body, exchange: ex}
+	return resp, nil
+}
+
+// proxyFor returns the redacted proxy the request is sent through, if any
+func (r *recorder) proxyFor(req *http.Request) string {
+	transport, ok := r.next.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		return ""
+	}
+	proxyURL, err := transport.Proxy(req)
+	if err != nil || proxyURL == nil {
+		return ""
+	}
+	return proxy.Redacted(proxyURL.String())
+}
+
+// exchange is one request and its response on the way to the archive
+type exchange struct {
+	writer   *Writer
+	target   string
+	attempt  int
+	proxy    string
+	start    time.Time
+	request  *Record
+	response *http.Response
+	body     bytes.Buffer
+	once     sync.Once
+}
+
+// fail archives a request that got no response
+func (e *exchange) fail(err error) {
+	e.once.Do(func() {
+		e.write(e.metadata(e.request.ID, err))
+	})
+}
+
+// finish archives the response; complete reports whether the whole body was read
+func (e *exchange) finish(complete bool) {
+	e.once.Do(func() {
+		resp := e.response
+
+		// Status line and headers as received; the transport has already
+		// removed the chunked transfer encoding from the body
+		var head bytes.Buffer
+		fmt.Fprintf(&head, "%s %s\r\n", resp.Proto, resp.Status)
+		resp.Header.Write(&head)
+		head.WriteString("\r\n")
+		payload := e.body.Bytes()
+
+		response := NewRecord(TypeResponse, append(head.Bytes(), payload...))
+		response.Headers["WARC-Target-URI"] = e.target
+		response.Headers["WARC-Concurrent-To"] = e.request.ID
+		response.Headers["Content-Type"] = "application/http;msgtype=response"
+		response.Headers["WARC-Block-Digest"] = Digest(response.Block)
+		response.Headers["WARC-Payload-Digest"] = Digest(payload)
+		if !complete {
+			// Bodies that were never read (error statuses, rejected content
+			// types) are unspecified; ones cut off by a size limit are length
+			if e.body.Len() == 0 {
+				response.Headers["WARC-Truncated"] = "unspecified"
+			} else {
+				response.Headers["WARC-Truncated"] = "length"
+			}
+		}
+
+		e.write(response, e.metadata(response.ID, nil))
+	})
+}
+
+// metadata builds the metadata record describing the exchange
+func (e *exchange) metadata(concurrentTo string, err error) *Record {
+	var errText string
+	if err != nil {
+		errText = err.Error()
+	}
+	record := NewRecord(TypeMetadata, Fields(
+		"attempt", strconv.Itoa(e.attempt),
+		"fetchTimeMs", strconv.FormatInt(time.Since(e.start).Milliseconds(), 10),
+		"via-proxy", e.proxy,
+		"error", errText,
+	))
+	record.Headers["WARC-Target-URI"] = e.target
+	record.Headers["WARC-Concurrent-To"] = concurrentTo
+	record.Headers["Content-Type"] = "application/warc-fields"
+	return record
+}
+
+// write archives the request record followed by the given records
+func (e *exchange) write(records ...*Record) {
+	if err := e.writer.Write(append([]*Record{e.request}, records...)...); err != nil {
+		slog.Warn("Error writing WARC records", "url", e.target, "error", err)
+	}
+}
+
+// recordingBody copies the response body into the exchange as it is read
+type recordingBody struct {
+	io.ReadCloser
+	exchange *exchange
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.exchange.body.Write(p[:n])
+	if err == io.EOF {
+		b.exchange.finish(true)
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	resp := b.exchange.response
+	b.exchange.finish(resp.Request.Method == http.MethodHead || resp.ContentLength == 0)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,213 @@
+// Package warc archives raw HTTP exchanges in WARC 1.1 files
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// Version is the WARC format version written to every record
+const Version = "WARC/1.1"
+
+// Record types
+const (
+	TypeWarcinfo = "warcinfo"
+	TypeRequest  = "request"
+	TypeResponse = "response"
+	TypeMetadata = "metadata"
+)
+
+// Record is a single WARC record
+type Record struct {
+	Type    string
+	ID      string
+	Date    time.Time
+	Headers map[string]string // Named fields besides WARC-Type, WARC-Record-ID, WARC-Date and Content-Length
+	Block   []byte
+}
+
+// NewRecord creates a record of the given type with a fresh ID
+func NewRecord(recordType string, block []byte) *Record {
+	return &Record{
+		Type:    recordType,
+		ID:      NewRecordID(),
+		Date:    time.Now(),
+		Headers: make(map[string]string),
+		Block:   block,
+	}
+}
+
+// WriteTo writes the record in WARC format
+func (r *Record) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s\r\n", Version)
+	fmt.Fprintf(&buf, "WARC-Type: %s\r\n", r.Type)
+	fmt.Fprintf(&buf, "WARC-Record-ID: %s\r\n", r.ID)
+	fmt.Fprintf(&buf, "WARC-Date: %s\r\n", r.Date.UTC().Format("2006-01-02T15:04:05.000000Z"))
+	names := make([]string, 0, len(r.Headers))
+	for name := range r.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, r.Headers[name])
+	}
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(r.Block))
+	buf.Write(r.Block)
+	buf.WriteString("\r\n\r\n")
+	return buf.WriteTo(w)
+}
+
+// Writer appends gzip-compressed records to WARC files, one gzip member per
+// record, starting a new file once the current one reaches the configured
+// size. Files are created lazily; write errors are logged rather than
+// failing the fetch being archived.
+type Writer struct {
+	Config *config.WARCConfig
+
+	mu      sync.Mutex
+	started time.Time
+	serial  int
+	file    *os.File
+	size    int64
+}
+
+// NewWriter creates a WARC writer
+func NewWriter(config *config.WARCConfig) *Writer {
+	return &Writer{
+		Config:  config,
+		started: time.Now(),
+	}
+}
+
+// Write appends records to the archive; they always end up in the same file
+func (w *Writer) Write(records ...*Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Roll over to a new file once the current one is full
+	if w.file != nil && w.Config.MaxSize > 0 && w.size >= w.Config.MaxSize {
+		if err := w.file.Close(); err != nil {
+			slog.Warn("Error closing WARC file", "file", w.file.Name(), "error", err)
+		}
+		w.file = nil
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		if err := w.append(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the current file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open starts the next file with a warcinfo record; callers must hold the lock
+func (w *Writer) open() error {
+	if err := os.MkdirAll(w.Config.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.Config.Prefix, w.started.UTC().Format("20060102150405"), w.serial)
+	file, err := os.OpenFile(filepath.Join(w.Config.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.serial++
+	w.file = file
+	w.size = 0
+	slog.Debug("Opened WARC file", "file", file.Name())
+
+	info := NewRecord(TypeWarcinfo, Fields(
+		"software", "concurrent-web-scraper",
+		"format", "WARC File Format 1.1",
+		"conformsTo", "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/",
+	))
+	info.Headers["WARC-Filename"] = name
+	info.Headers["Content-Type"] = "application/warc-fields"
+	return w.append(info)
+}
+
+// append writes one record as its own gzip member; callers must hold the lock
+func (w *Writer) append(record *Record) error {
+	counter := &countingWriter{w: w.file}
+	gz := gzip.NewWriter(counter)
+	if _, err := record.WriteTo(gz); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	w.size += counter.n
+	return nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Fields formats name/value pairs as an application/warc-fields block,
+// skipping empty values
+func Fields(pairs ...string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", pairs[i], pairs[i+1])
+		}
+	}
+	return buf.Bytes()
+}
+
+// NewRecordID returns a new random record ID
+func NewRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Digest returns the SHA-1 digest of data in the form used by
+// WARC-Block-Digest and WARC-Payload-Digest
+func Digest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,292 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+)
+
+// testRecords returns records with blocks that look like record boundaries
+func testRecords() []*Record {
+	request := NewRecord(TypeRequest, []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	request.Headers["WARC-Target-URI"] = "http://example.com/"
+	request.Headers["Content-Type"] = "application/http;msgtype=request"
+
+	response := NewRecord(TypeResponse, []byte("HTTP/1.1 200 OK\r\n\r\nWARC/1.1\r\n\r\n\x00\x1f\x8b binary"))
+	response.Headers["WARC-Target-URI"] = "http://example.com/"
+	response.Headers["WARC-Concurrent-To"] = request.ID
+	response.Headers["WARC-Payload-Digest"] = Digest([]byte("WARC/1.1\r\n\r\n\x00\x1f\x8b binary"))
+
+	empty := NewRecord(TypeMetadata, nil)
+	empty.Headers["WARC-Concurrent-To"] = response.ID
+	return []*Record{request, response, empty}
+}
+
+// readAll reads every record of a WARC file
+func readAll(t *testing.T, r io.Reader) []*Record {
+	t.Helper()
+	reader, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*Record
+	for {
+		record, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+// sameRecord compares a record read back with the one written
+func sameRecord(t *testing.T, got, want *Record) {
+	t.Helper()
+	if got.Type != want.Type || got.ID != want.ID {
+		t.Errorf("read %s %s, want %s %s", got.Type, got.ID, want.Type, want.ID)
+	}
+	if !got.Date.Equal(want.Date.Truncate(time.Microsecond)) {
+		t.Errorf("%s date = %v, want %v", want.ID, got.Date, want.Date)
+	}
+	if !bytes.Equal(got.Block, want.Block) {
+		t.Errorf("%s block = %q, want %q", want.ID, got.Block, want.Block)
+	}
+	if len(got.Headers) != len(want.Headers) {
+		t.Errorf("%s headers = %v, want %v", want.ID, got.Headers, want.Headers)
+	}
+	for name, value := range want.Headers {
+		if got.Headers[name] != value {
+			t.Errorf("%s header %s = %q, want %q", want.ID, name, got.Headers[name], value)
+		}
+	}
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		wrap func(io.Writer) io.WriteCloser
+	}{
+		{name: "plain", wrap: func(w io.Writer) io.WriteCloser { return nopCloser{w} }},
+		{name: "single gzip stream", wrap: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := testRecords()
+			var buf bytes.Buffer
+			w := tt.wrap(&buf)
+			for _, record := range records {
+				if _, err := record.WriteTo(w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := readAll(t, &buf)
+			if len(got) != len(records) {
+				t.Fatalf("read %d records, want %d", len(got), len(records))
+			}
+			for i := range records {
+				sameRecord(t, got[i], records[i])
+			}
+		})
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestWriterRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(&config.WARCConfig{Dir: dir, Prefix: "test"})
+	records := testRecords()
+	if err := w.Write(records[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(records[2:]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := warcFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("wrote %v, want one file", files)
+	}
+	if !strings.HasPrefix(filepath.Base(files[0]), "test-") || !strings.HasSuffix(files[0], "-00000.warc.gz") {
+		t.Errorf("file name = %s, want test-<time>-00000.warc.gz", filepath.Base(files[0]))
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := readAll(t, f)
+	if len(got) != len(records)+1 {
+		t.Fatalf("read %d records, want a warcinfo record and %d more", len(got), len(records))
+	}
+	if got[0].Type != TypeWarcinfo || got[0].Header("warc-filename") != filepath.Base(files[0]) {
+		t.Errorf("first record = %s naming %q, want the warcinfo naming its file", got[0].Type, got[0].Header("WARC-Filename"))
+	}
+	for i := range records {
+		sameRecord(t, got[i+1], records[i])
+	}
+}
+
+func TestWriterGzipMembers(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(&config.WARCConfig{Dir: dir, Prefix: "test"})
+	records := testRecords()
+	if err := w.Write(records...); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	f, err := os.Open(warcFiles(t, dir)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Every record is a gzip member of its own, so it can be read on its own
+	buffered := bufio.NewReader(f)
+	var types []string
+	for {
+		if _, err := buffered.Peek(1); err == io.EOF {
+			break
+		}
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz.Multistream(false)
+		member, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAll(t, bytes.NewReader(member))
+		if len(got) != 1 {
+			t.Fatalf("gzip member holds %d records, want 1", len(got))
+		}
+		types = append(types, got[0].Type)
+	}
+
+	want := []string{TypeWarcinfo, TypeRequest, TypeResponse, TypeMetadata}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("gzip members hold %v, want %v", types, want)
+	}
+}
+
+func TestWriterRollover(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(&config.WARCConfig{Dir: dir, Prefix: "test", MaxSize: 1})
+	for i := 0; i < 3; i++ {
+		// The records of one call stay together, past the size limit
+		if err := w.Write(testRecords()...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	files := warcFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatalf("wrote %d files, want 3", len(files))
+	}
+	for i, file := range files {
+		if want := fmt.Sprintf("-%05d.warc.gz", i); !strings.HasSuffix(file, want) {
+			t.Errorf("file %d = %s, want it to end in %s", i, filepath.Base(file), want)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAll(t, f)
+		f.Close()
+		if len(got) != 4 || got[0].Type != TypeWarcinfo || got[0].Header("WARC-Filename") != filepath.Base(file) {
+			t.Errorf("%s holds %d records, want its own warcinfo record and 3 more", filepath.Base(file), len(got))
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	w := NewWriter(&config.WARCConfig{Dir: dir, Prefix: "test"})
+	client := &http.Client{Transport: w.Transport(http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	w.Close()
+
+	f, err := os.Open(warcFiles(t, dir)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got := readAll(t, f)
+	if len(got) != 4 {
+		t.Fatalf("read %d records, want warcinfo, request, response and metadata", len(got))
+	}
+	request, response, metadata := got[1], got[2], got[3]
+
+	if request.Type != TypeRequest || Method(request) != http.MethodGet {
+		t.Errorf("request record = %s %q", request.Type, Method(request))
+	}
+	if response.Type != TypeResponse || !bytes.HasSuffix(response.Block, []byte("\r\n\r\nhello")) {
+		t.Errorf("response record = %s %q", response.Type, response.Block)
+	}
+	if response.Header("WARC-Payload-Digest") != Digest([]byte("hello")) || response.Header("WARC-Truncated") != "" {
+		t.Errorf("response headers = %v", response.Headers)
+	}
+	if response.Header("WARC-Concurrent-To") != request.ID || metadata.Header("WARC-Concurrent-To") != response.ID {
+		t.Error("records are not linked by WARC-Concurrent-To")
+	}
+	for _, record := range got[1:] {
+		if record.Header("WARC-Target-URI") != server.URL+"/page" {
+			t.Errorf("%s target = %q", record.Type, record.Header("WARC-Target-URI"))
+		}
+	}
+	if !bytes.Contains(metadata.Block, []byte("attempt: 1\r\n")) {
+		t.Errorf("metadata = %q, want the attempt number", metadata.Block)
+	}
+}
+
+// warcFiles lists the WARC files in dir in the order they were written
+func warcFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,357 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Event types sent in the X-Scraper-Event header and payload
+const (
+	EventResults  = "results"
+	EventComplete = "job.completed"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the timestamp and body
+const SignatureHeader = "X-Scraper-Signature"
+
+// TimestampHeader carries the Unix time the request was signed at
+const TimestampHeader = "X-Scraper-Timestamp"
+
+// ResultsPayload is the body of a results delivery
+type ResultsPayload struct {
+	Event   string          `json:"event"`
+	JobID   string          `json:"job_id,omitempty"`
+	Batch   int             `json:"batch"`
+	Results []models.Result `json:"results"`
+}
+
+// CompletePayload is the body of the final job complete callback
+type CompletePayload struct {
+	Event  string         `json:"event"`
+	JobID  string         `json:"job_id,omitempty"`
+	Status string         `json:"status"`
+	Report *report.Report `json:"report"`
+}
+
+// deadLetter is a line of the dead-letter file
+type deadLetter struct {
+	Event    string          `json:"event"`
+	URL      string          `json:"url"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failed_at"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Deliverer POSTs results to a webhook in batches as they are produced
+type Deliverer struct {
+	Config *config.WebhookConfig
+	JobID  string
+	Client *http.Client
+
+	ctx     context.Context // Cancelling it stops retries and dead-letters what is left
+	results chan models.Result
+	batches chan ResultsPayload // Full batches on their way to the delivery goroutine
+	done    chan struct{}
+	batch   int
+
+	deadMu sync.Mutex
+}
+
+// NewDeliverer creates a new webhook deliverer and starts its batching loop.
+// Once ctx is cancelled, deliveries stop waiting on the webhook and the
+// remaining payloads go straight to the dead-letter file.
+func NewDeliverer(ctx context.Context, config *config.WebhookConfig, jobID string) *Deliverer {
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	d := &Deliverer{
+		Config:  config,
+		JobID:   jobID,
+		Client:  &http.Client{Timeout: config.Timeout},
+		ctx:     ctx,
+		results: make(chan models.Result, batchSize),
+		batches: make(chan ResultsPayload),
+		done:    make(chan struct{}),
+	}
+	go d.loop(batchSize)
+	go d.deliverBatches()
+	return d
+}
+
+// Send queues a result for delivery. It doesn't wait for deliveries, so a
+// webhook retrying with backoff doesn't hold up the caller.
+func (d *Deliverer) Send(result models.Result) {
+	d.results <- result
+}
+
+// Complete flushes the pending results and sends the job complete callback
+// carrying the run report
+func (d *Deliverer) Complete(status string, summary *report.Report) {
+	d.Close()
+
+	url := d.Config.CompleteURL
+	if url == "" {
+		url = d.Config.URL
+	}
+	d.deliver(url, EventComplete, CompletePayload{
+		Event:  EventComplete,
+		JobID:  d.JobID,
+		Status: status,
+		Report: summary,
+	})
+}
+
+// Close flushes the pending results and stops the batching loop. It is safe
+// to call more than once.
+func (d *Deliverer) Close() {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	close(d.results)
+	<-d.done
+}
+
+// loop collects results into batches, queueing a batch for delivery when it
+// is full or when the flush interval passes. Batches wait in memory while an
+// earlier one is being delivered, so results keep being accepted meanwhile.
+func (d *Deliverer) loop(batchSize int) {
+	var pending []models.Result
+	var queued []ResultsPayload
+	var flush <-chan time.Time
+	var timer *time.Timer
+
+	send := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+		if len(pending) == 0 {
+			return
+		}
+		d.batch++
+		queued = append(queued, ResultsPayload{
+			Event:   EventResults,
+			JobID:   d.JobID,
+			Batch:   d.batch,
+			Results: pending,
+		})
+		pending = nil
+	}
+
+	for {
+		// Offer the oldest queued batch to the delivery goroutine
+		var out chan<- ResultsPayload
+		var next ResultsPayload
+		if len(queued) > 0 {
+			out, next = d.batches, queued[0]
+		}
+
+		select {
+		case result, ok := <-d.results:
+			if !ok {
+				send()
+				for _, batch := range queued {
+					d.batches <- batch
+				}
+				close(d.batches)
+				return
+			}
+			pending = append(pending, result)
+			if len(pending) >= batchSize {
+				send()
+			} else if timer == nil && d.Config.FlushInterval > 0 {
+				timer = time.NewTimer(d.Config.FlushInterval)
+				flush = timer.C
+			}
+		case <-flush:
+			timer, flush = nil, nil
+			send()
+		case out <- next:
+			queued = queued[1:]
+		}
+	}
+}
+
+// deliverBatches delivers the batches in order until the batching loop stops
+func (d *Deliverer) deliverBatches() {
+	defer close(d.done)
+
+	for batch := range d.batches {
+		d.deliver(d.Config.URL, EventResults, batch)
+	}
+}
+
+// deliver POSTs a payload, retrying with exponential backoff, and writes it
+// to the dead-letter file if every attempt fails
+func (d *Deliverer) deliver(url, event string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding webhook payload", "event", event, "error", err)
+		return
+	}
+
+	var lastErr error
+	attempts := 0
+	for attempt := 0; attempt <= d.Config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			// Wait before retrying, doubling the delay each time
+			delay := d.Config.RetryDelay * time.Duration(1<<(attempt-1))
+			slog.Debug("Retrying webhook delivery",
+				"url", url,
+				"event", event,
+				"attempt", attempt,
+				"delay", delay,
+				"error", lastErr,
+			)
+			timer := time.NewTimer(delay)
+			select {
+			case <-d.ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+		if err := d.ctx.Err(); err != nil {
+			lastErr = err
+			break
+		}
+
+		attempts++
+		retry, err := d.post(url, event, body)
+		if err == nil {
+			slog.Debug("Webhook delivered", "url", url, "event", event, "attempts", attempts)
+			return
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	if d.ctx.Err() != nil {
+		slog.Warn("Webhook delivery cancelled", "url", url, "event", event, "attempts", attempts)
+	} else {
+		slog.Error("Webhook delivery failed", "url", url, "event", event, "attempts", attempts, "error", lastErr)
+	}
+	if err := d.writeDeadLetter(url, event, body, attempts, lastErr); err != nil {
+		slog.Error("Error writing webhook dead letter", "file", d.Config.DeadLetterFile, "error", err)
+	}
+}
+
+// post sends a single signed request and reports whether a failure is worth retrying
+func (d *Deliverer) post(url, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Scraper-Event", event)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	for name, value := range d.Config.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	if d.Config.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(os.ExpandEnv(d.Config.Secret), timestamp, body))
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Client errors will not succeed on retry, except for timeouts and throttling
+	err = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
+
+// writeDeadLetter appends an undeliverable payload to the dead-letter file
+func (d *Deliverer) writeDeadLetter(url, event string, body []byte, attempts int, deliveryErr error) error {
+	if d.Config.DeadLetterFile == "" {
+		return nil
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Event:    event,
+		URL:      url,
+		Error:    deliveryErr.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now(),
+		Payload:  body,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+
+	file, err := os.OpenFile(d.Config.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of timestamp + "." + body using
+// secret. Signing the timestamp keeps a captured request from being replayed
+// later with a fresh timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature header against its timestamp header
+// and body, and rejects timestamps further than tolerance from now
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %q", TimestampHeader, timestamp)
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp %s is outside the %s tolerance", timestamp, tolerance)
+	}
+
+	expected := "sha256=" + Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(expected)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
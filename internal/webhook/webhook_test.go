@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/report"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// readDeadLetters returns the events of the dead-letter file, in order
+func readDeadLetters(t *testing.T, file string) []deadLetter {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("dead letter %q: %v", scanner.Text(), err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestDeliver(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("secret", r.Header, body, time.Minute); err != nil {
+			t.Errorf("Verify() = %v", err)
+		}
+		mu.Lock()
+		events = append(events, r.Header.Get("X-Scraper-Event"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	d := NewDeliverer(context.Background(), &config.WebhookConfig{
+		URL:       server.URL,
+		Secret:    "secret",
+		BatchSize: 2,
+		Timeout:   time.Second,
+	}, "job")
+	for _, url := range []string{"https://a.example", "https://b.example", "https://c.example"} {
+		d.Send(models.Result{URL: url})
+	}
+	d.Complete("completed", &report.Report{Total: 3})
+
+	want := []string{EventResults, EventResults, EventComplete}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("events = %v, want %v", events, want)
+			break
+		}
+	}
+}
+
+func TestDeliverCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	deadLetters := filepath.Join(t.TempDir(), "dead.ndjson")
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDeliverer(ctx, &config.WebhookConfig{
+		URL:            server.URL,
+		BatchSize:      1,
+		MaxRetries:     5,
+		RetryDelay:     time.Hour,
+		Timeout:        time.Second,
+		DeadLetterFile: deadLetters,
+	}, "job")
+	d.Send(models.Result{URL: "https://a.example"})
+	d.Send(models.Result{URL: "https://b.example"})
+
+	// The first batch is now waiting an hour to retry
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan struct{})
+	go func() {
+		d.Complete("cancelled", &report.Report{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Complete() still waiting on retries after cancellation")
+	}
+
+	letters := readDeadLetters(t, deadLetters)
+	want := []string{EventResults, EventResults, EventComplete}
+	if len(letters) != len(want) {
+		t.Fatalf("%d dead letters, want %d", len(letters), len(want))
+	}
+	for i, letter := range letters {
+		if letter.Event != want[i] {
+			t.Errorf("dead letter %d is a %q event, want %q", i, letter.Event, want[i])
+		}
+	}
+	if letters[0].Attempts != 1 {
+		t.Errorf("first batch made %d attempts, want 1", letters[0].Attempts)
+	}
+}
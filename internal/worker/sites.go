@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/internal/warc"
+)
+
+// site is a per-site profile with its own configuration, scraper and rate limit
+type site struct {
+	Name    string
+	Profile *config.SiteConfig
+	Config  *config.AppConfig
+	Scraper scraper.Scraper
+
+	rateLimit time.Duration
+	limiter   *time.Ticker
+}
+
+// newSites builds the per-site profiles of the configuration, sharing the
+// cookie jar and WARC archive
+func newSites(cfg *config.AppConfig, jar *cookies.Jar, archive *warc.Writer) ([]*site, error) {
+	sites := make([]*site, 0, len(cfg.Sites))
+	for i := range cfg.Sites {
+		profile := &cfg.Sites[i]
+
+		name := profile.Name
+		if name == "" {
+			name = strings.Join(profile.Hosts, ",")
+		}
+		if name == "" {
+			name = profile.URLRegex
+		}
+
+		siteConfig, err := cfg.ForSite(profile)
+		if err != nil {
+			slog.Warn("Skipping site profile", "site", name, "error", err)
+			continue
+		}
+
+		fetcher, err := scraper.NewWithOptions(siteConfig, jar, scraper.Options{Archive: archive})
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", name, err)
+		}
+
+		s := &site{
+			Name:      name,
+			Profile:   profile,
+			Config:    siteConfig,
+			Scraper:   fetcher,
+			rateLimit: profile.RateLimit,
+		}
+		sites = append(sites, s)
+	}
+	return sites, nil
+}
+
+// resolve returns the first profile matching the URL, or nil to use the
+// global settings
+func (p *Pool) resolve(rawURL string) *site {
+	for _, s := range p.sites {
+		if s.Profile.Matches(rawURL) {
+			return s
+		}
+	}
+	return nil
+}
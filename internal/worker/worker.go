@@ -1,12 +1,21 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/williampepple1/concurrent-web-scraper/internal/canonical"
 	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/cookies"
+	"github.com/williampepple1/concurrent-web-scraper/internal/metrics"
+	"github.com/williampepple1/concurrent-web-scraper/internal/queue"
 	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/internal/simhash"
+	"github.com/williampepple1/concurrent-web-scraper/internal/warc"
 	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
 )
 
@@ -14,31 +23,114 @@ import (
 type Pool struct {
 	Config    *config.AppConfig
 	Scraper   scraper.Scraper
-	Jobs      chan string
+	Cookies   *cookies.Jar
+	Archive   *warc.Writer
+	Queue     queue.Queue // Where the workers lease URLs from
+	Sink      queue.Sink  // Where the workers put their results
+	Canonical *canonical.Canonicalizer
+	NearDups  *simhash.Detector // nil unless near-duplicate detection is enabled
 	Results   chan models.Result
 	WaitGroup *sync.WaitGroup
+
+	// Context stops the workers from picking up new URLs once it is cancelled
+	Context context.Context
+
+	// Budget optionally limits concurrent fetches across several pools; each
+	// worker holds a slot while fetching
+	Budget chan struct{}
+
+	// DeferFlags leaves flagging duplicates to whoever collects the results,
+	// which with a shared queue is the only one to see those of every process
+	DeferFlags bool
+
+	// sites are the per-site profiles, resolved for every URL
+	sites []*site
+
+	// canonicals maps each canonical URL to the first result that had it
+	canonicals map[string]string
+	mu         sync.Mutex
 }
 
-// NewPool creates a new worker pool
-func NewPool(config *config.AppConfig, urls []string) *Pool {
-	jobs := make(chan string, len(urls))
+// NewPool creates a new worker pool that takes its URLs from an in-process
+// queue and sends its results to the Results channel. It fails if a scraper
+// can't be built from the configuration.
+func NewPool(config *config.AppConfig, urls []string) (*Pool, error) {
 	results := make(chan models.Result, len(urls))
 	wg := &sync.WaitGroup{}
 
+	// Share a single cookie jar across all workers; logins need one to keep their session
+	var jar *cookies.Jar
+	if config.Cookies.Enabled || config.Auth.Enabled {
+		jar = cookies.NewJar()
+	}
+
+	// Share a single WARC writer too, so every exchange lands in the same archive
+	var archive *warc.Writer
+	if config.WARC.Enabled {
+		archive = warc.NewWriter(&config.WARC)
+	}
+
+	var nearDups *simhash.Detector
+	if config.NearDup.Enabled {
+		nearDups = simhash.NewDetector(&config.NearDup)
+	}
+
+	fetcher, err := scraper.NewWithOptions(config, jar, scraper.Options{Archive: archive})
+	if err != nil {
+		return nil, err
+	}
+	sites, err := newSites(config, jar, archive)
+	if err != nil {
+		return nil, err
+	}
+	if archive != nil && usesBrowser(config, sites) {
+		slog.Warn("Pages fetched with the browser are not archived; WARC files only hold plain HTTP exchanges")
+	}
+
 	return &Pool{
 		Config:    config,
-		Scraper:   scraper.New(config),
-		Jobs:      jobs,
+		Scraper:   fetcher,
+		Cookies:   jar,
+		Archive:   archive,
+		Queue:     queue.NewMemory(len(urls)),
+		Sink:      queue.ChannelSink(results),
+		Canonical: canonical.NewCanonicalizer(&config.Canonical),
+		NearDups:  nearDups,
 		Results:   results,
 		WaitGroup: wg,
+		Context:   context.Background(),
+		sites:     sites,
+
+		canonicals: make(map[string]string),
+	}, nil
+}
+
+// UseScraper replaces the scrapers of the pool and of every site profile
+// with the ones built by newScraper from their configuration
+func (p *Pool) UseScraper(newScraper func(config *config.AppConfig) (scraper.Scraper, error)) error {
+	fetcher, err := newScraper(p.Config)
+	if err != nil {
+		return err
+	}
+	p.Scraper = fetcher
+	for _, s := range p.sites {
+		if s.Scraper, err = newScraper(s.Config); err != nil {
+			return fmt.Errorf("site %s: %w", s.Name, err)
+		}
 	}
+	return nil
 }
 
 // Start starts the worker pool
 func (p *Pool) Start() {
-	// Create a rate limiter
+	// Create a rate limiter, plus one for each site with its own rate limit;
+	// they are stopped once all workers are done
 	rateLimiter := time.NewTicker(p.Config.Scraper.RateLimit)
-	defer rateLimiter.Stop()
+	for _, s := range p.sites {
+		if s.rateLimit > 0 {
+			s.limiter = time.NewTicker(s.rateLimit)
+		}
+	}
 
 	// Start workers
 	for w := 1; w <= p.Config.Scraper.Workers; w++ {
@@ -49,28 +141,215 @@ func (p *Pool) Start() {
 	// Start a goroutine to close the results channel when all workers are done
 	go func() {
 		p.WaitGroup.Wait()
+		rateLimiter.Stop()
+		if p.Archive != nil {
+			if err := p.Archive.Close(); err != nil {
+				slog.Warn("Error closing WARC archive", "error", err)
+			}
+		}
+		for _, s := range p.sites {
+			if s.limiter != nil {
+				s.limiter.Stop()
+			}
+		}
 		close(p.Results)
 	}()
 }
 
-// worker processes URLs from the jobs channel and sends results to the results channel
+// worker processes URLs leased from the queue and puts their results in the sink
 func (p *Pool) worker(id int, rateLimiter *time.Ticker) {
 	defer p.WaitGroup.Done()
 
-	for url := range p.Jobs {
-		// Wait for rate limiter
-		<-rateLimiter.C
+	for {
+		job, err := p.Queue.Lease(p.Context)
+		if err != nil {
+			if !errors.Is(err, queue.ErrDrained) && p.Context.Err() == nil {
+				slog.Error("Error leasing a job", "worker_id", id, "error", err)
+			}
+			return
+		}
+
+		// Hold on to the lease while waiting and fetching
+		stopRenewing := p.renew(job)
+
+		// Use the site profile matching the URL, if any
+		fetcher, limiter := p.Scraper, rateLimiter.C
+		profile := p.resolve(job.URL)
+		if profile != nil {
+			fetcher = profile.Scraper
+			if profile.limiter != nil {
+				limiter = profile.limiter.C
+			}
+		}
+
+		// Wait for rate limiter; once cancelled, drain the remaining URLs
+		select {
+		case <-limiter:
+		case <-p.Context.Done():
+			stopRenewing()
+			continue
+		}
 
-		fmt.Printf("Worker %d processing URL: %s\n", id, url)
-		result := p.Scraper.Fetch(url)
-		p.Results <- result
+		// Wait for a slot in the shared concurrency budget
+		if p.Budget != nil {
+			select {
+			case p.Budget <- struct{}{}:
+			case <-p.Context.Done():
+				stopRenewing()
+				continue
+			}
+		}
+
+		metrics.ActiveWorkers.Inc()
+
+		if profile != nil {
+			slog.Debug("Processing URL", "worker_id", id, "url", job.URL, "site", profile.Name)
+		} else {
+			slog.Debug("Processing URL", "worker_id", id, "url", job.URL)
+		}
+		result := fetcher.Fetch(job.URL)
+		if profile != nil {
+			result.Site = profile.Name
+		}
+		p.canonicalize(&result)
+		if !p.DeferFlags {
+			p.Flag(&result)
+		}
+
+		metrics.ActiveWorkers.Dec()
+		if p.Budget != nil {
+			<-p.Budget
+		}
+		observe(result)
+		stopRenewing()
+
+		// Store the result even if the run was cancelled meanwhile; a job
+		// that isn't acknowledged is fetched again once its lease runs out
+		if err := p.Sink.Put(context.Background(), job, result); err != nil {
+			slog.Error("Error storing result", "worker_id", id, "url", job.URL, "error", err)
+			continue
+		}
+		if err := p.Queue.Ack(context.Background(), job); err != nil {
+			slog.Warn("Error acknowledging job", "worker_id", id, "url", job.URL, "error", err)
+		}
 	}
 }
 
-// AddJobs adds URLs to the jobs channel
+// canonicalize records the result's canonical URL. The page is fetched as
+// given; the canonical form only identifies it.
+func (p *Pool) canonicalize(result *models.Result) {
+	cfg := p.Canonical.Config
+	if !cfg.Enabled {
+		return
+	}
+	result.CanonicalURL = p.Canonical.Canonicalize(result.URL)
+	if result.Err != "" {
+		return
+	}
+	if cfg.ResolveTag {
+		if tag := p.Canonical.FromPage(*result); tag != "" {
+			result.CanonicalURL = tag
+		}
+	}
+}
+
+// Flag marks the result as a duplicate of an earlier one with the same
+// canonical URL or, with near-duplicate detection, nearly the same text
+func (p *Pool) Flag(result *models.Result) {
+	p.flagCanonical(result)
+	if p.NearDups != nil {
+		p.NearDups.Observe(result)
+	}
+}
+
+// flagCanonical sets duplicate_of when an earlier result had the same canonical URL
+func (p *Pool) flagCanonical(result *models.Result) {
+	if cfg := p.Canonical.Config; !cfg.Enabled || !cfg.Deduplicate || result.Err != "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The canonical page itself is never a duplicate of one of its aliases
+	first, seen := p.canonicals[result.CanonicalURL]
+	switch {
+	case p.Canonical.Canonicalize(result.URL) == result.CanonicalURL, !seen:
+		p.canonicals[result.CanonicalURL] = result.URL
+	default:
+		result.DuplicateOf = first
+	}
+}
+
+// renew keeps renewing the job's lease until the returned function is called
+func (p *Pool) renew(job *queue.Job) func() {
+	if job.Lease <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(job.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := p.Queue.Renew(context.Background(), job); err != nil {
+				slog.Warn("Error renewing lease", "url", job.URL, "error", err)
+				if errors.Is(err, queue.ErrLeaseLost) {
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// AddJobs adds URLs to the queue, dropping the ones whose canonical form is
+// already in it, and seals it to signal workers that no more
+// jobs are coming
 func (p *Pool) AddJobs(urls []string) {
-	for _, url := range urls {
-		p.Jobs <- url
+	// Queue each canonical URL once
+	if kept := p.Canonical.Dedupe(urls); len(kept) < len(urls) {
+		slog.Info("Dropped duplicate URLs", "duplicates", len(urls)-len(kept), "urls", len(kept))
+		urls = kept
+	}
+
+	if err := p.Queue.Push(p.Context, urls...); err != nil {
+		slog.Error("Error queueing URLs", "error", err)
+	}
+	if err := p.Queue.Seal(p.Context); err != nil {
+		slog.Error("Error sealing the queue", "error", err)
+	}
+}
+
+// usesBrowser reports whether the configuration or any site profile fetches
+// pages with the browser, always or as a hybrid fallback
+func usesBrowser(config *config.AppConfig, sites []*site) bool {
+	if config.Browser.Enabled || config.Hybrid.Enabled {
+		return true
+	}
+	for _, s := range sites {
+		if s.Config.Browser.Enabled || s.Config.Hybrid.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// observe records the metrics for a finished fetch
+func observe(result models.Result) {
+	host := metrics.Host(result.URL)
+
+	outcome := "success"
+	if result.Err != "" {
+		outcome = "failure"
+	}
+	metrics.FetchDuration.WithLabelValues(host, outcome).Observe(result.Duration.Seconds())
+
+	if result.Size > 0 {
+		metrics.BytesDownloaded.WithLabelValues(host).Add(float64(result.Size))
 	}
-	close(p.Jobs) // Close the jobs channel to signal workers that no more jobs are coming
 }
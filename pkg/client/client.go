@@ -0,0 +1,345 @@
+// Package client is the public Go API of the scraper. It wraps the worker
+// pool and scrapers behind a builder so that other programs can embed the
+// scraper instead of running the binary:
+//
+//	c, err := client.NewBuilder().
+//		Workers(4).
+//		RateLimit(500 * time.Millisecond).
+//		Selector("title", "title").
+//		Build()
+//	if err != nil {
+//		return err
+//	}
+//	for result := range c.Run(ctx, urls) {
+//		fmt.Println(result.URL, result.Extracted["title"])
+//	}
+package client
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/internal/worker"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// Fetcher fetches a single URL. It replaces the built-in HTTP and browser
+// scrapers when set on the builder.
+type Fetcher interface {
+	Fetch(url string) models.Result
+}
+
+// FetcherFunc adapts a function to the Fetcher interface
+type FetcherFunc func(url string) models.Result
+
+// Fetch calls f(url)
+func (f FetcherFunc) Fetch(url string) models.Result {
+	return f(url)
+}
+
+// Extractor extracts data from a parsed HTML page into Result.Extracted
+type Extractor interface {
+	Extract(doc *goquery.Document) map[string]interface{}
+}
+
+// ExtractorFunc adapts a function to the Extractor interface
+type ExtractorFunc func(doc *goquery.Document) map[string]interface{}
+
+// Extract calls f(doc)
+func (f ExtractorFunc) Extract(doc *goquery.Document) map[string]interface{} {
+	return f(doc)
+}
+
+// Sink receives every result as it is produced
+type Sink interface {
+	Write(result models.Result) error
+	Close() error
+}
+
+// Middleware wraps a fetcher to add behaviour around Fetch
+type Middleware func(next Fetcher) Fetcher
+
+// Builder configures a Client
+type Builder struct {
+	config     *config.AppConfig
+	fetcher    Fetcher
+	extractor  Extractor
+	sinks      []Sink
+	hooks      scraper.Hooks
+	middleware []scraper.Middleware
+	err        error
+}
+
+// NewBuilder creates a builder with the same defaults as the command-line tool
+func NewBuilder() *Builder {
+	cfg := config.Defaults()
+	cfg.Progress.Enabled = false
+	return &Builder{config: cfg}
+}
+
+// ConfigFile replaces the builder's configuration with a YAML configuration
+// file. Options set afterwards are applied on top of it.
+func (b *Builder) ConfigFile(path string) *Builder {
+	cfg, err := config.Load(path)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.config = cfg
+	return b
+}
+
+// Workers sets the number of concurrent workers
+func (b *Builder) Workers(n int) *Builder {
+	b.config.Scraper.Workers = n
+	return b
+}
+
+// RateLimit sets the delay between requests
+func (b *Builder) RateLimit(d time.Duration) *Builder {
+	b.config.Scraper.RateLimit = d
+	return b
+}
+
+// Retries sets the maximum number of retries per URL and the base delay between them
+func (b *Builder) Retries(n int, delay time.Duration) *Builder {
+	b.config.Scraper.MaxRetries = n
+	b.config.Scraper.RetryDelay = delay
+	return b
+}
+
+// Timeout sets the per-request timeout
+func (b *Builder) Timeout(d time.Duration) *Builder {
+	b.config.Scraper.Timeout = d
+	return b
+}
+
+// UserAgents sets the user agents to rotate between
+func (b *Builder) UserAgents(agents ...string) *Builder {
+	b.config.Scraper.UserAgents = agents
+	return b
+}
+
+// Selector adds a CSS selector whose text is extracted into the named field
+func (b *Builder) Selector(name, selector string) *Builder {
+	if b.config.Extraction.Selectors == nil {
+		b.config.Extraction.Selectors = make(map[string]string)
+	}
+	b.config.Extraction.Selectors[name] = selector
+	return b
+}
+
+// Regex adds a regular expression whose matches are extracted into the named field
+func (b *Builder) Regex(name, pattern string) *Builder {
+	if b.config.Extraction.Regex == nil {
+		b.config.Extraction.Regex = make(map[string]string)
+	}
+	b.config.Extraction.Regex[name] = pattern
+	return b
+}
+
+// ClearSelectors removes the default title and heading selectors
+func (b *Builder) ClearSelectors() *Builder {
+	b.config.Extraction.Selectors = make(map[string]string)
+	b.config.Extraction.Regex = make(map[string]string)
+	return b
+}
+
+// Proxies routes requests through the given proxy URLs, rotating between
+// them on retries when rotate is set
+func (b *Builder) Proxies(rotate bool, proxies ...string) *Builder {
+	b.config.Proxies.Enabled = len(proxies) > 0
+	b.config.Proxies.Rotate = rotate
+	b.config.Proxies.List = proxies
+	return b
+}
+
+// Browser renders pages in headless Chrome instead of fetching them over HTTP
+func (b *Builder) Browser(enabled bool) *Builder {
+	b.config.Browser.Enabled = enabled
+	return b
+}
+
+// HeadOnly records status and headers without downloading bodies
+func (b *Builder) HeadOnly(enabled bool) *Builder {
+	b.config.Scraper.HeadOnly = enabled
+	return b
+}
+
+// Fetcher replaces the built-in scrapers with a custom fetcher. Only the
+// OnError and OnResult hooks and the middleware apply to it.
+func (b *Builder) Fetcher(f Fetcher) *Builder {
+	b.fetcher = f
+	return b
+}
+
+// Extractor replaces the configured selectors and patterns with a custom extractor
+func (b *Builder) Extractor(e Extractor) *Builder {
+	b.extractor = e
+	return b
+}
+
+// Sink adds a sink that receives every result
+func (b *Builder) Sink(s Sink) *Builder {
+	b.sinks = append(b.sinks, s)
+	return b
+}
+
+// Use adds middleware around every fetch. The first middleware added is the
+// outermost; all of them run inside the URL filter from the configuration.
+func (b *Builder) Use(middlewares ...Middleware) *Builder {
+	for _, mw := range middlewares {
+		mw := mw
+		b.middleware = append(b.middleware, func(next scraper.Scraper) scraper.Scraper {
+			return mw(next)
+		})
+	}
+	return b
+}
+
+// BeforeRequest adds a hook that can modify each HTTP request, for example to
+// add headers or sign it. Returning an error fails the fetch.
+func (b *Builder) BeforeRequest(hook func(req *http.Request) error) *Builder {
+	b.hooks.BeforeRequest = append(b.hooks.BeforeRequest, hook)
+	return b
+}
+
+// AfterResponse adds a hook that sees each HTTP response before its body is
+// read. Returning an error fails the attempt, which is retried.
+func (b *Builder) AfterResponse(hook func(resp *http.Response) error) *Builder {
+	b.hooks.AfterResponse = append(b.hooks.AfterResponse, hook)
+	return b
+}
+
+// OnError adds a hook that is called with every failed result
+func (b *Builder) OnError(hook func(result models.Result)) *Builder {
+	b.hooks.OnError = append(b.hooks.OnError, hook)
+	return b
+}
+
+// OnResult adds a hook that can modify every result before it is delivered
+func (b *Builder) OnResult(hook func(result *models.Result)) *Builder {
+	b.hooks.OnResult = append(b.hooks.OnResult, hook)
+	return b
+}
+
+// Build validates the options and creates the client
+func (b *Builder) Build() (*Client, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.config.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Copy the configuration so that later builder calls don't affect the client
+	cfg, err := b.config.Merge(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		config:     cfg,
+		fetcher:    b.fetcher,
+		extractor:  b.extractor,
+		sinks:      b.sinks,
+		hooks:      b.hooks,
+		middleware: b.middleware,
+	}
+
+	// Build the scrapers once so that an unusable configuration fails here
+	// rather than in Run
+	if _, err := c.newPool(nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Client runs scrapes with a fixed configuration. It is safe to call Run
+// several times, including concurrently.
+type Client struct {
+	config     *config.AppConfig
+	fetcher    Fetcher
+	extractor  Extractor
+	sinks      []Sink
+	hooks      scraper.Hooks
+	middleware []scraper.Middleware
+}
+
+// Run scrapes the URLs and streams the results on the returned channel,
+// which is closed once every URL is done. Cancelling ctx stops workers from
+// picking up new URLs; fetches already in flight still finish. The channel
+// must be drained.
+func (c *Client) Run(ctx context.Context, urls []string) <-chan models.Result {
+	out := make(chan models.Result)
+
+	// Build already created a pool from the same configuration successfully
+	pool, err := c.newPool(urls)
+	if err != nil {
+		slog.Error("Error creating worker pool", "error", err)
+		close(out)
+		return out
+	}
+	pool.Context = ctx
+
+	pool.Start()
+	pool.AddJobs(urls)
+
+	go func() {
+		defer close(out)
+		for result := range pool.Results {
+			for _, sink := range c.sinks {
+				if err := sink.Write(result); err != nil {
+					slog.Error("Error writing result to sink", "url", result.URL, "error", err)
+				}
+			}
+			out <- result
+		}
+	}()
+
+	return out
+}
+
+// newPool creates a worker pool for the URLs using the client's fetcher or
+// its scrapers with the custom extractor, hooks and middleware
+func (c *Client) newPool(urls []string) (*worker.Pool, error) {
+	pool, err := worker.NewPool(c.config, urls)
+	if err != nil {
+		return nil, err
+	}
+	if c.fetcher != nil {
+		// A custom fetcher only gets the result hooks and the middleware
+		fetcher := scraper.Chain(c.fetcher, append([]scraper.Middleware{c.hooks.Middleware()}, c.middleware...)...)
+		err = pool.UseScraper(func(*config.AppConfig) (scraper.Scraper, error) { return fetcher, nil })
+	} else {
+		err = pool.UseScraper(func(cfg *config.AppConfig) (scraper.Scraper, error) {
+			return scraper.NewWithOptions(cfg, pool.Cookies, scraper.Options{
+				Extractor:  c.extractor,
+				Hooks:      c.hooks,
+				Middleware: c.middleware,
+				Archive:    pool.Archive,
+			})
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Close closes the client's sinks
+func (c *Client) Close() error {
+	var errs []error
+	for _, sink := range c.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,313 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/config"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+func TestBuildErrors(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("scraper:\n  wokers: 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		builder *Builder
+		path    string // Path of the expected validation problem, if any
+	}{
+		{name: "no workers", builder: NewBuilder().Workers(0), path: "scraper.workers"},
+		{name: "no rate limit", builder: NewBuilder().RateLimit(0), path: "scraper.rate_limit"},
+		{name: "negative timeout", builder: NewBuilder().Timeout(-time.Second), path: "scraper.timeout"},
+		{name: "negative retries", builder: NewBuilder().Retries(-1, time.Second), path: "scraper.max_retries"},
+		{name: "invalid selector", builder: NewBuilder().Selector("price", "div[")},
+		{name: "invalid regex", builder: NewBuilder().Regex("id", "(")},
+		{name: "missing config file", builder: NewBuilder().ConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))},
+		{name: "invalid config file", builder: NewBuilder().ConfigFile(invalid), path: "scraper.wokers"},
+		{
+			name:    "config file error kept past later options",
+			builder: NewBuilder().ConfigFile(invalid).Workers(2),
+			path:    "scraper.wokers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.builder.Build()
+			if err == nil {
+				t.Fatalf("Build() = %v, want an error", c)
+			}
+			if tt.path == "" {
+				return
+			}
+			var verr *config.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Build() = %v, want a *config.ValidationError", err)
+			}
+			found := false
+			for _, p := range verr.Problems {
+				found = found || p.Path == tt.path
+			}
+			if !found {
+				t.Errorf("Build() = %v, want a problem at %s", err, tt.path)
+			}
+		})
+	}
+}
+
+func TestBuildCopiesConfig(t *testing.T) {
+	b := NewBuilder().Workers(2).RateLimit(time.Millisecond)
+	c, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Workers(9).Selector("later", "p")
+
+	if c.config.Scraper.Workers != 2 {
+		t.Errorf("workers = %d, want 2 as built", c.config.Scraper.Workers)
+	}
+	if _, ok := c.config.Extraction.Selectors["later"]; ok {
+		t.Error("selector added after Build() reached the client")
+	}
+}
+
+// collect drains a run, failing the test if it doesn't finish
+func collect(t *testing.T, results <-chan models.Result) map[string]models.Result {
+	t.Helper()
+	byURL := make(map[string]models.Result)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return byURL
+			}
+			byURL[result.URL] = result
+		case <-timeout:
+			t.Fatal("Run() did not close its channel")
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head><body><h1>Heading</h1><p>id=42</p></body></html>", r.URL.Path)
+	}))
+	defer site.Close()
+
+	filter, err := FilterURLs(nil, []string{`/private`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sunk bytes.Buffer
+	var mu sync.Mutex
+	var failed []string
+	c, err := NewBuilder().
+		Workers(3).
+		RateLimit(time.Millisecond).
+		Retries(0, time.Millisecond).
+		Regex("id", `id=(\d+)`).
+		BeforeRequest(InjectHeaders(map[string]string{"X-Token": "secret"})).
+		OnResult(Enrich(map[string]string{"run": "test"})).
+		OnError(func(result models.Result) {
+			mu.Lock()
+			failed = append(failed, result.URL)
+			mu.Unlock()
+		}).
+		Use(filter).
+		Sink(NewJSONLinesSink(&sunk)).
+		Build()
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	urls := []string{site.URL + "/a", site.URL + "/b", site.URL + "/c", site.URL + "/missing", site.URL + "/private"}
+	results := collect(t, c.Run(context.Background(), urls))
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want one per URL (%d)", len(results), len(urls))
+	}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		result := results[site.URL+path]
+		if result.Err != "" {
+			t.Errorf("%s failed: %s", path, result.Err)
+			continue
+		}
+		if result.Extracted["title"] != path || result.Extracted["heading"] != "Heading" {
+			t.Errorf("%s extracted %v", path, result.Extracted)
+		}
+		if result.Metadata["run"] != "test" {
+			t.Errorf("%s metadata = %v, want the enriched values", path, result.Metadata)
+		}
+	}
+	if result := results[site.URL+"/missing"]; result.StatusCode != http.StatusNotFound || result.Err == "" {
+		t.Errorf("/missing = %d %q, want a failed 404", result.StatusCode, result.Err)
+	}
+	if result := results[site.URL+"/private"]; result.ErrorClass != "filtered" {
+		t.Errorf("/private error class = %q, want filtered", result.ErrorClass)
+	}
+
+	mu.Lock()
+	sort.Strings(failed)
+	mu.Unlock()
+	if want := []string{site.URL + "/missing", site.URL + "/private"}; strings.Join(failed, " ") != strings.Join(want, " ") {
+		t.Errorf("OnError saw %v, want %v", failed, want)
+	}
+
+	// The sink got every result before it was delivered
+	lines := 0
+	for scanner := bufio.NewScanner(&sunk); scanner.Scan(); lines++ {
+		var result models.Result
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Errorf("sink line %q: %v", scanner.Text(), err)
+		}
+	}
+	if lines != len(urls) {
+		t.Errorf("sink got %d results, want %d", lines, len(urls))
+	}
+}
+
+func TestRunFetcher(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Fetcher) Fetcher {
+			return FetcherFunc(func(url string) models.Result {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return next.Fetch(url)
+			})
+		}
+	}
+
+	sinkErrors := 0
+	c, err := NewBuilder().
+		Workers(1).
+		RateLimit(time.Millisecond).
+		Fetcher(FetcherFunc(func(url string) models.Result {
+			return models.Result{URL: url, StatusCode: http.StatusOK, Extracted: map[string]interface{}{"fetched": true}}
+		})).
+		Use(trace("outer"), trace("inner")).
+		Sink(SinkFunc(func(models.Result) error {
+			sinkErrors++
+			return errors.New("sink unavailable")
+		})).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs are independent, and a failing sink doesn't stop the results
+	for run := 0; run < 2; run++ {
+		results := collect(t, c.Run(context.Background(), []string{"https://a.example", "https://b.example"}))
+		if len(results) != 2 {
+			t.Fatalf("run %d got %d results, want 2", run, len(results))
+		}
+		for url, result := range results {
+			if result.Extracted["fetched"] != true {
+				t.Errorf("%s = %+v, want the custom fetcher's result", url, result)
+			}
+		}
+	}
+	if sinkErrors != 4 {
+		t.Errorf("sink called %d times, want 4", sinkErrors)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i+1 < len(order); i += 2 {
+		if order[i] != "outer" || order[i+1] != "inner" {
+			t.Fatalf("middleware ran in order %v, want outer before inner", order)
+		}
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	var fetched sync.Map
+	c, err := NewBuilder().
+		Workers(1).
+		RateLimit(time.Millisecond).
+		Fetcher(FetcherFunc(func(url string) models.Result {
+			fetched.Store(url, true)
+			time.Sleep(20 * time.Millisecond)
+			return models.Result{URL: url}
+		})).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	urls := make([]string, 50)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := c.Run(ctx, urls)
+	<-results
+	cancel()
+
+	// The channel still closes, without fetching the rest
+	rest := collect(t, results)
+	count := 0
+	fetched.Range(func(any, any) bool { count++; return true })
+	if count >= len(urls) || len(rest)+1 >= len(urls) {
+		t.Errorf("fetched %d of %d URLs after cancelling", count, len(urls))
+	}
+}
+
+func TestJSONLinesSinkClose(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "results.jsonl")
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewJSONLinesSink(f)
+	for _, url := range []string{"https://a.example", "https://b.example"} {
+		if err := sink.Write(models.Result{URL: url}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Close() left the file open")
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("wrote %d lines, want 2", lines)
+	}
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/williampepple1/concurrent-web-scraper/internal/scraper"
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// InjectHeaders returns a BeforeRequest hook that sets the headers on every
+// request. Values may reference environment variables as ${VAR}.
+func InjectHeaders(headers map[string]string) func(req *http.Request) error {
+	return scraper.InjectHeaders(headers)
+}
+
+// FilterURLs returns a middleware that only fetches URLs matching one of the
+// allow regexes (all if empty) and none of the deny regexes. Dropped URLs come
+// back as failed results with the "filtered" error class.
+func FilterURLs(allow, deny []string) (Middleware, error) {
+	filter, err := scraper.FilterURLs(allow, deny)
+	if err != nil {
+		return nil, err
+	}
+	return func(next Fetcher) Fetcher {
+		return filter(next)
+	}, nil
+}
+
+// Enrich returns an OnResult hook that adds the values, plus the URL's host,
+// to each result's metadata
+func Enrich(values map[string]string) func(result *models.Result) {
+	return scraper.Enrich(values)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/williampepple1/concurrent-web-scraper/pkg/models"
+)
+
+// JSONLinesSink writes each result as a line of JSON
+type JSONLinesSink struct {
+	mu      sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONLinesSink creates a sink that writes newline-delimited JSON to w.
+// Closing the sink closes w if it is an io.Closer.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w, encoder: json.NewEncoder(w)}
+}
+
+// Write encodes the result as one line
+func (s *JSONLinesSink) Write(result models.Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(result)
+}
+
+// Close closes the underlying writer if it is an io.Closer
+func (s *JSONLinesSink) Close() error {
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SinkFunc adapts a function to the Sink interface; closing it does nothing
+type SinkFunc func(result models.Result) error
+
+// Write calls f(result)
+func (f SinkFunc) Write(result models.Result) error {
+	return f(result)
+}
+
+// Close does nothing
+func (f SinkFunc) Close() error {
+	return nil
+}
@@ -1,20 +1,69 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
 // Result represents the result of scraping a URL
 type Result struct {
-	URL        string                 `json:"url"`
-	Content    string                 `json:"content,omitempty"`
-	Extracted  map[string]interface{} `json:"extracted,omitempty"`
-	Err        string                 `json:"error,omitempty"`
-	Duration   time.Duration          `json:"duration"`
-	Retries    int                    `json:"retries"`
-	StatusCode int                    `json:"status_code,omitempty"`
-	Timestamp  time.Time              `json:"timestamp"`
-	Screenshot string                 `json:"screenshot,omitempty"`
-	JSRendered bool                   `json:"js_rendered,omitempty"`
-	ProxyUsed  string                 `json:"proxy_used,omitempty"`
+	URL         string                 `json:"url"`
+	Content     string                 `json:"content,omitempty"`
+	Extracted   map[string]interface{} `json:"extracted,omitempty"`
+	Err         string                 `json:"error,omitempty"`
+	ErrorClass  string                 `json:"error_class,omitempty"`
+	Duration    time.Duration          `json:"duration"`
+	Retries     int                    `json:"retries"`
+	StatusCode  int                    `json:"status_code,omitempty"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Screenshot  string                 `json:"screenshot,omitempty"`
+	JSRendered  bool                   `json:"js_rendered,omitempty"`
+	ProxyUsed   string                 `json:"proxy_used,omitempty"`
+	Encoding    string                 `json:"encoding,omitempty"`
+	ContentType string                 `json:"content_type,omitempty"`
+	ContentHash string                 `json:"content_hash,omitempty"`
+	Items       []FeedItem             `json:"items,omitempty"`
+	Metadata    map[string]string      `json:"metadata,omitempty"`
+	Download    string                 `json:"download,omitempty"`
+	Size        int64                  `json:"size,omitempty"`
+	Headers     map[string]string      `json:"headers,omitempty"`
+	Site        string                 `json:"site,omitempty"`
+	FetchPath   string                 `json:"fetch_path,omitempty"` // "http" or "browser"
+	Escalation  string                 `json:"escalation,omitempty"` // Why the hybrid scraper used the browser
+
+	CanonicalURL string `json:"canonical_url,omitempty"` // The page's rel=canonical URL, or the canonical form of URL when it has none
+	DuplicateOf  string `json:"duplicate_of,omitempty"`  // An earlier result of the run with the same canonical URL
+
+	SimHash         string  `json:"simhash,omitempty"`           // 64-bit SimHash of the page's main text, in hex
+	NearDuplicateOf string  `json:"near_duplicate_of,omitempty"` // An earlier result of the run with nearly the same text
+	Similarity      float64 `json:"similarity,omitempty"`        // Share of SimHash bits shared with near_duplicate_of
+}
+
+// IsEmpty reports whether an extracted value is missing or blank
+func IsEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []string:
+		for _, item := range v {
+			if strings.TrimSpace(item) != "" {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// FeedItem represents an entry of an RSS or Atom feed
+type FeedItem struct {
+	Title     string `json:"title,omitempty"`
+	Link      string `json:"link,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Published string `json:"published,omitempty"`
+	Summary   string `json:"summary,omitempty"`
+	Author    string `json:"author,omitempty"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "testing"
+
+func TestIsEmpty(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{nil, true},
+		{"", true},
+		{" \n\t", true},
+		{"title", false},
+		{[]string{}, true},
+		{[]string{"", " "}, true},
+		{[]string{"", "item"}, false},
+		{0, false},
+		{map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		if got := IsEmpty(tt.value); got != tt.want {
+			t.Errorf("IsEmpty(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}